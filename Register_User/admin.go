@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"shubam/handlers"
+	"shubam/store"
+)
+
+const adminUsage = `usage: %s set-role EMAIL ROLE
+       %s reset-2fa EMAIL
+       %s hash-passwords
+
+  set-role   give a user one of the roles %s
+  reset-2fa  remove a user's two-factor authentication and recovery codes and
+             end their sessions, e.g. after they lost their device; staff
+             must enrol again at their next login
+  hash-passwords
+             hash the passwords still stored in plaintext, those of accounts
+             created before passwords were hashed that have not logged in
+             since
+`
+
+// adminCommand runs the administrative subcommand in args.
+func adminCommand(ctx context.Context, pg *store.Postgres, program string, args []string, out io.Writer) error {
+	usage := func() error {
+		fmt.Fprintf(out, adminUsage, program, program, program, strings.Join(store.Roles, ", "))
+		return fmt.Errorf("invalid arguments to %s", args[0])
+	}
+
+	switch args[0] {
+	case "set-role":
+		if len(args) != 3 {
+			return usage()
+		}
+		role := args[2]
+		if !slices.Contains(store.Roles, role) {
+			return usage()
+		}
+		user, err := pg.UserByEmail(ctx, handlers.NormalizeEmail(args[1]))
+		if err != nil {
+			return userError(err)
+		}
+		if err := pg.SetRole(ctx, user.ID, role); err != nil {
+			return err
+		}
+		slog.Info("Admin changed user role", "user_id", user.ID, "from", user.Role, "to", role)
+		fmt.Fprintf(out, "User %d is now %s.\n", user.ID, role)
+		return nil
+
+	case "reset-2fa":
+		if len(args) != 2 {
+			return usage()
+		}
+		user, err := pg.UserByEmail(ctx, handlers.NormalizeEmail(args[1]))
+		if err != nil {
+			return userError(err)
+		}
+		if err := pg.DisableTOTP(ctx, user.ID); err != nil {
+			return err
+		}
+		if err := pg.DeleteUserSessions(ctx, user.ID); err != nil {
+			return err
+		}
+		slog.Info("Admin reset two-factor authentication", "user_id", user.ID)
+		fmt.Fprintf(out, "Two-factor authentication of user %d removed and their sessions ended.\n", user.ID)
+		return nil
+
+	case "hash-passwords":
+		if len(args) != 1 {
+			return usage()
+		}
+		users, err := pg.UsersWithPlaintextPasswords(ctx)
+		if err != nil {
+			return err
+		}
+		hashed := 0
+		for _, user := range users {
+			hash, err := handlers.HashPassword(user.PasswordHash)
+			if err != nil {
+				return err
+			}
+			// A password reset or login since the query already hashed it.
+			err = pg.RehashPassword(ctx, user.ID, user.PasswordHash, hash)
+			if errors.Is(err, store.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			hashed++
+		}
+		slog.Info("Admin hashed plaintext passwords", "users", hashed)
+		fmt.Fprintf(out, "Hashed the passwords of %d users.\n", hashed)
+		return nil
+
+	default:
+		fmt.Fprintf(out, adminUsage, program, program, program, strings.Join(store.Roles, ", "))
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func userError(err error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return errors.New("no user with that email")
+	}
+	return err
+}
@@ -3,545 +3,321 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"shubam/auth"
+	"shubam/breaker"
+	"shubam/certs"
+	"shubam/config"
+	"shubam/email"
+	"shubam/handlers"
+	"shubam/logging"
+	"shubam/metrics"
+	"shubam/migrations"
 	pb "shubam/proto"
+	pharmacypb "shubam/proto/pharmacy"
+	pbv2 "shubam/proto/v2"
+	"shubam/schedule"
+	"shubam/store"
+	"shubam/tracing"
 
-	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
 )
 
-type RegisterPageData struct {
-	Error string
-}
+func initDB(cfg config.DBConfig) (*sql.DB, error) {
+	db, err := tracing.OpenDB(cfg.ConnString())
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the database: %w", err)
+	}
 
-type PageData struct {
-	UserID   string
-	UserEmail string
-	Appointments []Appointment
-}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+	defer cancel()
+	errPing := db.PingContext(ctx)
+	if errPing != nil {
+		db.Close()
+		return nil, fmt.Errorf("error pinging the database: %w", errPing)
+	}
 
-type Appointment struct {
-	ID         int
-    DoctorName string
-    Degree     string
-    Experience string
-    Date       string
-    Time       string
+	slog.Info("Connected to the database", "db", cfg.String())
+	return db, nil
 }
 
-type Doctor struct {
-    ID            int      `json:"id"`
-    Name          string   `json:"name"`
-    Specialty     string   `json:"specialty"`
-    Experience    string   `json:"experience"`
-    PhotoURL      string   `json:"photo_url"`
-    AvailableSlots []string `json:"available_slots"`
+// rpcTimeout gives every call on a connection a deadline of at most d, so
+// a slow or unreachable service fails the request instead of holding it
+// until the HTTP write timeout. An earlier deadline in ctx still applies.
+func rpcTimeout(d time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
 }
 
-var db *sql.DB
-var appointmentClient pb.HospitalServiceClient
-var pharmacyClient pb.HospitalServiceClient
+// Service configs for the two connections. Only idempotent methods are
+// retried, and only when the server could not be reached: every v2 method
+// is, since Appointment carries an idempotency key, and so is the
+// pharmacy's PlaceOrder, whose orders carry the web server's order ID.
+const (
+	appointmentServiceConfig = `{
+	"methodConfig": [{
+		"name": [{"service": "hospital.v2.HospitalService"}],
+		"retryPolicy": {
+			"maxAttempts": 4,
+			"initialBackoff": "0.1s",
+			"maxBackoff": "1s",
+			"backoffMultiplier": 2,
+			"retryableStatusCodes": ["UNAVAILABLE"]
+		}
+	}]
+}`
+	pharmacyServiceConfig = `{
+	"methodConfig": [{
+		"name": [{"service": "hospital.pharmacy.v1.PharmacyService"}],
+		"retryPolicy": {
+			"maxAttempts": 4,
+			"initialBackoff": "0.1s",
+			"maxBackoff": "1s",
+			"backoffMultiplier": 2,
+			"retryableStatusCodes": ["UNAVAILABLE"]
+		}
+	}]
+}`
+)
 
-func initDB() error {
-	err := godotenv.Load(".env")
-	if err != nil {
-		return fmt.Errorf("error loading .env file: %w", err)
-	}
+// After breakerThreshold consecutive calls to a service fail as
+// unreachable, calls to it fail fast for breakerCooldown.
+const (
+	breakerThreshold = 5
+	breakerCooldown  = 30 * time.Second
+)
 
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_NAME"))
+// clientKeepalive pings idle connections so a dead server or a dropped
+// NAT mapping is noticed before a patient's request is sent on it. The
+// appointment server's enforcement policy allows this rate.
+var clientKeepalive = keepalive.ClientParameters{
+	Time:                30 * time.Second,
+	Timeout:             10 * time.Second,
+	PermitWithoutStream: true,
+}
 
-	db, err = sql.Open("postgres", connStr)
-	if err != nil {
-		return fmt.Errorf("error connecting to the database: %w", err)
-	}
+// dial creates a client for the service at addr. The connection is made
+// lazily and re-established in the background, so an unreachable service
+// does not stop the web server from starting.
+// Calls are authenticated with a token addressed to the service's
+// audience.
+func dial(cfg *config.Config, creds credentials.TransportCredentials, addr, audience, serviceConfig string, interceptors ...grpc.UnaryClientInterceptor) (*grpc.ClientConn, error) {
+	chain := append([]grpc.UnaryClientInterceptor{
+		rpcTimeout(cfg.RPCTimeout),
+		logging.UnaryClientInterceptor,
+		metrics.UnaryClientInterceptor,
+		breaker.New(breakerThreshold, breakerCooldown).UnaryClientInterceptor,
+	}, interceptors...)
+	return grpc.NewClient(addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithPerRPCCredentials(auth.Credentials{
+			Key:      []byte(cfg.ServiceTokenKey),
+			Service:  "web",
+			Audience: audience,
+			Secure:   cfg.TLS.Mode != "off",
+		}),
+		grpc.WithDefaultServiceConfig(serviceConfig),
+		grpc.WithKeepaliveParams(clientKeepalive),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithChainUnaryInterceptor(chain...),
+	)
+}
 
-	errPing := db.Ping()
-	if errPing != nil {
-		return fmt.Errorf("error pinging the database: %w", errPing)
+// initGRPC sets up the clients of the appointment and pharmacy services in
+// opts. The returned appointment and pharmacy connections must be closed on
+// shutdown.
+func initGRPC(cfg *config.Config, opts *handlers.Options) (*grpc.ClientConn, *grpc.ClientConn, error) {
+	creds, err := certs.ClientCredentials(cfg.TLS, "web")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error setting up TLS: %w", err)
 	}
 
-	log.Println("Successfully connected to the database")
-	return nil
-}
-
-func initGRPC() error {
-	appointmentConn, err := grpc.Dial("localhost:5001", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	appointmentConn, err := dial(cfg, creds, cfg.AppointmentAddr, "appointment-server", appointmentServiceConfig)
 	if err != nil {
-		return fmt.Errorf("did not connect to appointment service: %w", err)
+		return nil, nil, fmt.Errorf("error creating appointment service client: %w", err)
 	}
-	appointmentClient = pb.NewHospitalServiceClient(appointmentConn)
-	log.Println("Successfully connected to the appointment gRPC server")
+	opts.AppointmentClient = pbv2.NewHospitalServiceClient(appointmentConn)
+	opts.AppointmentClientV1 = pb.NewHospitalServiceClient(appointmentConn)
+	slog.Info("Created appointment gRPC client", "addr", cfg.AppointmentAddr, "tls", cfg.TLS.Mode)
 
-	pharmacyConn, err := grpc.Dial("localhost:5002", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	pharmacyConn, err := dial(cfg, creds, cfg.PharmacyAddr, "pharmacy-server", pharmacyServiceConfig,
+		metrics.CountCalls(pharmacypb.PharmacyService_PlaceOrder_FullMethodName, metrics.PharmacyOrders))
 	if err != nil {
-		return fmt.Errorf("did not connect to pharmacy service: %w", err)
+		appointmentConn.Close()
+		return nil, nil, fmt.Errorf("error creating pharmacy service client: %w", err)
 	}
-	pharmacyClient = pb.NewHospitalServiceClient(pharmacyConn)
-	log.Println("Successfully connected to the pharmacy gRPC server")
+	opts.PharmacyClient = pharmacypb.NewPharmacyServiceClient(pharmacyConn)
+	slog.Info("Created pharmacy gRPC client", "addr", cfg.PharmacyAddr, "tls", cfg.TLS.Mode)
 
-	return nil
+	return appointmentConn, pharmacyConn, nil
 }
 
-func registerHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+func main() {
+	cfg, args, err := config.Load(config.Web, os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
 		return
 	}
-
-	err := r.ParseForm()
 	if err != nil {
-		http.Error(w, "Parse form error", http.StatusInternalServerError)
-		return
+		logging.Fatal("Error loading configuration", "error", err)
 	}
+	if err := logging.Setup(os.Stderr, cfg.LogLevel); err != nil {
+		logging.Fatal("Error setting up logging", "error", err)
+	}
+	slog.Info("Effective configuration", "config", cfg)
 
-	email := r.FormValue("email")
-	password := r.FormValue("password")
-
-	var exists bool
-	err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE email=$1)", email).Scan(&exists)
+	shutdownTracing, err := tracing.Setup(context.Background(), "web", cfg.TraceExporter, cfg.TraceEndpoint)
 	if err != nil {
-		http.Error(w, "Server error", http.StatusInternalServerError)
-		return
-	}
-	if exists {
-		errorMessage := "Email already exists"
-		http.Redirect(w, r, "/register.html?error="+errorMessage, http.StatusSeeOther)
-		return
+		logging.Fatal("Error setting up tracing", "error", err)
 	}
 
-	var id int
-	err = db.QueryRow("INSERT INTO users (email, password) VALUES ($1, $2) RETURNING id", email, password).Scan(&id)
+	db, err := initDB(cfg.DB)
 	if err != nil {
-		log.Printf("Error inserting user into database: %v\n", err)
-		http.Error(w, "Server error", http.StatusInternalServerError)
-		return
+		logging.Fatal("Error initializing database", "error", err)
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:  "userID",
-		Value: fmt.Sprintf("%d", id),
-		Path:  "/",
-	})
-	http.SetCookie(w, &http.Cookie{
-		Name:  "userEmail",
-		Value: email,
-		Path:  "/",
-	})
-
-	http.Redirect(w, r, "/login", http.StatusSeeOther)
-}
-
-func serviceHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		tmpl, err := template.ParseFiles("Static/service.html")
+	if len(args) > 0 && args[0] == "migrate" {
+		err = migrations.Command(context.Background(), db, cfg.HospitalTimezone, os.Args[0], args[1:], os.Stdout)
 		if err != nil {
-			http.Error(w, "Error loading service page", http.StatusInternalServerError)
-			log.Printf("Error loading service page: %v\n", err)
-			return
+			logging.Fatal("Error running migrations", "error", err)
 		}
-		tmpl.Execute(w, nil)
 		return
 	}
-	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-}
-
-func loginHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		tmpl, err := template.ParseFiles("Static/login.html")
+	if len(args) > 0 {
+		err = adminCommand(context.Background(), store.NewPostgres(db), os.Args[0], args, os.Stdout)
 		if err != nil {
-			http.Error(w, "Error loading login page", http.StatusInternalServerError)
-			log.Printf("Error loading login page: %v\n", err)
-			return
+			logging.Fatal("Error running command", "command", args[0], "error", err)
 		}
-		tmpl.Execute(w, nil)
 		return
 	}
-
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
+	if cfg.DB.AutoMigrate {
+		err = migrations.Up(context.Background(), db, migrations.Options{Out: os.Stdout, TimeZone: cfg.HospitalTimezone})
+		if err != nil {
+			logging.Fatal("Error applying migrations", "error", err)
+		}
+	} else if err := migrations.Check(context.Background(), db); err != nil {
+		logging.Fatal("Error checking database schema", "error", err)
 	}
 
-	email := r.FormValue("email")
-	password := r.FormValue("password")
-
-	var dbPassword string
-	var userID int
-	err := db.QueryRow("SELECT id, password FROM users WHERE email = $1", email).Scan(&userID, &dbPassword)
+	location, err := schedule.LoadLocation(cfg.HospitalTimezone)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("No user found with email: %s\n", email)
-			http.Error(w, "Invalid email or password", http.StatusUnauthorized)
-		} else {
-			log.Printf("Error querying database: %v\n", err)
-			http.Error(w, "Server error", http.StatusInternalServerError)
-		}
-		return
+		logging.Fatal("Error initializing time zone", "error", err)
 	}
+	slog.Info("Using hospital time zone", "location", location.String())
 
-	if password != dbPassword {
-		log.Printf("Password mismatch for user: %s\n", email)
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
-		return
+	if err := metrics.RegisterDB(db, cfg.DB.Name); err != nil {
+		logging.Fatal("Error registering database metrics", "error", err)
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:  "userID",
-		Value: fmt.Sprintf("%d", userID),
-		Path:  "/",
-	})
-	http.SetCookie(w, &http.Cookie{
-		Name:  "userEmail",
-		Value: email,
-		Path:  "/",
-	})
-
-	http.Redirect(w, r, "/service", http.StatusSeeOther)
-}
-
-func appointmentHandler(w http.ResponseWriter, r *http.Request) {
-    log.Println("AppointmentHandler called")  // Debug log
-
-    userIDCookie, err := r.Cookie("userID")
-    if err != nil {
-        http.Error(w, "Missing user ID", http.StatusBadRequest)
-        return
-    }
-    userEmailCookie, err := r.Cookie("userEmail")
-    if err != nil {
-        http.Error(w, "Missing user email", http.StatusBadRequest)
-        return
-    }
-
-    log.Println("Cookies:", userIDCookie, userEmailCookie)  // Debug log
-
-    if r.Method == http.MethodGet {
-        tmpl, err := template.ParseFiles("Static/appointment.html")
-        if err != nil {
-            log.Printf("Error parsing appointment template: %v\n", err)
-            http.Error(w, "Error loading appointment page", http.StatusInternalServerError)
-            return
-        }
-
-        data := struct {
-            UserID    string
-            UserEmail string
-        }{
-            UserID:    userIDCookie.Value,
-            UserEmail: userEmailCookie.Value,
-        }
-
-        tmpl.Execute(w, data)
-        return
-    }
-
-    if r.Method == http.MethodPost {
-        err := r.ParseForm()
-        if err != nil {
-            http.Error(w, "Parse form error", http.StatusInternalServerError)
-            return
-        }
-
-        doctorName := r.FormValue("doctor")
-        date := r.FormValue("date")
-        time := r.FormValue("time")
-
-        if date == "" {
-            http.Error(w, "Date is required", http.StatusBadRequest)
-            return
-        }
-
-        if time == "" {
-            http.Error(w, "Time is required", http.StatusBadRequest)
-            return
-        }
-
-        // Add debug log for form values
-        log.Println("Form values:", doctorName, date, time)
-
-        req := &pb.AppointmentRequest{
-            DoctorName: doctorName,
-            UserId:     userIDCookie.Value,
-            Email:      userEmailCookie.Value,
-            Date:       date,
-            Time:       time,
-        }
-
-        log.Println("AppointmentClient:", appointmentClient)  // Debug log
-
-        resp, err := appointmentClient.Appointment(context.Background(), req)
-        if err != nil {
-            log.Printf("Failed to create appointment: %v", err)
-            http.Error(w, "Failed to create appointment", http.StatusInternalServerError)
-            return
-        }
-
-        log.Println(resp.Message)
-        http.Redirect(w, r, "/profile", http.StatusSeeOther)
-    }
-}
-
-func bookedSlotsHandler(w http.ResponseWriter, r *http.Request) {
-    doctorName := r.URL.Query().Get("doctor")
-    if doctorName == "" {
-        http.Error(w, "Doctor name is required", http.StatusBadRequest)
-        return
-    }
-
-    query := "SELECT date, time FROM appointments WHERE doctor_name = $1 AND status = 'BOOKED'"
-    rows, err := db.Query(query, doctorName)
-    if err != nil {
-        http.Error(w, fmt.Sprintf("Failed to fetch booked slots: %v", err), http.StatusInternalServerError)
-        log.Printf("Error fetching booked slots: %v", err)
-        return
-    }
-    defer rows.Close()
-
-    bookedSlots := make(map[string][]string)
-    for rows.Next() {
-        var slotDate, time string
-        err := rows.Scan(&slotDate, &time)
-        if err != nil {
-            http.Error(w, fmt.Sprintf("Failed to scan row: %v", err), http.StatusInternalServerError)
-            log.Printf("Failed to scan row: %v", err)
-            return
-        }
-        bookedSlots[slotDate] = append(bookedSlots[slotDate], time)
-    }
-
-    log.Println("Booked slots fetched")
-
-    w.Header().Set("Content-Type", "application/json")
-    err = json.NewEncoder(w).Encode(bookedSlots)
-    if err != nil {
-        http.Error(w, fmt.Sprintf("Error encoding booked slots: %v", err), http.StatusInternalServerError)
-        log.Printf("Error encoding booked slots: %v", err)
-        return
-    }
-
-    log.Println("Booked slots sent")
-}
-
-func formatDate(date string) string {
-    t, _ := time.Parse(time.RFC3339, date)
-    return t.Format("2006-01-02")
-}
-
-func formatTime(timeStr string) string {
-    t, _ := time.Parse(time.RFC3339, timeStr)
-    return t.Format("15:04")
-}
-
-func profileHandler(w http.ResponseWriter, r *http.Request) {
-    userIDCookie, err := r.Cookie("userID")
-    if err != nil {
-        http.Error(w, "Missing user ID", http.StatusBadRequest)
-        return
-    }
-    userEmailCookie, err := r.Cookie("userEmail")
-    if err != nil {
-        http.Error(w, "Missing user email", http.StatusBadRequest)
-        return
-    }
-
-    userID := userIDCookie.Value
-    userEmail := userEmailCookie.Value
-    query := `
-        SELECT id, doctor_name, date, time 
-        FROM appointments 
-        WHERE user_id = $1 AND date >= CURRENT_DATE
-        ORDER BY date, time`
-
-    rows, err := db.Query(query, userID)
-    if err != nil {
-        log.Printf("Error querying appointments: %v\n", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
-        return
-    }
-    defer rows.Close()
-
-    var appointments []Appointment
-    for rows.Next() {
-        var appointment Appointment
-        if err := rows.Scan(&appointment.ID, &appointment.DoctorName, &appointment.Date, &appointment.Time); err != nil {
-            log.Printf("Error scanning appointment: %v\n", err)
-            http.Error(w, "Server error", http.StatusInternalServerError)
-            return
-        }
-        appointment.Date = formatDate(appointment.Date)
-        appointment.Time = formatTime(appointment.Time)
-        appointments = append(appointments, appointment)
-    }
-
-    if err := rows.Err(); err != nil {
-        log.Printf("Error iterating appointments: %v\n", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
-        return
-    }
-
-    data := PageData{
-        UserID:       userID,
-        UserEmail:    userEmail,
-        Appointments: appointments,
-    }
-
-    tmpl, err := template.ParseFiles("Static/profile.html")
-    if err != nil {
-        log.Printf("Error loading profile page template: %v\n", err)
-        http.Error(w, "Error loading profile page", http.StatusInternalServerError)
-        return
-    }
-
-    tmpl.Execute(w, data)
-}
-
-func cancelHandler(w http.ResponseWriter, r *http.Request) {
-    log.Print("cancel called")
-
-    var req struct {
-        ID string `json:"id"` // Change ID to string type
-    }
-
-    // Decode the request body
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        log.Printf("Invalid request body: %v\n", err)
-        http.Error(w, "Invalid request body", http.StatusBadRequest)
-        return
-    }
-
-    log.Printf("Decoded request: %+v\n", req)
-
-    // Convert ID from string to int
-    appointmentID, err := strconv.Atoi(req.ID)
-    if err != nil {
-        log.Printf("Error converting ID to integer: %v\n", err)
-        http.Error(w, "Invalid appointment ID", http.StatusBadRequest)
-        return
-    }
-
-    // Execute the database query
-    query := `DELETE FROM appointments WHERE id = $1`
-    result, err := db.Exec(query, appointmentID)
-    if err != nil {
-        log.Printf("Error cancelling appointment: %v\n", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
-        return
-    }
-
-    // Check the number of affected rows
-    rowsAffected, err := result.RowsAffected()
-    if err != nil {
-        log.Printf("Error fetching rows affected: %v\n", err)
-        http.Error(w, "Server error", http.StatusInternalServerError)
-        return
-    }
-
-    if rowsAffected == 0 {
-        log.Printf("No appointment found with ID: %d\n", appointmentID)
-        http.Error(w, "Appointment not found", http.StatusNotFound)
-        return
-    }
-
-    log.Printf("Appointment with ID %d cancelled successfully\n", appointmentID)
-    w.WriteHeader(http.StatusOK)
-}
-
-func pharmacyHandler(w http.ResponseWriter, r *http.Request) {
-	userIDCookie, err := r.Cookie("userID")
+	mailer, err := email.New(cfg.Mail)
 	if err != nil {
-		http.Error(w, "Missing user ID", http.StatusBadRequest)
-		return
-	}
-	userEmailCookie, err := r.Cookie("userEmail")
-	if err != nil {
-		http.Error(w, "Missing user email", http.StatusBadRequest)
-		return
+		logging.Fatal("Error initializing mailer", "error", err)
 	}
 
-	data := PageData{
-		UserID:   userIDCookie.Value,
-		UserEmail: userEmailCookie.Value,
+	pg := store.NewPostgres(db)
+	opts := handlers.Options{
+		Users:          pg,
+		Appointments:   metrics.InstrumentAppointments(pg),
+		Doctors:        pg,
+		LoginAttempts:  pg,
+		Verifications:  pg,
+		Sessions:       pg,
+		PasswordResets: pg,
+		TwoFactor:      pg,
+		Prescriptions:  pg,
+		PharmacyOrders: pg,
+		Mailer:         mailer,
+		PublicURL:      cfg.PublicURL,
+		LinkKey:        []byte(cfg.LinkKey),
+		Location:       location,
+		TemplateDir:    cfg.TemplateDir,
+		// Nothing can be written back after the write timeout, so stop
+		// the work behind a request then too.
+		RequestTimeout: cfg.HTTPWriteTimeout,
 	}
 
-	tmpl, err := template.ParseFiles("Static/appointment.html")
-if err != nil {
-    log.Printf("Error parsing pharmacy template: %v\n", err)
-    http.Error(w, "Error loading pharmacy page", http.StatusInternalServerError)
-    return
-}
-log.Println("Pharmacy called")
-
-	tmpl.Execute(w, data)
-}
-
-
-func inventoryHandler(w http.ResponseWriter, r *http.Request) {
-	userIDCookie, err := r.Cookie("userID")
+	appointmentConn, pharmacyConn, err := initGRPC(cfg, &opts)
 	if err != nil {
-		http.Error(w, "Missing user ID", http.StatusBadRequest)
-		return
+		logging.Fatal("Error initializing gRPC client", "error", err)
 	}
-	userEmailCookie, err := r.Cookie("userEmail")
+
+	opts.ReadinessChecks = []handlers.ReadinessCheck{
+		{Name: "database", Critical: true, Check: db.PingContext},
+		{Name: "appointment", Critical: true, Check: handlers.GRPCHealthCheck(appointmentConn, pbv2.HospitalService_ServiceDesc.ServiceName)},
+		// Only pharmacy orders depend on the pharmacy service, so it is
+		// reported but does not take the server out of rotation.
+		{Name: "pharmacy", Check: handlers.GRPCHealthCheck(pharmacyConn, "")},
+	}
+
+	s, err := handlers.New(opts)
 	if err != nil {
-		http.Error(w, "Missing user email", http.StatusBadRequest)
-		return
+		logging.Fatal("Error initializing handlers", "error", err)
 	}
 
-	data := PageData{
-		UserID:   userIDCookie.Value,
-		UserEmail: userEmailCookie.Value,
+	srv := &http.Server{
+		Addr:         cfg.HTTPAddr,
+		Handler:      s.Routes(),
+		ReadTimeout:  cfg.HTTPReadTimeout,
+		WriteTimeout: cfg.HTTPWriteTimeout,
+		IdleTimeout:  cfg.HTTPIdleTimeout,
 	}
 
-	tmpl, err := template.ParseFiles("Static/pharmacy.html")
-if err != nil {
-    log.Printf("Error parsing pharmacy template: %v\n", err)
-    http.Error(w, "Error loading pharmacy page", http.StatusInternalServerError)
-    return
-}
-log.Println("Inventory called")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	tmpl.Execute(w, data)
-}
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Starting server", "addr", cfg.HTTPAddr)
+		serveErr <- srv.ListenAndServe()
+	}()
 
-func main() {
-	err := initDB()
-	if err != nil {
-		log.Fatalf("Error initializing database: %v", err)
+	select {
+	case err := <-serveErr:
+		logging.Fatal("Error serving HTTP", "error", err)
+	case <-ctx.Done():
 	}
-
-	err = initGRPC()
-	if err != nil {
-		log.Fatalf("Error initializing gRPC client: %v", err)
+	stop()
+
+	// Fail /readyz and keep serving for DRAIN_DELAY, so load balancers
+	// stop sending new requests before the listener closes. Then stop
+	// accepting connections and let in-flight requests, such as a booking
+	// waiting on the appointment service, finish before the clients and
+	// pool they use are closed. A second signal exits at once.
+	s.Drain()
+	slog.Info("Draining, waiting for load balancers to stop routing here", "delay", cfg.DrainDelay)
+	time.Sleep(cfg.DrainDelay)
+	slog.Info("Shutting down, waiting for in-flight requests", "timeout", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error shutting down HTTP server", "error", err)
 	}
 
-	fileServer := http.FileServer(http.Dir("Static"))
-	http.Handle("/", fileServer)
-
-	http.HandleFunc("/register", registerHandler)
-	http.HandleFunc("/login", loginHandler)
-	http.HandleFunc("/service", serviceHandler)
-	http.HandleFunc("/appointment", appointmentHandler)
-	http.HandleFunc("/pharmacy", pharmacyHandler)
-	http.HandleFunc("/bookedSlots", bookedSlotsHandler)
-	http.HandleFunc("/cancel", cancelHandler)
-	http.HandleFunc("/profile", profileHandler)
-	http.HandleFunc("/inventory", inventoryHandler)
-
-	fmt.Printf("Starting server at port 8080\n")
-	log.Fatal(http.ListenAndServe(":8080", nil))
-}
\ No newline at end of file
+	for _, conn := range []*grpc.ClientConn{appointmentConn, pharmacyConn} {
+		if err := conn.Close(); err != nil {
+			slog.Error("Error closing gRPC connection", "target", conn.Target(), "error", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		slog.Error("Error closing database", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+	slog.Info("Server stopped")
+}
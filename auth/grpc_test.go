@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	intercept := UnaryServerInterceptor(Keys{Service: serviceKey, Operator: operatorKey}, "appointment-server", "grpc.health.v1.Health")
+	fresh := func(key []byte, id Identity) string {
+		return "Bearer " + mustSign(t, key, id, "appointment-server", time.Now())
+	}
+	ann := Identity{Service: "web", UserID: "7", Email: "ann@example.com"}
+
+	tests := []struct {
+		name   string
+		method string
+		auth   []string // authorization metadata values; nil sends no metadata
+		want   codes.Code
+		id     Identity // the identity the handler sees, if it runs
+	}{
+		{"no metadata", "/hospital.v2.HospitalService/Appointment", nil, codes.Unauthenticated, Identity{}},
+		{"no bearer prefix", "/hospital.v2.HospitalService/Appointment", []string{mustSign(t, serviceKey, ann, "appointment-server", time.Now())}, codes.Unauthenticated, Identity{}},
+		{"two tokens", "/hospital.v2.HospitalService/Appointment", []string{fresh(serviceKey, ann), fresh(serviceKey, ann)}, codes.Unauthenticated, Identity{}},
+		{"garbage token", "/hospital.v2.HospitalService/Appointment", []string{"Bearer not-a-token"}, codes.Unauthenticated, Identity{}},
+		{"unknown key", "/hospital.v2.HospitalService/Appointment", []string{fresh([]byte("another key"), ann)}, codes.Unauthenticated, Identity{}},
+		{"expired token", "/hospital.v2.HospitalService/Appointment", []string{"Bearer " + mustSign(t, serviceKey, ann, "appointment-server", time.Now().Add(-time.Hour))}, codes.Unauthenticated, Identity{}},
+		{"service token", "/hospital.v2.HospitalService/Appointment", []string{fresh(serviceKey, ann)}, codes.OK, ann},
+		{"operator token", "/hospital.v2.HospitalService/ListAppointments", []string{fresh(operatorKey, Identity{Service: OperatorService})}, codes.OK, Identity{Service: OperatorService, Operator: true}},
+		{"public service without a token", "/grpc.health.v1.Health/Check", nil, codes.OK, Identity{}},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.auth != nil {
+			ctx = metadata.NewIncomingContext(ctx, metadata.MD{"authorization": tt.auth})
+		}
+		var got Identity
+		handler := func(ctx context.Context, req any) (any, error) {
+			got, _ = FromContext(ctx)
+			return "ok", nil
+		}
+		_, err := intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+		if status.Code(err) != tt.want || got != tt.id {
+			t.Errorf("%s: %v with identity %+v, want %s with %+v", tt.name, err, got, tt.want, tt.id)
+		}
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	creds := Credentials{Key: serviceKey, Service: "web", Audience: "appointment-server"}
+	md, err := creds.GetRequestMetadata(WithPatient(context.Background(), "7", "ann@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	intercept := UnaryServerInterceptor(Keys{Service: serviceKey}, "appointment-server")
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.New(md))
+	var got Identity
+	_, err = intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/hospital.v2.HospitalService/Appointment"}, func(ctx context.Context, req any) (any, error) {
+		got, _ = FromContext(ctx)
+		return nil, nil
+	})
+	want := Identity{Service: "web", UserID: "7", Email: "ann@example.com"}
+	if err != nil || got != want {
+		t.Errorf("identity from the credentials' token = %+v, %v, want %+v", got, err, want)
+	}
+}
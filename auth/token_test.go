@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	serviceKey  = []byte("test-service-key-of-at-least-32-bytes")
+	operatorKey = []byte("test-operator-key-of-at-least-32-bytes")
+	now         = time.Unix(1_700_000_000, 0)
+)
+
+// segments encodes a token's header and claims.
+func segments(hdr string, c claims) string {
+	payload, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString([]byte(hdr)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// forge builds a token from any header and claims, signing it with key
+// the way Sign does.
+func forge(key []byte, hdr string, c claims) string {
+	signed := segments(hdr, c)
+	return signed + "." + sign(key, signed)
+}
+
+func mustSign(t *testing.T, key []byte, id Identity, audience string, at time.Time) string {
+	t.Helper()
+	token, err := Sign(key, id, audience, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestVerify(t *testing.T) {
+	ann := Identity{Service: "web", UserID: "7", Email: "ann@example.com"}
+	valid := mustSign(t, serviceKey, ann, "appointment-server", now)
+	good := claims{Issuer: "web", Audience: "appointment-server", Subject: "7", IssuedAt: now.Unix(), ExpiresAt: now.Add(tokenTTL).Unix()}
+	parts := strings.Split(valid, ".")
+	bob := good
+	bob.Subject = "8"
+	changed := segments(`{"alg":"HS256","typ":"JWT"}`, bob)
+
+	tests := []struct {
+		name  string
+		token string
+		at    time.Time
+		want  error // nil for ann's identity
+	}{
+		{"valid", valid, now, nil},
+		{"signed with another key", mustSign(t, []byte("another key"), ann, "appointment-server", now), now, ErrInvalidToken},
+		{"forged signature", parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(make([]byte, 32)), now, ErrInvalidToken},
+		{"claims changed after signing", changed + "." + parts[2], now, ErrInvalidToken},
+		{"alg none", segments(`{"alg":"none","typ":"JWT"}`, good) + ".", now, ErrInvalidToken},
+		{"alg HS512", forge(serviceKey, `{"alg":"HS512","typ":"JWT"}`, good), now, ErrInvalidToken},
+		{"wrong audience", mustSign(t, serviceKey, ann, "pharmacy-server", now), now, ErrInvalidToken},
+		{"no issuer", mustSign(t, serviceKey, Identity{UserID: "7"}, "appointment-server", now), now, ErrInvalidToken},
+		{"malformed", "not.a-token", now, ErrInvalidToken},
+		{"expired within the leeway", valid, now.Add(tokenTTL + leeway), nil},
+		{"expired", valid, now.Add(tokenTTL + leeway + time.Second), ErrExpiredToken},
+		{"issued in the future within the leeway", valid, now.Add(-leeway), nil},
+		{"issued in the future", valid, now.Add(-leeway - time.Second), ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		id, err := Verify(serviceKey, tt.token, "appointment-server", tt.at)
+		if tt.want == nil {
+			if err != nil || id != ann {
+				t.Errorf("%s: Verify = %+v, %v, want %+v", tt.name, id, err, ann)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: Verify = %+v, %v, want %v", tt.name, id, err, tt.want)
+		}
+	}
+}
+
+func TestKeysVerify(t *testing.T) {
+	both := Keys{Service: serviceKey, Operator: operatorKey}
+	serviceOnly := Keys{Service: serviceKey}
+	operator := Identity{Service: OperatorService}
+	tests := []struct {
+		name     string
+		keys     Keys
+		token    string
+		operator bool
+		want     error
+	}{
+		{"service token", both, mustSign(t, serviceKey, Identity{Service: "web"}, "appointment-server", now), false, nil},
+		{"service key naming hospitalctl", both, mustSign(t, serviceKey, operator, "appointment-server", now), false, nil},
+		{"operator token", both, mustSign(t, operatorKey, operator, "appointment-server", now), true, nil},
+		{"operator token without an operator key", serviceOnly, mustSign(t, operatorKey, operator, "appointment-server", now), false, ErrInvalidToken},
+		{"expired operator token", both, mustSign(t, operatorKey, operator, "appointment-server", now.Add(-time.Hour)), false, ErrExpiredToken},
+		{"operator token for another audience", both, mustSign(t, operatorKey, operator, "pharmacy-server", now), false, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		id, err := tt.keys.verify(tt.token, "appointment-server", now)
+		if !errors.Is(err, tt.want) || (err == nil && id.Operator != tt.operator) {
+			t.Errorf("%s: verify = %+v, %v, want operator %v, error %v", tt.name, id, err, tt.operator, tt.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Package breaker implements a circuit breaker for gRPC clients. After
+// enough consecutive calls fail because the server is unreachable, calls
+// fail fast for a cooldown period instead of each waiting out its
+// deadline; then a single probe call decides whether to close again.
+package breaker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrOpen is returned for calls rejected while the breaker is open. Its
+// code is Unavailable, like the failures that opened it.
+var ErrOpen = status.Error(codes.Unavailable, "circuit breaker open: service unavailable")
+
+type state int
+
+const (
+	closed state = iota
+	open
+	halfOpen
+)
+
+// Breaker trips after threshold consecutive failures and stays open for
+// cooldown. The zero value is not usable; use New.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time // time.Now, replaced in tests
+
+	mu       sync.Mutex
+	state    state
+	failures int
+	openedAt time.Time
+}
+
+// New returns a closed breaker.
+func New(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports whether a call may go ahead, moving an open breaker whose
+// cooldown has passed to half-open and letting that one probe through.
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case open:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = halfOpen
+		return true
+	case halfOpen:
+		// A probe is already in flight.
+		return false
+	}
+	return true
+}
+
+// record updates the breaker with the outcome of an allowed call.
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !isFailure(err) {
+		b.state = closed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == halfOpen || b.failures >= b.threshold {
+		b.state = open
+		b.openedAt = b.now()
+	}
+}
+
+// isFailure reports whether err means the server could not be reached or
+// did not answer in time. Application errors such as InvalidArgument say
+// the server is healthy.
+func isFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// UnaryClientInterceptor fails calls with ErrOpen while the breaker is
+// open. It should sit outside any retrying, which gRPC does below the
+// interceptors, so one call counts once however many attempts it made.
+func (b *Breaker) UnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if !b.allow() {
+		return ErrOpen
+	}
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	// A call the caller abandoned says nothing about the server.
+	if ctx.Err() != nil && status.Code(err) != codes.DeadlineExceeded {
+		b.mu.Lock()
+		if b.state == halfOpen {
+			b.state = open
+		}
+		b.mu.Unlock()
+		return err
+	}
+	b.record(err)
+	return err
+}
@@ -0,0 +1,147 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	unavailable = status.Error(codes.Unavailable, "connection refused")
+	invalid     = status.Error(codes.InvalidArgument, "bad request")
+)
+
+// fakeBreaker returns a breaker whose clock is *now.
+func fakeBreaker(threshold int, cooldown time.Duration, now *time.Time) *Breaker {
+	b := New(threshold, cooldown)
+	b.now = func() time.Time { return *now }
+	return b
+}
+
+// call makes a call through b that ends with err, reporting whether it
+// reached the server.
+func call(ctx context.Context, b *Breaker, err error) (invoked bool, got error) {
+	got = b.UnaryClientInterceptor(ctx, "/hospital.v2.HospitalService/Appointment", nil, nil, nil,
+		func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error {
+			invoked = true
+			return err
+		})
+	return invoked, got
+}
+
+func TestBreakerStates(t *testing.T) {
+	now := time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC)
+	b := fakeBreaker(3, 10*time.Second, &now)
+	ctx := context.Background()
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		err     error
+		invoked bool
+	}{
+		{"closed, first failure", 0, unavailable, true},
+		{"closed, second failure", 0, unavailable, true},
+		{"an application error resets the count", 0, invalid, true},
+		{"closed, first failure again", 0, unavailable, true},
+		{"closed, second failure again", 0, unavailable, true},
+		{"third failure opens", 0, unavailable, true},
+		{"open", 0, nil, false},
+		{"open until the cooldown ends", 10*time.Second - time.Nanosecond, nil, false},
+		{"half-open probe fails", time.Nanosecond, unavailable, true},
+		{"open again", 5 * time.Second, nil, false},
+		{"cooldown restarted by the probe", 5*time.Second - time.Nanosecond, nil, false},
+		{"half-open probe succeeds", time.Nanosecond, nil, true},
+		{"closed", 0, unavailable, true},
+		{"closed, failures counted from zero", 0, unavailable, true},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		invoked, err := call(ctx, b, step.err)
+		want := step.err
+		if !step.invoked {
+			want = ErrOpen
+		}
+		if invoked != step.invoked || !errors.Is(err, want) {
+			t.Errorf("%s: invoked %v with %v, want invoked %v with %v", step.name, invoked, err, step.invoked, want)
+		}
+	}
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	now := time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC)
+	b := fakeBreaker(1, time.Second, &now)
+	ctx := context.Background()
+	call(ctx, b, unavailable)
+	now = now.Add(time.Second)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- b.UnaryClientInterceptor(ctx, "/m", nil, nil, nil, func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	if invoked, err := call(ctx, b, nil); invoked || err != ErrOpen {
+		t.Errorf("call during the probe: invoked %v with %v, want rejected", invoked, err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if invoked, err := call(ctx, b, nil); !invoked || err != nil {
+		t.Errorf("call after the probe succeeded: invoked %v with %v, want it to go through", invoked, err)
+	}
+}
+
+func TestBreakerAbandonedProbe(t *testing.T) {
+	now := time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC)
+	b := fakeBreaker(1, time.Second, &now)
+	call(context.Background(), b, unavailable)
+	now = now.Add(time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if invoked, _ := call(ctx, b, status.Error(codes.Canceled, "canceled")); !invoked {
+		t.Fatal("the probe was not let through")
+	}
+	// The abandoned probe neither closes the breaker nor restarts the
+	// cooldown, so the next call probes again.
+	if invoked, err := call(context.Background(), b, nil); !invoked || err != nil {
+		t.Errorf("call after an abandoned probe: invoked %v with %v, want a new probe", invoked, err)
+	}
+}
+
+func TestIsFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{status.Error(codes.Unavailable, ""), true},
+		{status.Error(codes.DeadlineExceeded, ""), true},
+		{status.Error(codes.InvalidArgument, ""), false},
+		{status.Error(codes.NotFound, ""), false},
+		{status.Error(codes.AlreadyExists, ""), false},
+		{status.Error(codes.PermissionDenied, ""), false},
+		{status.Error(codes.Unauthenticated, ""), false},
+		{status.Error(codes.ResourceExhausted, ""), false},
+		{status.Error(codes.FailedPrecondition, ""), false},
+		{status.Error(codes.Internal, ""), false},
+		{status.Error(codes.Canceled, ""), false},
+		{errors.New("not a status"), false},
+	}
+	for _, tt := range tests {
+		if got := isFailure(tt.err); got != tt.want {
+			t.Errorf("isFailure(%s) = %v, want %v", status.Code(tt.err), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Package certs builds the gRPC transport credentials for both binaries
+// from config.TLSConfig. Certificates, keys and CA bundles are re-read when
+// their files change, so rotated certificates take effect without a
+// restart.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"shubam/config"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// reloadInterval is how often, at most, the files are checked for changes.
+const reloadInterval = 10 * time.Second
+
+// ServerCredentials returns the credentials for a gRPC server. name
+// identifies the binary's certificate in dev mode.
+func ServerCredentials(cfg config.TLSConfig, name string) (credentials.TransportCredentials, error) {
+	cfg, err := resolve(cfg, name)
+	if err != nil || cfg.Mode == "off" {
+		return insecure.NewCredentials(), err
+	}
+	r, err := newReloader(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	clientAuth := tls.NoClientCert
+	if cfg.Mode == "mtls" {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	return credentials.NewTLS(&tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Each handshake gets the current certificate and client CAs.
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientAuth:   clientAuth,
+				ClientCAs:    pool,
+				NextProtos:   []string{"h2"},
+			}, nil
+		},
+	}), nil
+}
+
+// ClientCredentials returns the credentials for connections to a gRPC
+// server. The server's name is taken from the address dialled.
+func ClientCredentials(cfg config.TLSConfig, name string) (credentials.TransportCredentials, error) {
+	cfg, err := resolve(cfg, name)
+	if err != nil || cfg.Mode == "off" {
+		return insecure.NewCredentials(), err
+	}
+	r, err := newReloader(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if r.certFile != "" {
+		tlsCfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		}
+	}
+	if r.caFile != "" {
+		// The standard verification only takes a fixed pool, so it is
+		// replaced by an equivalent one against the current pool.
+		tlsCfg.InsecureSkipVerify = true
+		tlsCfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("server presented no certificate")
+			}
+			_, pool := r.current()
+			opts := x509.VerifyOptions{
+				Roots:         pool,
+				DNSName:       cs.ServerName,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, c := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(c)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	}
+	return credentials.NewTLS(tlsCfg), nil
+}
+
+// resolve turns dev mode into mtls with generated files.
+func resolve(cfg config.TLSConfig, name string) (config.TLSConfig, error) {
+	if cfg.Mode != "dev" {
+		return cfg, nil
+	}
+	return ensureDev(cfg.DevDir, name)
+}
+
+// reloader holds the certificate and CA pool read from files, re-reading
+// them when their modification times change.
+type reloader struct {
+	certFile, keyFile, caFile string
+
+	mu      sync.Mutex
+	checked time.Time
+	certMod time.Time
+	keyMod  time.Time
+	caMod   time.Time
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+}
+
+func newReloader(cfg config.TLSConfig) (*reloader, error) {
+	r := &reloader{certFile: cfg.CertFile, keyFile: cfg.KeyFile, caFile: cfg.CAFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.checked = time.Now()
+	return r, nil
+}
+
+// current returns the certificate and pool, reloading them first if the
+// files have changed. A failed reload keeps the previous ones.
+func (r *reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) >= reloadInterval {
+		r.checked = time.Now()
+		if r.changed() {
+			if err := r.load(); err != nil {
+				slog.Error("Error reloading TLS files, keeping the previous ones", "error", err)
+			} else {
+				slog.Info("Reloaded TLS files", "cert", r.certFile, "ca", r.caFile)
+			}
+		}
+	}
+	return r.cert, r.pool
+}
+
+func (r *reloader) changed() bool {
+	return modTime(r.certFile) != r.certMod || modTime(r.keyFile) != r.keyMod || modTime(r.caFile) != r.caMod
+}
+
+func (r *reloader) load() error {
+	certMod, keyMod, caMod := modTime(r.certFile), modTime(r.keyFile), modTime(r.caFile)
+
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return fmt.Errorf("error loading TLS certificate: %w", err)
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("error reading TLS CA file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in TLS CA file %s", r.caFile)
+		}
+	}
+
+	r.cert, r.pool = cert, pool
+	r.certMod, r.keyMod, r.caMod = certMod, keyMod, caMod
+	return nil
+}
+
+// modTime returns the file's modification time, or the zero time if it
+// cannot be read.
+func modTime(name string) time.Time {
+	if name == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
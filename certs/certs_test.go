@@ -0,0 +1,101 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"shubam/config"
+)
+
+func TestReloaderPicksUpRotatedFiles(t *testing.T) {
+	// Two unrelated dev pairs, each with its own CA, to rotate between.
+	old, err := ensureDev(filepath.Join(t.TempDir(), "old"), "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := ensureDev(filepath.Join(t.TempDir(), "rotated"), "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	live := config.TLSConfig{
+		Mode:     "mtls",
+		CertFile: filepath.Join(dir, "server.pem"),
+		KeyFile:  filepath.Join(dir, "server-key.pem"),
+		CAFile:   filepath.Join(dir, "ca.pem"),
+	}
+	modified := time.Now().Add(-time.Hour)
+	// install copies the files of from over live, as a deployment would,
+	// each with a newer modification time than the last install.
+	install := func(certFile, keyFile, caFile string) {
+		t.Helper()
+		modified = modified.Add(time.Minute)
+		for to, from := range map[string]string{live.CertFile: certFile, live.KeyFile: keyFile, live.CAFile: caFile} {
+			data, err := os.ReadFile(from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(to, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(to, modified, modified); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	install(old.CertFile, old.KeyFile, old.CAFile)
+
+	r, err := newReloader(live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// current reads the reloader as if the reload interval had passed.
+	current := func() (*tls.Certificate, *x509.CertPool) {
+		r.mu.Lock()
+		r.checked = time.Time{}
+		r.mu.Unlock()
+		return r.current()
+	}
+
+	tests := []struct {
+		name                      string
+		certFile, keyFile, caFile string
+		want                      config.TLSConfig // whose certificate is served
+	}{
+		{"rotated pair", rotated.CertFile, rotated.KeyFile, rotated.CAFile, rotated},
+		{"certificate without its key", old.CertFile, rotated.KeyFile, old.CAFile, rotated},
+		{"certificate in the key file", old.CertFile, old.CAFile, old.CAFile, rotated},
+		{"no certificates in the CA file", old.CertFile, old.KeyFile, old.KeyFile, rotated},
+		{"fixed", old.CertFile, old.KeyFile, old.CAFile, old},
+	}
+	for _, tt := range tests {
+		install(tt.certFile, tt.keyFile, tt.caFile)
+		cert, pool := current()
+		if !bytes.Equal(cert.Certificate[0], leaf(t, tt.want)) {
+			t.Errorf("%s: serving the wrong certificate", tt.name)
+		}
+		// The CA pool rotates with the pair.
+		c, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+			t.Errorf("%s: the CA pool does not go with the certificate: %v", tt.name, err)
+		}
+	}
+}
+
+// leaf returns the DER certificate of cfg's pair.
+func leaf(t *testing.T, cfg config.TLSConfig) []byte {
+	t.Helper()
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Certificate[0]
+}
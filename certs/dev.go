@@ -0,0 +1,188 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"shubam/config"
+)
+
+const (
+	devCAValidity   = 10 * 365 * 24 * time.Hour
+	devCertValidity = 365 * 24 * time.Hour
+	// devRenewBefore is how close to expiry a dev certificate is replaced.
+	devRenewBefore = 30 * 24 * time.Hour
+)
+
+// ensureDev makes sure dir holds a local CA and a certificate for name
+// signed by it, generating whichever is missing, and returns an mtls
+// configuration using them. Binaries sharing dir trust each other.
+func ensureDev(dir, name string) (config.TLSConfig, error) {
+	cfg := config.TLSConfig{
+		Mode:     "mtls",
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, name+".pem"),
+		KeyFile:  filepath.Join(dir, name+"-key.pem"),
+		DevDir:   dir,
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return cfg, fmt.Errorf("error creating dev certificate directory: %w", err)
+	}
+
+	ca, err := devCA(dir)
+	if err != nil {
+		return cfg, err
+	}
+
+	if leaf, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile); err == nil {
+		c, err := x509.ParseCertificate(leaf.Certificate[0])
+		if err == nil && time.Until(c.NotAfter) > devRenewBefore && c.CheckSignatureFrom(ca.Leaf) == nil {
+			return cfg, nil
+		}
+	}
+
+	slog.Warn("Generating a development TLS certificate; do not use it in production", "name", name, "dir", dir)
+	tmpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(devCertValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host, err := os.Hostname(); err == nil {
+		tmpl.DNSNames = append(tmpl.DNSNames, host)
+	}
+	certPEM, keyPEM, err := issue(tmpl, ca)
+	if err != nil {
+		return cfg, err
+	}
+	// The key is written first so the pair on disk never has a
+	// certificate without its key.
+	if err := writeFile(cfg.KeyFile, keyPEM, 0o600); err != nil {
+		return cfg, err
+	}
+	if err := writeFile(cfg.CertFile, certPEM, 0o644); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// devCA loads the CA in dir, creating it on first run. When two binaries
+// start together one creates it and the other waits for the files.
+func devCA(dir string) (*tls.Certificate, error) {
+	certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+
+	if ca, err := loadCA(certFile, keyFile); err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return ca, err
+	}
+
+	tmpl := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "hospital development CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(devCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	certPEM, keyPEM, err := issue(tmpl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Creating the key file exclusively decides which process owns the CA.
+	f, err := os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, fs.ErrExist) {
+		for i := 0; i < 50; i++ {
+			if ca, err := loadCA(certFile, keyFile); err == nil {
+				return ca, nil
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		return loadCA(certFile, keyFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating dev CA key: %w", err)
+	}
+	_, err = f.Write(keyPEM)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error writing dev CA key: %w", err)
+	}
+	if err := writeFile(certFile, certPEM, 0o644); err != nil {
+		return nil, err
+	}
+	slog.Warn("Generated a development CA; trust it only on this machine", "file", certFile)
+	return loadCA(certFile, keyFile)
+}
+
+func loadCA(certFile, keyFile string) (*tls.Certificate, error) {
+	ca, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	ca.Leaf, err = x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	return &ca, nil
+}
+
+// issue creates a new P-256 key and a certificate for it from tmpl, signed
+// by parent or self-signed when parent is nil, both PEM-encoded.
+func issue(tmpl *x509.Certificate, parent *tls.Certificate) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl.SerialNumber, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signerCert, signerKey := tmpl, any(key)
+	if parent != nil {
+		signerCert, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// writeFile replaces name atomically, so a reloading reader never sees a
+// partial file.
+func writeFile(name string, data []byte, perm os.FileMode) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,587 @@
+// Package config loads the settings shared by the hospital binaries. Each
+// setting is read from, in increasing order of precedence: its default, the
+// config file (KEY=VALUE lines, the same format as .env), the environment
+// and the command line. The result is validated as a whole so a bad
+// deployment fails at startup with every problem listed at once.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"shubam/schedule"
+
+	"github.com/joho/godotenv"
+)
+
+// Component selects which binary's settings Load registers.
+type Component int
+
+const (
+	// Web is the Register_User HTTP server.
+	Web Component = iota
+	// AppointmentServer is the appointment gRPC server.
+	AppointmentServer
+	// CLI is the hospitalctl tool, which only talks to the appointment
+	// server.
+	CLI
+)
+
+// Config is the effective configuration of one binary. Fields that do not
+// apply to the loaded Component keep their defaults.
+type Config struct {
+	ConfigFile string
+
+	// Env is "development" or "production". Production refuses secrets
+	// from the config file and known default passwords.
+	Env string
+
+	DB DBConfig
+
+	// TLS secures the gRPC connections between the binaries.
+	TLS TLSConfig
+	// ServiceTokenKey is the HMAC key shared by the binaries to sign and
+	// verify the tokens that authenticate gRPC calls.
+	ServiceTokenKey string
+	// OperatorTokenKey signs hospitalctl's tokens. Only hospitalctl and
+	// the appointment server hold it, so the web tier cannot make
+	// operator calls; an appointment server without it accepts none.
+	OperatorTokenKey string
+
+	HospitalTimezone string
+
+	// LogLevel is the minimum level logged: debug, info, warn or error.
+	LogLevel string
+
+	// TraceExporter is where spans are sent: none, stdout or otlp.
+	TraceExporter string
+	// TraceEndpoint is the OTLP/HTTP collector URL.
+	TraceEndpoint string
+
+	// ShutdownTimeout bounds how long in-flight requests may take to
+	// finish after SIGINT or SIGTERM.
+	ShutdownTimeout time.Duration
+	// DrainDelay is how long the web server keeps serving, failing
+	// /readyz, before it stops accepting connections.
+	DrainDelay time.Duration
+
+	// Web; hospitalctl also uses AppointmentAddr and RPCTimeout.
+	HTTPAddr         string
+	TemplateDir      string
+	AppointmentAddr  string
+	PharmacyAddr     string
+	HTTPReadTimeout  time.Duration
+	HTTPWriteTimeout time.Duration
+	HTTPIdleTimeout  time.Duration
+	RPCTimeout       time.Duration
+	// PublicURL is the web server's external base URL, used in links
+	// emailed to patients.
+	PublicURL string
+	// LinkKey is the HMAC key signing those links.
+	LinkKey string
+	Mail    MailConfig
+
+	// Appointment server
+	GRPCAddr    string
+	MetricsAddr string
+
+	settings []*setting
+}
+
+// DBConfig describes the Postgres connection. When DSN is set it is used
+// verbatim and the individual fields are ignored.
+type DBConfig struct {
+	DSN            string
+	Host           string
+	Port           string
+	User           string
+	Password       string
+	Name           string
+	SSLMode        string
+	ConnectTimeout time.Duration
+	AutoMigrate    bool
+}
+
+// TLSConfig describes the certificates used on gRPC connections. Mode is
+// one of tlsModes: "off" sends plaintext, "tls" authenticates the server,
+// "mtls" authenticates both ends, and "dev" is mtls with a local CA and
+// certificates generated in DevDir on first run.
+type TLSConfig struct {
+	Mode     string
+	CertFile string
+	KeyFile  string
+	// CAFile verifies peers; empty means the system roots for servers and
+	// is required for client certificates.
+	CAFile string
+	DevDir string
+}
+
+var tlsModes = []string{"off", "tls", "mtls", "dev"}
+
+// MailConfig describes how email is sent. Transport "log" only logs each
+// message, for development; "smtp" relays it through SMTPAddr.
+type MailConfig struct {
+	Transport    string
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	From         string
+}
+
+// setting binds one configuration key to a field of Config. key is both
+// the environment variable and the config-file key; the flag name is
+// derived from it (DB_HOST becomes -db-host). Secret settings also get a
+// KEY_FILE companion naming a file to read the value from.
+type setting struct {
+	key    string
+	usage  string
+	secret bool
+	source string
+	flag   *flag.Flag
+	file   *setting
+}
+
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// Load builds the configuration for component from the config file,
+// environment and args (without the program name). It returns the
+// arguments left after the flags, e.g. a subcommand.
+func Load(component Component, program string, args []string) (*Config, []string, error) {
+	cfg := &Config{}
+	fs := flag.NewFlagSet(program, flag.ContinueOnError)
+
+	add := func(key, usage string, secret bool, bind func(name string)) {
+		name := strings.ReplaceAll(strings.ToLower(key), "_", "-")
+		bind(name)
+		s := &setting{key: key, usage: usage, secret: secret, source: "default", flag: fs.Lookup(name)}
+		cfg.settings = append(cfg.settings, s)
+		if secret {
+			fileUsage := "file containing the value of " + key + ", e.g. a mounted secret"
+			fs.String(name+"-file", "", fileUsage)
+			s.file = &setting{key: key + "_FILE", usage: fileUsage, source: "default", flag: fs.Lookup(name + "-file")}
+			cfg.settings = append(cfg.settings, s.file)
+		}
+	}
+	str := func(p *string, key, def, usage string) {
+		add(key, usage, false, func(name string) { fs.StringVar(p, name, def, usage) })
+	}
+	dur := func(p *time.Duration, key string, def time.Duration, usage string) {
+		add(key, usage, false, func(name string) { fs.DurationVar(p, name, def, usage) })
+	}
+
+	str(&cfg.ConfigFile, "CONFIG_FILE", ".env", "file of KEY=VALUE settings; the default is optional, an explicit one must exist, empty disables it")
+	str(&cfg.Env, "APP_ENV", "development", "deployment environment: development or production")
+
+	if component != CLI {
+		add("DB_DSN", "full Postgres connection string; overrides the other DB_* connection settings", true, func(name string) {
+			fs.StringVar(&cfg.DB.DSN, name, "", "full Postgres connection string; overrides the other DB_* connection settings")
+		})
+		str(&cfg.DB.Host, "DB_HOST", "localhost", "Postgres host")
+		str(&cfg.DB.Port, "DB_PORT", "5432", "Postgres port")
+		str(&cfg.DB.User, "DB_USER", "postgres", "Postgres user")
+		add("DB_PASSWORD", "Postgres password", true, func(name string) { fs.StringVar(&cfg.DB.Password, name, "", "Postgres password") })
+		str(&cfg.DB.Name, "DB_NAME", "", "Postgres database name")
+		str(&cfg.DB.SSLMode, "DB_SSLMODE", "disable", "Postgres TLS mode: "+strings.Join(sslModes, ", "))
+		dur(&cfg.DB.ConnectTimeout, "DB_CONNECT_TIMEOUT", 5*time.Second, "how long to wait when opening a database connection")
+		add("DB_AUTO_MIGRATE", "apply pending schema migrations at startup", false, func(name string) {
+			fs.BoolVar(&cfg.DB.AutoMigrate, name, false, "apply pending schema migrations at startup")
+		})
+	}
+
+	str(&cfg.TLS.Mode, "TLS_MODE", "off", "gRPC transport security: "+strings.Join(tlsModes, ", "))
+	str(&cfg.TLS.CertFile, "TLS_CERT_FILE", "", "PEM certificate this binary presents on gRPC connections; reloaded when it changes")
+	str(&cfg.TLS.KeyFile, "TLS_KEY_FILE", "", "PEM private key for TLS_CERT_FILE")
+	str(&cfg.TLS.CAFile, "TLS_CA_FILE", "", "PEM CA bundle used to verify gRPC peers")
+	if component != CLI {
+		add("SERVICE_TOKEN_KEY", "shared key, at least 32 bytes, signing the tokens that authenticate gRPC calls", true, func(name string) {
+			fs.StringVar(&cfg.ServiceTokenKey, name, "", "shared key, at least 32 bytes, signing the tokens that authenticate gRPC calls")
+		})
+	}
+	if component != Web {
+		add("OPERATOR_TOKEN_KEY", "key, at least 32 bytes, signing hospitalctl's tokens; the appointment server accepts no operator calls without it", true, func(name string) {
+			fs.StringVar(&cfg.OperatorTokenKey, name, "", "key, at least 32 bytes, signing hospitalctl's tokens; the appointment server accepts no operator calls without it")
+		})
+	}
+	str(&cfg.TLS.DevDir, "TLS_DEV_DIR", ".dev-certs", "where TLS_MODE=dev keeps its generated CA and certificates")
+
+	str(&cfg.HospitalTimezone, "HOSPITAL_TIMEZONE", "UTC", "IANA time zone the hospital books and displays appointments in")
+	str(&cfg.LogLevel, "LOG_LEVEL", "info", "minimum log level: debug, info, warn or error")
+	str(&cfg.TraceExporter, "TRACE_EXPORTER", "none", "where to send trace spans: none, stdout or otlp")
+	str(&cfg.TraceEndpoint, "TRACE_ENDPOINT", "http://localhost:4318", "OTLP/HTTP collector URL used when TRACE_EXPORTER is otlp")
+	dur(&cfg.ShutdownTimeout, "SHUTDOWN_TIMEOUT", 20*time.Second, "how long to let in-flight requests finish on SIGINT or SIGTERM")
+	if component == Web {
+		dur(&cfg.DrainDelay, "DRAIN_DELAY", 5*time.Second, "how long to keep serving, with /readyz failing, after SIGINT or SIGTERM before SHUTDOWN_TIMEOUT starts")
+	}
+
+	switch component {
+	case Web:
+		str(&cfg.HTTPAddr, "HTTP_ADDR", ":8080", "address the web server listens on")
+		str(&cfg.TemplateDir, "TEMPLATE_DIR", "Static", "directory holding the HTML templates and static files")
+		str(&cfg.AppointmentAddr, "APPOINTMENT_ADDR", "localhost:5001", "address of the appointment gRPC server")
+		str(&cfg.PharmacyAddr, "PHARMACY_ADDR", "localhost:5002", "address of the pharmacy gRPC server")
+		dur(&cfg.HTTPReadTimeout, "HTTP_READ_TIMEOUT", 15*time.Second, "maximum time to read an HTTP request")
+		dur(&cfg.HTTPWriteTimeout, "HTTP_WRITE_TIMEOUT", 30*time.Second, "maximum time to write an HTTP response")
+		dur(&cfg.HTTPIdleTimeout, "HTTP_IDLE_TIMEOUT", 2*time.Minute, "how long idle keep-alive connections are kept")
+		dur(&cfg.RPCTimeout, "RPC_TIMEOUT", 5*time.Second, "deadline for calls to the gRPC services")
+		str(&cfg.PublicURL, "PUBLIC_URL", "http://localhost:8080", "external base URL of the web server, used in emailed links")
+		add("LINK_KEY", "key, at least 32 bytes, signing the links emailed to patients", true, func(name string) {
+			fs.StringVar(&cfg.LinkKey, name, "", "key, at least 32 bytes, signing the links emailed to patients")
+		})
+		str(&cfg.Mail.Transport, "MAIL_TRANSPORT", "log", "how email is sent: log (development only) or smtp")
+		str(&cfg.Mail.SMTPAddr, "SMTP_ADDR", "localhost:587", "SMTP relay host:port used when MAIL_TRANSPORT is smtp")
+		str(&cfg.Mail.SMTPUsername, "SMTP_USERNAME", "", "SMTP user; empty sends without authenticating")
+		add("SMTP_PASSWORD", "SMTP password", true, func(name string) { fs.StringVar(&cfg.Mail.SMTPPassword, name, "", "SMTP password") })
+		str(&cfg.Mail.From, "MAIL_FROM", "Hospital <no-reply@localhost>", "sender address of emails to patients")
+	case AppointmentServer:
+		str(&cfg.GRPCAddr, "GRPC_ADDR", ":5001", "address the appointment gRPC server listens on")
+		str(&cfg.MetricsAddr, "METRICS_ADDR", ":9091", "address the appointment server serves Prometheus /metrics on")
+	case CLI:
+		str(&cfg.AppointmentAddr, "APPOINTMENT_ADDR", "localhost:5001", "address of the appointment gRPC server")
+		dur(&cfg.RPCTimeout, "RPC_TIMEOUT", 10*time.Second, "deadline for each call to the appointment server")
+	}
+
+	// The config file location itself may come from the environment or
+	// the command line, so find it before layering anything else.
+	// Errors are reported by the second, final parse below.
+	fs.SetOutput(io.Discard)
+	_ = fs.Parse(args)
+	explicitFile := false
+	fs.Visit(func(f *flag.Flag) { explicitFile = explicitFile || f.Name == "config-file" })
+	if v, ok := os.LookupEnv("CONFIG_FILE"); ok && !explicitFile {
+		cfg.ConfigFile, explicitFile = v, true
+		cfg.settings[0].source = "env"
+	}
+
+	fileValues, err := readFile(cfg.ConfigFile, explicitFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var errs []error
+	var fileSecrets []string
+	for _, s := range cfg.settings {
+		if s.key == "CONFIG_FILE" {
+			continue
+		}
+		if v, ok := fileValues[s.key]; ok {
+			errs = append(errs, s.set(v, "file"))
+			if s.secret && v != "" {
+				fileSecrets = append(fileSecrets, s.key)
+			}
+		}
+		if v, ok := os.LookupEnv(s.key); ok {
+			errs = append(errs, s.set(v, "env"))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() {
+		commands := "migrate ..."
+		switch component {
+		case Web:
+			commands += " | set-role EMAIL ROLE | reset-2fa EMAIL"
+		case CLI:
+			commands = "COMMAND ..."
+		}
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] [%s]\n\nEvery flag can also be set with the environment variable or config file key shown.\n\n", program, commands)
+		for _, s := range cfg.settings {
+			fmt.Fprintf(fs.Output(), "  -%s (%s)\n    \t%s (default %q)\n", s.flag.Name, s.key, s.usage, s.flag.DefValue)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range cfg.settings {
+			if s.flag == f {
+				s.source = "flag"
+			}
+		}
+	})
+
+	if err := cfg.readSecretFiles(); err != nil {
+		return nil, nil, err
+	}
+	if err := cfg.checkFileSecrets(fileSecrets); err != nil {
+		return nil, nil, err
+	}
+	cfg.applyDevSecrets()
+	if err := cfg.Validate(component); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+func (s *setting) set(value, source string) error {
+	if err := s.flag.Value.Set(value); err != nil {
+		return fmt.Errorf("%s: invalid value from %s: %w", s.key, source, err)
+	}
+	s.source = source
+	return nil
+}
+
+// readFile returns the KEY=VALUE pairs in path. A missing file is only an
+// error when the caller asked for it explicitly.
+func readFile(path string, required bool) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	values, err := godotenv.Read(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !required {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// Validate checks the settings used by component and reports every problem
+// found, one per line.
+func (c *Config) Validate(component Component) error {
+	var errs []error
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{key}, args...)...))
+	}
+	positive := func(key string, d time.Duration) {
+		if d <= 0 {
+			fail(key, "must be positive, got %s", d)
+		}
+	}
+	address := func(key, addr string, needHost bool) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			fail(key, "must be host:port, got %q", addr)
+			return
+		}
+		if needHost && host == "" {
+			fail(key, "host is required, got %q", addr)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			fail(key, "port must be between 1 and 65535, got %q", port)
+		}
+	}
+
+	if component != CLI && c.DB.DSN == "" {
+		if c.DB.Host == "" {
+			fail("DB_HOST", "is required")
+		}
+		if n, err := strconv.Atoi(c.DB.Port); err != nil || n < 1 || n > 65535 {
+			fail("DB_PORT", "must be between 1 and 65535, got %q", c.DB.Port)
+		}
+		if c.DB.User == "" {
+			fail("DB_USER", "is required")
+		}
+		if c.DB.Name == "" {
+			fail("DB_NAME", "is required")
+		}
+		valid := false
+		for _, m := range sslModes {
+			valid = valid || c.DB.SSLMode == m
+		}
+		if !valid {
+			fail("DB_SSLMODE", "must be one of %s, got %q", strings.Join(sslModes, ", "), c.DB.SSLMode)
+		}
+	}
+	if component != CLI {
+		positive("DB_CONNECT_TIMEOUT", c.DB.ConnectTimeout)
+	}
+
+	switch c.TLS.Mode {
+	case "off", "dev":
+		if c.Env == "production" {
+			fail("TLS_MODE", "must be tls or mtls in production, got %q", c.TLS.Mode)
+		}
+	case "tls", "mtls":
+		// Servers always present a certificate; clients only for mtls.
+		needCert := c.TLS.Mode == "mtls" || component == AppointmentServer
+		if needCert && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+			fail("TLS_CERT_FILE", "and TLS_KEY_FILE are required when TLS_MODE is %s", c.TLS.Mode)
+		}
+		if c.TLS.Mode == "mtls" && c.TLS.CAFile == "" {
+			fail("TLS_CA_FILE", "is required when TLS_MODE is mtls")
+		}
+	default:
+		fail("TLS_MODE", "must be one of %s, got %q", strings.Join(tlsModes, ", "), c.TLS.Mode)
+	}
+
+	switch c.Env {
+	case "development":
+	case "production":
+		if component != CLI && isDefaultPassword(c.DB.password()) {
+			fail("DB_PASSWORD", "is empty or a well-known default; refusing to start in production")
+		}
+		if component != CLI && c.ServiceTokenKey == devServiceTokenKey {
+			fail("SERVICE_TOKEN_KEY", "is the development key; refusing to start in production")
+		}
+		if component != Web && c.OperatorTokenKey == devOperatorTokenKey {
+			fail("OPERATOR_TOKEN_KEY", "is the development key; refusing to start in production")
+		}
+		if component == Web && c.LinkKey == devLinkKey {
+			fail("LINK_KEY", "is the development key; refusing to start in production")
+		}
+		if component == Web && c.Mail.Transport == "log" {
+			fail("MAIL_TRANSPORT", "must be smtp in production")
+		}
+	default:
+		fail("APP_ENV", "must be development or production, got %q", c.Env)
+	}
+
+	positive("SHUTDOWN_TIMEOUT", c.ShutdownTimeout)
+	if c.DrainDelay < 0 {
+		fail("DRAIN_DELAY", "must not be negative, got %s", c.DrainDelay)
+	}
+
+	if component != CLI && len(c.ServiceTokenKey) < 32 {
+		fail("SERVICE_TOKEN_KEY", "must be at least 32 bytes, got %d", len(c.ServiceTokenKey))
+	}
+	// The appointment server may run without an operator key; the CLI is
+	// of no use without one.
+	if (component == CLI || c.OperatorTokenKey != "") && len(c.OperatorTokenKey) < 32 {
+		fail("OPERATOR_TOKEN_KEY", "must be at least 32 bytes, got %d", len(c.OperatorTokenKey))
+	}
+	if component == AppointmentServer && c.OperatorTokenKey != "" && c.OperatorTokenKey == c.ServiceTokenKey {
+		fail("OPERATOR_TOKEN_KEY", "must differ from SERVICE_TOKEN_KEY, which the web server also holds")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		fail("LOG_LEVEL", "must be debug, info, warn or error, got %q", c.LogLevel)
+	}
+
+	switch c.TraceExporter {
+	case "none", "stdout":
+	case "otlp":
+		if u, err := url.Parse(c.TraceEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("TRACE_ENDPOINT", "must be an http or https URL, got %q", c.TraceEndpoint)
+		}
+	default:
+		fail("TRACE_EXPORTER", "must be none, stdout or otlp, got %q", c.TraceExporter)
+	}
+
+	if _, err := schedule.LoadLocation(c.HospitalTimezone); err != nil {
+		fail("HOSPITAL_TIMEZONE", "unknown time zone %q", c.HospitalTimezone)
+	}
+
+	switch component {
+	case Web:
+		address("HTTP_ADDR", c.HTTPAddr, false)
+		address("APPOINTMENT_ADDR", c.AppointmentAddr, true)
+		address("PHARMACY_ADDR", c.PharmacyAddr, true)
+		if info, err := os.Stat(c.TemplateDir); err != nil || !info.IsDir() {
+			fail("TEMPLATE_DIR", "%q is not a readable directory", c.TemplateDir)
+		}
+		positive("HTTP_READ_TIMEOUT", c.HTTPReadTimeout)
+		positive("HTTP_WRITE_TIMEOUT", c.HTTPWriteTimeout)
+		positive("HTTP_IDLE_TIMEOUT", c.HTTPIdleTimeout)
+		positive("RPC_TIMEOUT", c.RPCTimeout)
+		if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("PUBLIC_URL", "must be an http or https URL, got %q", c.PublicURL)
+		}
+		if len(c.LinkKey) < 32 {
+			fail("LINK_KEY", "must be at least 32 bytes, got %d", len(c.LinkKey))
+		}
+		switch c.Mail.Transport {
+		case "log":
+		case "smtp":
+			address("SMTP_ADDR", c.Mail.SMTPAddr, true)
+		default:
+			fail("MAIL_TRANSPORT", "must be log or smtp, got %q", c.Mail.Transport)
+		}
+		if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+			fail("MAIL_FROM", "must be an email address, got %q", c.Mail.From)
+		}
+	case AppointmentServer:
+		address("GRPC_ADDR", c.GRPCAddr, false)
+		address("METRICS_ADDR", c.MetricsAddr, false)
+	case CLI:
+		address("APPOINTMENT_ADDR", c.AppointmentAddr, true)
+		positive("RPC_TIMEOUT", c.RPCTimeout)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %w", joinLines(errs))
+	}
+	return nil
+}
+
+func joinLines(errs []error) error {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return errors.New(strings.Join(msgs, "\n  "))
+}
+
+// ConnString returns the lib/pq connection string for the database.
+func (c DBConfig) ConnString() string {
+	if c.DSN != "" {
+		return c.DSN
+	}
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s connect_timeout=%d",
+		quote(c.Host), quote(c.Port), quote(c.User), quote(c.Password), quote(c.Name), quote(c.SSLMode),
+		int(c.ConnectTimeout.Round(time.Second)/time.Second))
+}
+
+// quote escapes a keyword/value connection string value.
+func quote(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+// LogValue logs the settings as a group of KEY: "value (source)", sorted
+// by key, with secrets redacted.
+func (c *Config) LogValue() slog.Value {
+	settings := append([]*setting(nil), c.settings...)
+	sort.SliceStable(settings, func(i, j int) bool { return settings[i].key < settings[j].key })
+	attrs := make([]slog.Attr, 0, len(settings))
+	for _, s := range settings {
+		v := s.flag.Value.String()
+		switch {
+		case s.key == "DB_DSN":
+			v = redactDSN(v)
+		case s.secret && v != "":
+			v = "<redacted>"
+		}
+		attrs = append(attrs, slog.String(s.key, fmt.Sprintf("%s (%s)", v, s.source)))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+var dsnPassword = regexp.MustCompile(`(?i)(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// redactDSN hides the password in either URL or keyword/value form.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}<redacted>")
+}
+
+// String describes the connection without its password, so a DBConfig
+// that ends up in a log line or error does not leak it.
+func (c DBConfig) String() string {
+	if c.DSN != "" {
+		return redactDSN(c.DSN)
+	}
+	return fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=%s", c.Host, c.Port, c.User, c.Name, c.SSLMode)
+}
+
+// GoString keeps %#v as safe as %v.
+func (c DBConfig) GoString() string {
+	return "config.DBConfig{" + c.String() + "}"
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	serviceKey  = "test-service-key-of-at-least-32-bytes"
+	operatorKey = "test-operator-key-of-at-least-32-bytes"
+)
+
+// load runs Load with env set and file, if not empty, as the config
+// file. DB_NAME is set unless env sets it, since nothing else is valid
+// without it.
+func load(t *testing.T, component Component, file string, env map[string]string, args ...string) (*Config, error) {
+	t.Helper()
+	t.Setenv("DB_NAME", "hospital")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	path := ""
+	if file != "" {
+		path = writeFile(t, "hospital.env", file)
+	}
+	cfg, _, err := Load(component, "test", append([]string{"-config-file", path}, args...))
+	return cfg, err
+}
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	keyFile := writeFile(t, "service-key", serviceKey+"\n")
+	logLevel := func(c *Config) string { return c.LogLevel }
+	grpcAddr := func(c *Config) string { return c.GRPCAddr }
+	serviceTokenKey := func(c *Config) string { return c.ServiceTokenKey }
+	dbPassword := func(c *Config) string { return c.DB.Password }
+
+	tests := []struct {
+		name  string
+		file  string
+		env   map[string]string
+		args  []string
+		field func(*Config) string
+		want  string // the field's value, or part of the error
+		err   bool
+	}{
+		{"default", "", nil, nil, logLevel, "info", false},
+		{"file over default", "LOG_LEVEL=warn\n", nil, nil, logLevel, "warn", false},
+		{"env over file", "LOG_LEVEL=warn\n", map[string]string{"LOG_LEVEL": "error"}, nil, logLevel, "error", false},
+		{"flag over env", "LOG_LEVEL=warn\n", map[string]string{"LOG_LEVEL": "error"}, []string{"-log-level", "debug"}, logLevel, "debug", false},
+		{"file setting kept under another's env", "LOG_LEVEL=warn\nGRPC_ADDR=:6001\n", map[string]string{"LOG_LEVEL": "error"}, nil, grpcAddr, ":6001", false},
+		{"bad value from env", "", map[string]string{"SHUTDOWN_TIMEOUT": "soon"}, nil, nil, "SHUTDOWN_TIMEOUT: invalid value from env", true},
+		{"bad value from file", "SHUTDOWN_TIMEOUT=soon\n", nil, nil, nil, "SHUTDOWN_TIMEOUT: invalid value from file", true},
+		{"explicit config file missing", "", nil, []string{"-config-file", filepath.Join(t.TempDir(), "missing.env")}, nil, "error reading config file", true},
+
+		{"development service key", "", nil, nil, serviceTokenKey, devServiceTokenKey, false},
+		{"secret from env file", "", map[string]string{"SERVICE_TOKEN_KEY_FILE": keyFile}, nil, serviceTokenKey, serviceKey, false},
+		{"secret from flag file", "", nil, []string{"-service-token-key-file", keyFile}, serviceTokenKey, serviceKey, false},
+		{"secret file from the config file", "SERVICE_TOKEN_KEY_FILE=" + keyFile + "\n", nil, nil, serviceTokenKey, serviceKey, false},
+		{"secret and secret file", "", map[string]string{"SERVICE_TOKEN_KEY": serviceKey, "SERVICE_TOKEN_KEY_FILE": keyFile}, nil, nil, "set only one", true},
+		{"secret file missing", "", map[string]string{"SERVICE_TOKEN_KEY_FILE": filepath.Join(t.TempDir(), "missing")}, nil, nil, "SERVICE_TOKEN_KEY_FILE:", true},
+		{"secret in the config file in development", "DB_PASSWORD=s3cret-enough\n", nil, nil, dbPassword, "s3cret-enough", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := load(t, AppointmentServer, tt.file, tt.env, tt.args...)
+			if tt.err {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("Load error = %v, want one containing %q", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.field(cfg); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadProduction(t *testing.T) {
+	production := map[string]string{
+		"APP_ENV":            "production",
+		"TLS_MODE":           "tls",
+		"TLS_CERT_FILE":      "server.pem",
+		"TLS_KEY_FILE":       "server-key.pem",
+		"DB_PASSWORD":        "s3cret-enough",
+		"SERVICE_TOKEN_KEY":  serviceKey,
+		"OPERATOR_TOKEN_KEY": operatorKey,
+		"LINK_KEY":           "test-link-key-of-at-least-32-bytes",
+		"MAIL_TRANSPORT":     "smtp",
+		"TEMPLATE_DIR":       "../Static",
+	}
+	with := func(changes ...string) map[string]string {
+		env := make(map[string]string, len(production))
+		for k, v := range production {
+			env[k] = v
+		}
+		for i := 0; i < len(changes); i += 2 {
+			env[changes[i]] = changes[i+1]
+		}
+		return env
+	}
+
+	tests := []struct {
+		name      string
+		component Component
+		file      string
+		env       map[string]string
+		want      string // part of the error; empty for none
+	}{
+		{"valid appointment server", AppointmentServer, "", production, ""},
+		{"valid web server", Web, "", production, ""},
+		{"development service key", AppointmentServer, "", with("SERVICE_TOKEN_KEY", devServiceTokenKey), "SERVICE_TOKEN_KEY: is the development key"},
+		{"development operator key", AppointmentServer, "", with("OPERATOR_TOKEN_KEY", devOperatorTokenKey), "OPERATOR_TOKEN_KEY: is the development key"},
+		{"development link key", Web, "", with("LINK_KEY", devLinkKey), "LINK_KEY: is the development key"},
+		{"no development key filled in", AppointmentServer, "", with("SERVICE_TOKEN_KEY", ""), "SERVICE_TOKEN_KEY: must be at least 32 bytes"},
+		{"operator key equal to the service key", AppointmentServer, "", with("OPERATOR_TOKEN_KEY", serviceKey), "OPERATOR_TOKEN_KEY: must differ from SERVICE_TOKEN_KEY"},
+		{"no database password", AppointmentServer, "", with("DB_PASSWORD", ""), "DB_PASSWORD: is empty or a well-known default"},
+		{"default database password", AppointmentServer, "", with("DB_PASSWORD", "Postgres"), "DB_PASSWORD: is empty or a well-known default"},
+		{"default password in the DSN URL", AppointmentServer, "", with("DB_DSN", "postgres://app:changeme@db/hospital"), "DB_PASSWORD: is empty or a well-known default"},
+		{"default password in the DSN keywords", AppointmentServer, "", with("DB_DSN", "host=db user=app password='admin' dbname=hospital"), "DB_PASSWORD: is empty or a well-known default"},
+		{"strong password in the DSN", AppointmentServer, "", with("DB_DSN", "postgres://app:s3cret-enough@db/hospital"), ""},
+		{"secret in the config file", Web, "SMTP_PASSWORD=another-s3cret\n", production, "SMTP_PASSWORD must not be set in config file"},
+		{"logged mail", Web, "", with("MAIL_TRANSPORT", "log"), "MAIL_TRANSPORT: must be smtp in production"},
+		{"plaintext gRPC", AppointmentServer, "", with("TLS_MODE", "off"), "TLS_MODE: must be tls or mtls in production"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(t, tt.component, tt.file, tt.env)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("Load: %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("Load error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// defaultPasswords are passwords that ship with Postgres images, tutorials
+// and example files. A production database must not use any of them.
+var defaultPasswords = []string{
+	"", "postgres", "password", "admin", "root", "secret", "changeme", "example", "123456",
+}
+
+// devServiceTokenKey is used for SERVICE_TOKEN_KEY in development when it
+// is not set, so the binaries can talk to each other out of the box.
+const devServiceTokenKey = "development-only-service-token-key-do-not-use"
+
+// devOperatorTokenKey is used for OPERATOR_TOKEN_KEY in development when
+// it is not set.
+const devOperatorTokenKey = "development-only-operator-token-key-do-not-use"
+
+// devLinkKey is used for LINK_KEY in development when it is not set.
+const devLinkKey = "development-only-link-signing-key-do-not-use"
+
+// applyDevSecrets fills in development defaults for unset secrets.
+func (c *Config) applyDevSecrets() {
+	if c.Env != "development" {
+		return
+	}
+	defaults := []struct {
+		key   string
+		value *string
+		dev   string
+	}{
+		{"SERVICE_TOKEN_KEY", &c.ServiceTokenKey, devServiceTokenKey},
+		{"OPERATOR_TOKEN_KEY", &c.OperatorTokenKey, devOperatorTokenKey},
+		{"LINK_KEY", &c.LinkKey, devLinkKey},
+	}
+	for _, d := range defaults {
+		for _, s := range c.settings {
+			if s.key == d.key && *d.value == "" {
+				*d.value = d.dev
+				s.source = "development default"
+				slog.Warn(d.key + " is not set; using the development key")
+			}
+		}
+	}
+}
+
+// readSecretFiles replaces each secret whose KEY_FILE is set with the
+// contents of that file, the convention used by Docker and Kubernetes
+// secret mounts. Setting both KEY and KEY_FILE is an error.
+func (c *Config) readSecretFiles() error {
+	for _, s := range c.settings {
+		if s.file == nil {
+			continue
+		}
+		path := s.file.flag.Value.String()
+		if path == "" {
+			continue
+		}
+		if s.source != "default" {
+			return fmt.Errorf("%s is set from %s and %s from %s; set only one", s.key, s.source, s.file.key, s.file.source)
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.file.key, err)
+		}
+		if err := s.flag.Value.Set(strings.TrimRight(string(b), "\r\n")); err != nil {
+			return fmt.Errorf("%s: invalid value in %s: %w", s.key, path, err)
+		}
+		s.source = "secret file"
+	}
+	return nil
+}
+
+// checkFileSecrets handles secrets that were found in the config file.
+// Config files tend to get committed, so production refuses them and
+// development only warns.
+func (c *Config) checkFileSecrets(keys []string) error {
+	var stillFromFile []string
+	for _, key := range keys {
+		for _, s := range c.settings {
+			if s.key == key && s.source == "file" {
+				stillFromFile = append(stillFromFile, key)
+			}
+		}
+	}
+	if len(stillFromFile) == 0 {
+		return nil
+	}
+
+	list := strings.Join(stillFromFile, ", ")
+	if c.Env == "production" {
+		return fmt.Errorf("%s must not be set in config file %s in production; use the environment or a *_FILE secret", list, c.ConfigFile)
+	}
+	slog.Warn("Secrets read from the config file; use the environment or a *_FILE secret outside development",
+		"keys", list, "file", c.ConfigFile)
+	return nil
+}
+
+// password returns the database password, looking inside DSN when set.
+func (c DBConfig) password() string {
+	if c.DSN == "" {
+		return c.Password
+	}
+	if u, err := url.Parse(c.DSN); err == nil && u.Scheme != "" {
+		p, _ := u.User.Password()
+		return p
+	}
+	m := dsnPassword.FindStringSubmatch(c.DSN)
+	if m == nil {
+		return ""
+	}
+	v := m[2]
+	if strings.HasPrefix(v, "'") {
+		v = strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(strings.Trim(v, "'"))
+	}
+	return v
+}
+
+func isDefaultPassword(p string) bool {
+	for _, d := range defaultPasswords {
+		if strings.EqualFold(p, d) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+// Package email sends the messages the web server emails patients, such
+// as verification links. Senders are pluggable: New picks one from
+// config.MailConfig, and anything implementing Sender can stand in for it.
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"shubam/config"
+)
+
+// Message is a plain-text email to one recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers messages.
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// New returns the Sender selected by cfg.Transport.
+func New(cfg config.MailConfig) (Sender, error) {
+	switch cfg.Transport {
+	case "log":
+		slog.Warn("MAIL_TRANSPORT is log; emails are logged instead of sent")
+		return Log{}, nil
+	case "smtp":
+		from, err := mail.ParseAddress(cfg.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sender address: %w", err)
+		}
+		return &SMTP{Addr: cfg.SMTPAddr, From: from, Username: cfg.SMTPUsername, Password: cfg.SMTPPassword}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", cfg.Transport)
+	}
+}
+
+// Log writes messages to the log instead of sending them, so links can be
+// followed in development without a mail server.
+type Log struct{}
+
+func (Log) Send(ctx context.Context, m Message) error {
+	slog.InfoContext(ctx, "Email", "to", m.To, "subject", m.Subject, "body", m.Body)
+	return nil
+}
+
+// SMTP relays messages through an SMTP server, upgrading the connection
+// with STARTTLS when the server offers it. Credentials are only sent over
+// TLS.
+type SMTP struct {
+	Addr     string
+	From     *mail.Address
+	Username string
+	Password string
+}
+
+// sendTimeout bounds a send when ctx has no deadline.
+const sendTimeout = 30 * time.Second
+
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+	if strings.ContainsAny(m.Subject, "\r\n") {
+		return errors.New("subject must be a single line")
+	}
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("error connecting to SMTP server: %w", err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error connecting to SMTP server: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}); err != nil {
+			return fmt.Errorf("error starting TLS: %w", err)
+		}
+	}
+	if s.Username != "" {
+		// PlainAuth itself refuses to send credentials without TLS,
+		// except to localhost.
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return fmt.Errorf("error authenticating to SMTP server: %w", err)
+		}
+	}
+	if err := c.Mail(s.From.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(format(s.From, to, m)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// format builds the RFC 5322 message.
+func format(from, to *mail.Address, m Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
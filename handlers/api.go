@@ -0,0 +1,762 @@
+package handlers
+
+import (
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"shubam/schedule"
+	"shubam/store"
+)
+
+// The JSON API under /api/v1 serves the mobile apps. It authenticates
+// with the session token as a bearer token instead of a cookie, so it
+// needs no CSRF tokens, and answers every error with an apiErrorBody.
+
+const (
+	// apiPageSize is the default size of a page of a listing, and
+	// apiMaxPageSize the largest a client may ask for.
+	apiPageSize    = 20
+	apiMaxPageSize = 100
+	// apiMaxBody bounds request bodies.
+	apiMaxBody = 1 << 20
+)
+
+// openAPI describes the API; keep it in step with the routes.
+//
+//go:embed openapi.json
+var openAPI []byte
+
+// apiErrorBody is the envelope of every API error.
+type apiErrorBody struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	// Code is stable and meant for programs, Message for people.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiPage is one page of a listing. NextPageToken, passed back as the
+// page_token query parameter, fetches the next page; it is empty on the
+// last one.
+type apiPage[T any] struct {
+	Items         []T    `json:"items"`
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+type apiUser struct {
+	ID            int64  `json:"id"`
+	Email         string `json:"email"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+type apiSession struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      apiUser   `json:"user"`
+}
+
+// apiChallenge asks for a second factor to finish logging in.
+type apiChallenge struct {
+	SecondFactor string `json:"second_factor"`
+	Challenge    string `json:"challenge"`
+}
+
+type apiDoctor struct {
+	ID         int64  `json:"id"`
+	Name       string `json:"name"`
+	Specialty  string `json:"specialty"`
+	Experience string `json:"experience"`
+	PhotoURL   string `json:"photo_url"`
+}
+
+// apiSlot is a bookable slot; Date and Time are hospital-local.
+type apiSlot struct {
+	Date      string    `json:"date"`
+	Time      string    `json:"time"`
+	StartsAt  time.Time `json:"starts_at"`
+	Available bool      `json:"available"`
+}
+
+type apiAppointment struct {
+	ID              int64     `json:"id"`
+	Doctor          string    `json:"doctor"`
+	Date            string    `json:"date"`
+	Time            string    `json:"time"`
+	StartsAt        time.Time `json:"starts_at"`
+	DurationMinutes int       `json:"duration_minutes"`
+	Status          string    `json:"status"`
+}
+
+type apiPrescription struct {
+	ID           int64     `json:"id"`
+	Medicine     string    `json:"medicine"`
+	Dosage       string    `json:"dosage"`
+	Quantity     int       `json:"quantity"`
+	PrescribedBy string    `json:"prescribed_by"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// apiPharmacyOrder is an order of a prescription; Reference is the
+// pharmacy's, once it has accepted the order.
+type apiPharmacyOrder struct {
+	ID             int64     `json:"id"`
+	PrescriptionID int64     `json:"prescription_id"`
+	Status         string    `json:"status"`
+	Reference      string    `json:"reference,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// APIRegisterHandler creates a patient account and logs it in.
+func (s *Server) APIRegisterHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Email == "" {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "email is required")
+		return
+	}
+
+	user, err := s.register(r.Context(), req.Email, req.Password)
+	var invalid *invalidError
+	switch {
+	case errors.As(err, &invalid):
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", invalid.msg)
+		return
+	case errors.Is(err, store.ErrConflict):
+		writeAPIError(w, http.StatusConflict, "conflict", "Email already exists")
+		return
+	case err != nil:
+		apiServerError(w, r, "Error inserting user into database", err)
+		return
+	}
+	s.writeAPISession(w, r, http.StatusCreated, user)
+}
+
+// APILoginHandler exchanges an email and password for a session token,
+// or, for users with two-factor authentication, for a challenge to pass
+// to APILoginSecondFactorHandler. It is throttled like the login page.
+func (s *Server) APILoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	ctx := r.Context()
+	now := time.Now()
+	defer padUntil(ctx, now.Add(loginMinDuration))
+
+	user, step, err := s.login(ctx, req.Email, req.Password, clientIP(r), now)
+	if !apiLoginError(w, r, err) {
+		return
+	}
+	switch step {
+	case challengeCode:
+		writeJSON(w, http.StatusAccepted, apiChallenge{SecondFactor: step, Challenge: s.challengeToken(user.ID, step)})
+		return
+	case challengeEnrol:
+		writeAPIError(w, http.StatusForbidden, "two_factor_enrolment_required",
+			"Your role requires two-factor authentication; log in on the website once to set it up")
+		return
+	}
+	s.writeAPISession(w, r, http.StatusOK, user)
+}
+
+// APILoginSecondFactorHandler finishes a login with an authenticator or
+// recovery code.
+func (s *Server) APILoginSecondFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	userID, ok := s.parseChallenge(req.Challenge, challengeCode)
+	if !ok {
+		writeAPIError(w, http.StatusUnauthorized, "unauthenticated", "The challenge is invalid or has expired; log in again")
+		return
+	}
+
+	ctx := r.Context()
+	now := time.Now()
+	defer padUntil(ctx, now.Add(loginMinDuration))
+
+	user, err := s.loginSecondFactor(ctx, userID, req.Code, clientIP(r), now)
+	if !apiLoginError(w, r, err) {
+		return
+	}
+	s.writeAPISession(w, r, http.StatusOK, user)
+}
+
+// APILogoutHandler ends the session of the request's token.
+func (s *Server) APILogoutHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearerToken(r)
+	if !ok {
+		writeUnauthenticated(w)
+		return
+	}
+	if err := s.sessions.DeleteSession(r.Context(), hashToken(token)); err != nil {
+		apiServerError(w, r, "Error ending session", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIMeHandler describes the logged-in user.
+func (s *Server) APIMeHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.apiUser(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, toAPIUser(user))
+}
+
+// APIDoctorsHandler lists the doctors.
+func (s *Server) APIDoctorsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.apiUser(w, r); !ok {
+		return
+	}
+	size, cursor, ok := pageRequest(w, r)
+	if !ok {
+		return
+	}
+	afterID, ok := idCursor(w, cursor)
+	if !ok {
+		return
+	}
+	doctors, err := s.doctors.DoctorsAfter(r.Context(), afterID, size+1)
+	if err != nil {
+		apiServerError(w, r, "Error fetching doctors", err)
+		return
+	}
+	items := make([]apiDoctor, len(doctors))
+	for i, d := range doctors {
+		items[i] = apiDoctor{ID: d.ID, Name: d.Name, Specialty: d.Specialty, Experience: d.Experience, PhotoURL: d.PhotoURL}
+	}
+	writePage(w, items, size, func(d apiDoctor) string { return strconv.FormatInt(d.ID, 10) })
+}
+
+// APIDoctorSlotsHandler lists the doctor's slots that can still be
+// booked, on the date query parameter or, without it, on every date of
+// the booking window, marking the ones already taken.
+func (s *Server) APIDoctorSlotsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.apiUser(w, r); !ok {
+		return
+	}
+	doctor, err := s.doctors.DoctorByName(r.Context(), r.PathValue("name"))
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "No such doctor")
+		return
+	}
+	if err != nil {
+		apiServerError(w, r, "Error fetching doctor", err)
+		return
+	}
+
+	now := time.Now()
+	var dates []string
+	for _, d := range schedule.Dates(now, s.location) {
+		dates = append(dates, schedule.Date(d, s.location))
+	}
+	if date := r.URL.Query().Get("date"); date != "" {
+		if !slices.Contains(dates, date) {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request",
+				fmt.Sprintf("date must be one of the next %d days, as YYYY-MM-DD", schedule.BookingWindowDays))
+			return
+		}
+		dates = []string{date}
+	}
+
+	booked, err := s.bookedSlots(r.Context(), doctor.Name)
+	if err != nil {
+		apiServerError(w, r, "Error fetching booked slots", err)
+		return
+	}
+	items := []apiSlot{}
+	for _, date := range dates {
+		for _, clock := range schedule.Times {
+			start, err := schedule.ParseSlot(date, clock, s.location)
+			if err != nil || schedule.Validate(start, now, s.location) != nil {
+				continue
+			}
+			items = append(items, apiSlot{Date: date, Time: clock, StartsAt: start, Available: !slices.Contains(booked[date], clock)})
+		}
+	}
+	writeJSON(w, http.StatusOK, apiPage[apiSlot]{Items: items})
+}
+
+// APIAppointmentsHandler lists the patient's upcoming appointments,
+// earliest first.
+func (s *Server) APIAppointmentsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.apiUser(w, r)
+	if !ok {
+		return
+	}
+	size, cursor, ok := pageRequest(w, r)
+	if !ok {
+		return
+	}
+	// Like upcomingAppointments, but a page at a time.
+	f := store.AppointmentFilter{UserID: user.ID, From: schedule.StartOfDay(time.Now(), s.location), Limit: size + 1}
+	if cursor != "" {
+		after, err := store.ParseAppointmentCursor(cursor)
+		if err != nil {
+			invalidPageToken(w)
+			return
+		}
+		f.After = after
+	}
+
+	upcoming, err := s.appointments.SearchAppointments(r.Context(), f)
+	if err != nil {
+		apiServerError(w, r, "Error querying appointments", err)
+		return
+	}
+	items := make([]apiAppointment, len(upcoming))
+	for i, a := range upcoming {
+		items[i] = s.toAPIAppointment(a)
+	}
+	writePage(w, items, size, func(a apiAppointment) string {
+		return store.AppointmentCursor{StartsAt: a.StartsAt, ID: a.ID}.String()
+	})
+}
+
+// APIBookAppointmentHandler books a slot. An Idempotency-Key header makes
+// the request safe to retry: repeats book only once.
+func (s *Server) APIBookAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.apiUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Doctor string `json:"doctor"`
+		Date   string `json:"date"`
+		Time   string `json:"time"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	a, err := s.bookAppointment(r.Context(), user, req.Doctor, req.Date, req.Time, r.Header.Get("Idempotency-Key"))
+	var invalid *invalidError
+	switch {
+	case errors.Is(err, errUnverified):
+		writeAPIError(w, http.StatusForbidden, "email_unverified", "Verify your email address before booking appointments")
+		return
+	case errors.As(err, &invalid):
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", invalid.msg)
+		return
+	case errors.Is(err, store.ErrConflict):
+		writeAPIError(w, http.StatusConflict, "conflict", "This slot is already booked")
+		return
+	case errors.Is(err, errUnavailable):
+		slog.WarnContext(r.Context(), "Appointment service unavailable", "error", err)
+		w.Header().Set("Retry-After", maintenanceRetryAfter)
+		writeAPIError(w, http.StatusServiceUnavailable, "unavailable", "Appointment booking is temporarily unavailable")
+		return
+	case err != nil:
+		apiServerError(w, r, "Failed to create appointment", err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/appointments/%d", a.ID))
+	writeJSON(w, http.StatusCreated, s.toAPIAppointment(a))
+}
+
+// APICancelAppointmentHandler cancels one of the patient's upcoming
+// appointments.
+func (s *Server) APICancelAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.apiUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "Invalid appointment ID")
+		return
+	}
+	err = s.cancelAppointment(r.Context(), user.ID, id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Appointment not found")
+		return
+	}
+	if err != nil {
+		apiServerError(w, r, "Error cancelling appointment", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIPrescriptionsHandler lists the patient's prescriptions, newest first.
+func (s *Server) APIPrescriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.apiUser(w, r)
+	if !ok {
+		return
+	}
+	size, cursor, ok := pageRequest(w, r)
+	if !ok {
+		return
+	}
+	beforeID, ok := idCursor(w, cursor)
+	if !ok {
+		return
+	}
+	prescriptions, err := s.prescriptions.Prescriptions(r.Context(), user.ID, beforeID, size+1)
+	if err != nil {
+		apiServerError(w, r, "Error querying prescriptions", err)
+		return
+	}
+	items := make([]apiPrescription, len(prescriptions))
+	for i, rx := range prescriptions {
+		items[i] = toAPIPrescription(rx)
+	}
+	writePage(w, items, size, func(rx apiPrescription) string { return strconv.FormatInt(rx.ID, 10) })
+}
+
+// APIPrescribeHandler lets a doctor write a patient a prescription.
+func (s *Server) APIPrescribeHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.apiUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		PatientEmail string `json:"patient_email"`
+		Medicine     string `json:"medicine"`
+		Dosage       string `json:"dosage"`
+		Quantity     int    `json:"quantity"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	rx, err := s.prescribe(r.Context(), user, req.PatientEmail, req.Medicine, req.Dosage, req.Quantity)
+	var invalid *invalidError
+	switch {
+	case errors.Is(err, errNotDoctor):
+		writeAPIError(w, http.StatusForbidden, "forbidden", "Only doctors can write prescriptions")
+		return
+	case errors.As(err, &invalid):
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", invalid.msg)
+		return
+	case errors.Is(err, store.ErrNotFound):
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "No patient with that email")
+		return
+	case err != nil:
+		apiServerError(w, r, "Error writing prescription", err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, toAPIPrescription(rx))
+}
+
+// APIPharmacyOrdersHandler lists the patient's pharmacy orders, newest
+// first.
+func (s *Server) APIPharmacyOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.apiUser(w, r)
+	if !ok {
+		return
+	}
+	size, cursor, ok := pageRequest(w, r)
+	if !ok {
+		return
+	}
+	beforeID, ok := idCursor(w, cursor)
+	if !ok {
+		return
+	}
+	orders, err := s.pharmacyOrders.PharmacyOrders(r.Context(), user.ID, beforeID, size+1)
+	if err != nil {
+		apiServerError(w, r, "Error querying pharmacy orders", err)
+		return
+	}
+	items := make([]apiPharmacyOrder, len(orders))
+	for i, o := range orders {
+		items[i] = toAPIPharmacyOrder(o)
+	}
+	writePage(w, items, size, func(o apiPharmacyOrder) string { return strconv.FormatInt(o.ID, 10) })
+}
+
+// APIOrderPrescriptionHandler orders one of the patient's prescriptions
+// from the pharmacy. It is safe to retry: a prescription is ordered once.
+func (s *Server) APIOrderPrescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.apiUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		PrescriptionID int64 `json:"prescription_id"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	order, err := s.orderPrescription(r.Context(), user, req.PrescriptionID)
+	switch {
+	case errors.Is(err, errUnverified):
+		writeAPIError(w, http.StatusForbidden, "email_unverified", "Verify your email address before ordering medicine")
+		return
+	case errors.Is(err, store.ErrNotFound):
+		writeAPIError(w, http.StatusNotFound, "not_found", "Prescription not found")
+		return
+	case errors.Is(err, store.ErrConflict):
+		writeAPIError(w, http.StatusConflict, "conflict", "This prescription has already been ordered")
+		return
+	case errors.Is(err, errUnavailable):
+		slog.WarnContext(r.Context(), "Pharmacy service unavailable", "error", err)
+		w.Header().Set("Retry-After", maintenanceRetryAfter)
+		writeAPIError(w, http.StatusServiceUnavailable, "unavailable", "The pharmacy is temporarily unavailable; the order is kept and can be sent again")
+		return
+	case err != nil:
+		apiServerError(w, r, "Error placing pharmacy order", err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, toAPIPharmacyOrder(order))
+}
+
+// OpenAPIHandler serves the OpenAPI description of the API.
+func (s *Server) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	serveOpenAPI(w, openAPI)
+}
+
+// apiUser loads the user of the request's bearer token, answering 401
+// and returning false without a valid one.
+func (s *Server) apiUser(w http.ResponseWriter, r *http.Request) (store.User, bool) {
+	user, err := s.bearerUser(r)
+	if errors.Is(err, errNoSession) {
+		writeUnauthenticated(w)
+		return store.User{}, false
+	}
+	if err != nil {
+		apiServerError(w, r, "Error loading session", err)
+		return store.User{}, false
+	}
+	return user, true
+}
+
+var errNoSession = errors.New("missing or invalid session token")
+
+// bearerUser loads the user of the request's bearer token, returning
+// errNoSession without a valid one.
+func (s *Server) bearerUser(r *http.Request) (store.User, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return store.User{}, errNoSession
+	}
+	sess, err := s.sessions.SessionByTokenHash(r.Context(), hashToken(token), time.Now())
+	if errors.Is(err, store.ErrNotFound) {
+		return store.User{}, errNoSession
+	}
+	if err != nil {
+		return store.User{}, err
+	}
+	user, err := s.users.UserByID(r.Context(), sess.UserID)
+	if errors.Is(err, store.ErrNotFound) {
+		return store.User{}, errNoSession
+	}
+	return user, err
+}
+
+// writeAPISession starts a session for user and sends its token.
+func (s *Server) writeAPISession(w http.ResponseWriter, r *http.Request, code int, user store.User) {
+	token, expires, err := s.createSession(r.Context(), user)
+	if err != nil {
+		apiServerError(w, r, "Error starting session", err)
+		return
+	}
+	writeJSON(w, code, apiSession{Token: token, ExpiresAt: expires, User: toAPIUser(user)})
+}
+
+// apiLoginError answers a refused login and returns false, or returns true
+// if err is nil.
+func apiLoginError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var throttled *throttledError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", throttled.retryAfter())
+		writeAPIError(w, http.StatusTooManyRequests, "too_many_requests", "Too many login attempts; please try again later")
+	case errors.Is(err, errInvalidLogin):
+		writeAPIError(w, http.StatusUnauthorized, "unauthenticated", "Invalid email or password")
+	case errors.Is(err, errInvalidCode):
+		writeAPIError(w, http.StatusUnauthorized, "unauthenticated", "That code is not valid")
+	default:
+		apiServerError(w, r, "Error logging in", err)
+	}
+	return false
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, ok && token != ""
+}
+
+func toAPIUser(u store.User) apiUser {
+	return apiUser{ID: u.ID, Email: u.Email, Role: u.Role, EmailVerified: !u.VerifiedAt.IsZero()}
+}
+
+func (s *Server) toAPIAppointment(a store.Appointment) apiAppointment {
+	return apiAppointment{
+		ID:              a.ID,
+		Doctor:          a.DoctorName,
+		Date:            schedule.Date(a.StartsAt, s.location),
+		Time:            schedule.Clock(a.StartsAt, s.location),
+		StartsAt:        a.StartsAt,
+		DurationMinutes: int(a.Duration / time.Minute),
+		Status:          a.Status,
+	}
+}
+
+func toAPIPrescription(rx store.Prescription) apiPrescription {
+	return apiPrescription{ID: rx.ID, Medicine: rx.Medicine, Dosage: rx.Dosage, Quantity: rx.Quantity, PrescribedBy: rx.PrescribedBy, CreatedAt: rx.CreatedAt}
+}
+
+func toAPIPharmacyOrder(o store.PharmacyOrder) apiPharmacyOrder {
+	return apiPharmacyOrder{ID: o.ID, PrescriptionID: o.PrescriptionID, Status: o.Status, Reference: o.Reference, CreatedAt: o.CreatedAt}
+}
+
+// decodeJSON reads the request body into v, answering 400 and returning
+// false if it is not a JSON object of v's fields.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// Listings are paged with cursors: a page token holds the position of the
+// previous page's last item, from which the store query continues. Tokens
+// are opaque to clients so the paging scheme can change.
+
+// pageRequest returns the page size and cursor asked for by the page_size
+// and page_token query parameters, or false after writing an error.
+func pageRequest(w http.ResponseWriter, r *http.Request) (int, string, bool) {
+	size := apiPageSize
+	if v := r.URL.Query().Get("page_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > apiMaxPageSize {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("page_size must be between 1 and %d", apiMaxPageSize))
+			return 0, "", false
+		}
+		size = n
+	}
+	var cursor string
+	if v := r.URL.Query().Get("page_token"); v != "" {
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil || len(b) == 0 {
+			invalidPageToken(w)
+			return 0, "", false
+		}
+		cursor = string(b)
+	}
+	return size, cursor, true
+}
+
+// idCursor returns the ID in cursor, the position in a listing ordered
+// by ID, or zero for the first page. It returns false after writing an
+// error.
+func idCursor(w http.ResponseWriter, cursor string) (int64, bool) {
+	if cursor == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || id <= 0 {
+		invalidPageToken(w)
+		return 0, false
+	}
+	return id, true
+}
+
+func invalidPageToken(w http.ResponseWriter) {
+	writeAPIError(w, http.StatusBadRequest, "invalid_request", "Invalid page_token")
+}
+
+// writePage sends a page of items, which the store was asked for one more
+// of than size to tell whether there is a next page. cursor gives an
+// item's position for the next page's token.
+func writePage[T any](w http.ResponseWriter, items []T, size int, cursor func(T) string) {
+	page := apiPage[T]{Items: items}
+	if len(items) > size {
+		page.Items = items[:size]
+		page.NextPageToken = base64.RawURLEncoding.EncodeToString([]byte(cursor(items[size-1])))
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error encoding response", "error", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, code int, errCode, message string) {
+	writeJSON(w, code, apiErrorBody{apiError{Code: errCode, Message: message}})
+}
+
+func writeUnauthenticated(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	writeAPIError(w, http.StatusUnauthorized, "unauthenticated", "A valid bearer token is required")
+}
+
+// apiServerError logs err and answers 500 without details.
+func apiServerError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	slog.ErrorContext(r.Context(), msg, "error", err)
+	writeAPIError(w, http.StatusInternalServerError, "internal", "Server error")
+}
+
+// apiFallback answers the requests mux has no route for, 404 or 405, in
+// the error envelope rather than as plain text.
+func apiFallback(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		if allow := allowedMethods(mux, r); len(allow) > 0 {
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+			writeAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed; see the Allow header")
+			return
+		}
+		writeAPIError(w, http.StatusNotFound, "not_found", "No such API endpoint")
+	})
+}
+
+// apiMethods are the methods API routes can be declared with, in the
+// order of the Allow header.
+var apiMethods = []string{http.MethodDelete, http.MethodGet, http.MethodHead, http.MethodPatch, http.MethodPost, http.MethodPut}
+
+// allowedMethods returns the methods mux has a route for at the path of r.
+func allowedMethods(mux *http.ServeMux, r *http.Request) []string {
+	var allow []string
+	for _, method := range apiMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		if _, pattern := mux.Handler(probe); pattern != "" {
+			allow = append(allow, method)
+		}
+	}
+	return allow
+}
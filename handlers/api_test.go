@@ -0,0 +1,393 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"shubam/email"
+	"shubam/metrics"
+	pharmacypb "shubam/proto/pharmacy"
+	pbv2 "shubam/proto/v2"
+	"shubam/schedule"
+	"shubam/store"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mailbox keeps the messages sent instead of delivering them.
+type mailbox struct {
+	mu       sync.Mutex
+	messages []email.Message
+}
+
+func (m *mailbox) Send(ctx context.Context, msg email.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// link returns the path and query of the link in the last message sent.
+func (m *mailbox) link(t *testing.T) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.messages) == 0 {
+		t.Fatal("no email sent")
+	}
+	found := regexp.MustCompile(`https?://\S+`).FindString(m.messages[len(m.messages)-1].Body)
+	u, err := url.Parse(found)
+	if err != nil || found == "" {
+		t.Fatalf("no link in email %q", m.messages[len(m.messages)-1].Body)
+	}
+	return u.RequestURI()
+}
+
+// appointmentServer stands in for the appointment server, booking
+// straight into the store.
+type appointmentServer struct {
+	pbv2.HospitalServiceClient
+	appointments store.AppointmentStore
+}
+
+func (f appointmentServer) Appointment(ctx context.Context, req *pbv2.AppointmentRequest, opts ...grpc.CallOption) (*pbv2.AppointmentResponse, error) {
+	userID, err := strconv.ParseInt(req.UserId, 10, 64)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user ID")
+	}
+	a, err := f.appointments.CreateAppointment(ctx, store.Appointment{
+		DoctorName: req.DoctorName,
+		UserID:     userID,
+		Email:      req.Email,
+		StartsAt:   req.Start.AsTime(),
+		Duration:   req.Duration.AsDuration(),
+	})
+	if errors.Is(err, store.ErrConflict) {
+		return nil, status.Error(codes.AlreadyExists, "slot is already booked")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pbv2.AppointmentResponse{Message: "Appointment scheduled successfully", Id: a.ID}, nil
+}
+
+// pharmacy stands in for the pharmacy service, refusing calls while down.
+type pharmacy struct {
+	mu     sync.Mutex
+	down   bool
+	orders []*pharmacypb.PlaceOrderRequest
+}
+
+func (p *pharmacy) PlaceOrder(ctx context.Context, req *pharmacypb.PlaceOrderRequest, opts ...grpc.CallOption) (*pharmacypb.PlaceOrderResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.down {
+		return nil, status.Error(codes.Unavailable, "pharmacy is down")
+	}
+	p.orders = append(p.orders, req)
+	return &pharmacypb.PlaceOrderResponse{Reference: "PH-" + req.OrderId}, nil
+}
+
+// newTestServer returns the web server's routes over an in-memory store.
+func newTestServer(t *testing.T) (http.Handler, *mailbox) {
+	h, mail, _, _ := newTestServerWithStore(t)
+	return h, mail
+}
+
+// newTestServerWithStore is newTestServer also returning its store and
+// pharmacy.
+func newTestServerWithStore(t *testing.T) (http.Handler, *mailbox, *store.Memory, *pharmacy) {
+	t.Helper()
+	s, mail, mem, pharm := newTestService(t)
+	return s.Routes(), mail, mem, pharm
+}
+
+// newTestService returns the Server behind newTestServer, for tests of
+// the service layer.
+func newTestService(t *testing.T) (*Server, *mailbox, *store.Memory, *pharmacy) {
+	t.Helper()
+	mem := store.NewMemory(
+		store.Doctor{Name: "Dr. John Doe", Specialty: "Cardiology"},
+		store.Doctor{Name: "Dr. Jane Doe", Specialty: "Neurology"},
+	)
+	mail := &mailbox{}
+	pharm := &pharmacy{}
+	s, err := New(Options{
+		Users:             mem,
+		Appointments:      mem,
+		Doctors:           mem,
+		LoginAttempts:     mem,
+		Verifications:     mem,
+		Sessions:          mem,
+		PasswordResets:    mem,
+		TwoFactor:         mem,
+		Prescriptions:     mem,
+		PharmacyOrders:    mem,
+		Mailer:            mail,
+		PublicURL:         "http://hospital.test",
+		LinkKey:           []byte("test-link-key"),
+		AppointmentClient: appointmentServer{appointments: mem},
+		PharmacyClient:    pharm,
+		Location:          time.UTC,
+		TemplateDir:       "../Static",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, mail, mem, pharm
+}
+
+// call makes a request to h and decodes the JSON response into out, if
+// not nil.
+func call(t *testing.T, h http.Handler, method, path, token, body string, out any) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = "192.0.2.1:1234"
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, path, rec.Body, err)
+		}
+	}
+	return rec.Result()
+}
+
+func TestAPIBookingFlow(t *testing.T) {
+	h, mail := newTestServer(t)
+
+	var registered apiSession
+	resp := call(t, h, "POST", "/api/v1/auth/register", "", `{"email": "ann@example.com", "password": "correct horse battery"}`, &registered)
+	if resp.StatusCode != http.StatusCreated || registered.Token == "" || registered.User.EmailVerified {
+		t.Fatalf("register: %d %+v, want 201 with an unverified user", resp.StatusCode, registered)
+	}
+	if resp := call(t, h, "POST", "/api/v1/auth/register", "", `{"email": "ann@example.com", "password": "correct horse battery"}`, nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("registering twice: %d, want 409", resp.StatusCode)
+	}
+
+	var session apiSession
+	resp = call(t, h, "POST", "/api/v1/auth/login", "", `{"email": "ann@example.com", "password": "correct horse battery"}`, &session)
+	if resp.StatusCode != http.StatusOK || session.Token == "" {
+		t.Fatalf("login: %d %+v, want 200 with a token", resp.StatusCode, session)
+	}
+	token := session.Token
+
+	tomorrow := schedule.Date(time.Now().Add(24*time.Hour), time.UTC)
+	booking := `{"doctor": "Dr. John Doe", "date": "` + tomorrow + `", "time": "09:00"}`
+	var apiErr apiErrorBody
+	resp = call(t, h, "POST", "/api/v1/appointments", token, booking, &apiErr)
+	if resp.StatusCode != http.StatusForbidden || apiErr.Error.Code != "email_unverified" {
+		t.Fatalf("booking unverified: %d %+v, want 403 email_unverified", resp.StatusCode, apiErr)
+	}
+
+	if resp := call(t, h, "GET", mail.link(t), "", "", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("following the verification link: %d, want 200", resp.StatusCode)
+	}
+	var me apiUser
+	if resp := call(t, h, "GET", "/api/v1/me", token, "", &me); resp.StatusCode != http.StatusOK || !me.EmailVerified {
+		t.Fatalf("me after verifying: %d %+v, want a verified user", resp.StatusCode, me)
+	}
+
+	var booked apiAppointment
+	resp = call(t, h, "POST", "/api/v1/appointments", token, booking, &booked)
+	if resp.StatusCode != http.StatusCreated || booked.Date != tomorrow || booked.Time != "09:00" || booked.DurationMinutes != 60 {
+		t.Fatalf("booking: %d %+v, want 201 for %s 09:00", resp.StatusCode, booked, tomorrow)
+	}
+	if resp := call(t, h, "POST", "/api/v1/appointments", token, booking, nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("booking a taken slot: %d, want 409", resp.StatusCode)
+	}
+
+	var slots apiPage[apiSlot]
+	call(t, h, "GET", "/api/v1/doctors/Dr.%20John%20Doe/slots?date="+tomorrow, token, "", &slots)
+	for _, slot := range slots.Items {
+		if slot.Available == (slot.Time == "09:00") {
+			t.Errorf("slot %s %s available = %v", slot.Date, slot.Time, slot.Available)
+		}
+	}
+
+	var list apiPage[apiAppointment]
+	if call(t, h, "GET", "/api/v1/appointments", token, "", &list); len(list.Items) != 1 || list.Items[0].ID != booked.ID {
+		t.Fatalf("appointments = %+v, want the one booked", list)
+	}
+	path := "/api/v1/appointments/" + strconv.FormatInt(booked.ID, 10)
+	if resp := call(t, h, "DELETE", path, token, "", nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("cancelling: %d, want 204", resp.StatusCode)
+	}
+	if resp := call(t, h, "DELETE", path, token, "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("cancelling twice: %d, want 404", resp.StatusCode)
+	}
+
+	if resp := call(t, h, "POST", "/api/v1/auth/logout", token, "", nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("logout: %d, want 204", resp.StatusCode)
+	}
+	if resp := call(t, h, "GET", "/api/v1/me", token, "", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("me after logout: %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestAPIPaging(t *testing.T) {
+	h, _ := newTestServer(t)
+	var session apiSession
+	call(t, h, "POST", "/api/v1/auth/register", "", `{"email": "ann@example.com", "password": "correct horse battery"}`, &session)
+
+	var first, second apiPage[apiDoctor]
+	call(t, h, "GET", "/api/v1/doctors?page_size=1", session.Token, "", &first)
+	if len(first.Items) != 1 || first.Items[0].Name != "Dr. John Doe" || first.NextPageToken == "" {
+		t.Fatalf("first page = %+v, want Dr. John Doe and a token", first)
+	}
+	call(t, h, "GET", "/api/v1/doctors?page_size=1&page_token="+first.NextPageToken, session.Token, "", &second)
+	if len(second.Items) != 1 || second.Items[0].Name != "Dr. Jane Doe" || second.NextPageToken != "" {
+		t.Errorf("second page = %+v, want Dr. Jane Doe and no token", second)
+	}
+
+	for _, query := range []string{"page_size=0", "page_size=101", "page_token=bm9wZQ", "page_token=%21"} {
+		if resp := call(t, h, "GET", "/api/v1/appointments?"+query, session.Token, "", nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("appointments?%s: %d, want 400", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestAPIPrescriptionsAndOrders(t *testing.T) {
+	h, mail, mem, pharm := newTestServerWithStore(t)
+	register := func(email string) string {
+		t.Helper()
+		var session apiSession
+		if resp := call(t, h, "POST", "/api/v1/auth/register", "", `{"email": "`+email+`", "password": "correct horse battery"}`, &session); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("registering %s: %d", email, resp.StatusCode)
+		}
+		call(t, h, "GET", mail.link(t), "", "", nil)
+		return session.Token
+	}
+	ann := register("ann@example.com")
+	doctor := register("house@example.com")
+	house, err := mem.UserByEmail(context.Background(), "house@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.SetRole(context.Background(), house.ID, store.RoleDoctor); err != nil {
+		t.Fatal(err)
+	}
+
+	prescription := `{"patient_email": "ann@example.com", "medicine": "Tylenol", "dosage": "500 mg twice daily", "quantity": 20}`
+	prescribing := []struct {
+		name, token, body string
+		code              int
+	}{
+		{"as a patient", ann, prescription, http.StatusForbidden},
+		{"without a quantity", doctor, `{"patient_email": "ann@example.com", "medicine": "Tylenol", "dosage": "500 mg"}`, http.StatusBadRequest},
+		{"for an unknown patient", doctor, `{"patient_email": "nobody@example.com", "medicine": "Tylenol", "dosage": "500 mg", "quantity": 20}`, http.StatusBadRequest},
+	}
+	for _, tt := range prescribing {
+		if resp := call(t, h, "POST", "/api/v1/prescriptions", tt.token, tt.body, nil); resp.StatusCode != tt.code {
+			t.Errorf("prescribing %s: %d, want %d", tt.name, resp.StatusCode, tt.code)
+		}
+	}
+	var rx apiPrescription
+	if resp := call(t, h, "POST", "/api/v1/prescriptions", doctor, prescription, &rx); resp.StatusCode != http.StatusCreated || rx.PrescribedBy != "house@example.com" {
+		t.Fatalf("prescribing: %d %+v, want 201 by house@example.com", resp.StatusCode, rx)
+	}
+	var list apiPage[apiPrescription]
+	if call(t, h, "GET", "/api/v1/prescriptions", ann, "", &list); len(list.Items) != 1 || list.Items[0].ID != rx.ID {
+		t.Errorf("ann's prescriptions = %+v, want the one written", list)
+	}
+
+	order := `{"prescription_id": ` + strconv.FormatInt(rx.ID, 10) + `}`
+	pharm.down = true
+	if resp := call(t, h, "POST", "/api/v1/pharmacy/orders", ann, order, nil); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("ordering while the pharmacy is down: %d, want 503", resp.StatusCode)
+	}
+	var orders apiPage[apiPharmacyOrder]
+	if call(t, h, "GET", "/api/v1/pharmacy/orders", ann, "", &orders); len(orders.Items) != 1 || orders.Items[0].Status != store.OrderPending {
+		t.Errorf("orders after the pharmacy failed = %+v, want one pending", orders)
+	}
+
+	pharm.down = false
+	var placed apiPharmacyOrder
+	resp := call(t, h, "POST", "/api/v1/pharmacy/orders", ann, order, &placed)
+	if resp.StatusCode != http.StatusCreated || placed.Status != store.OrderPlaced || placed.Reference != "PH-"+strconv.FormatInt(placed.ID, 10) {
+		t.Fatalf("ordering again: %d %+v, want 201 placed", resp.StatusCode, placed)
+	}
+	if len(pharm.orders) != 1 || pharm.orders[0].Medicine != "Tylenol" || pharm.orders[0].Quantity != 20 || pharm.orders[0].Email != "ann@example.com" {
+		t.Errorf("pharmacy received %+v, want one order of 20 Tylenol for ann", pharm.orders)
+	}
+	if resp := call(t, h, "POST", "/api/v1/pharmacy/orders", ann, order, nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("ordering a placed prescription: %d, want 409", resp.StatusCode)
+	}
+	if resp := call(t, h, "POST", "/api/v1/pharmacy/orders", doctor, order, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("ordering another patient's prescription: %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	h, _ := newTestServer(t)
+
+	tests := []struct {
+		method, path, body string
+		code               int
+		errCode            string
+	}{
+		{"GET", "/api/v1/me", "", http.StatusUnauthorized, "unauthenticated"},
+		{"POST", "/api/v1/auth/login", `{"email": "bob@example.com", "password": "wrong password"}`, http.StatusUnauthorized, "unauthenticated"},
+		{"POST", "/api/v1/auth/login", `{"email": "bob@example.com", "extra": 1}`, http.StatusBadRequest, "invalid_request"},
+		{"POST", "/api/v1/auth/register", `{"email": "bob@example.com", "password": "short"}`, http.StatusBadRequest, "invalid_request"},
+		{"GET", "/api/v1/nothing", "", http.StatusNotFound, "not_found"},
+		{"PUT", "/api/v1/doctors", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+	}
+	for _, tt := range tests {
+		var body apiErrorBody
+		resp := call(t, h, tt.method, tt.path, "", tt.body, &body)
+		if resp.StatusCode != tt.code || body.Error.Code != tt.errCode {
+			t.Errorf("%s %s: %d %q, want %d %q", tt.method, tt.path, resp.StatusCode, body.Error.Code, tt.code, tt.errCode)
+		}
+	}
+	if resp := call(t, h, "DELETE", "/api/v1/appointments", "", "", nil); resp.Header.Get("Allow") != "GET, HEAD, POST" {
+		t.Errorf("Allow = %q, want %q", resp.Header.Get("Allow"), "GET, HEAD, POST")
+	}
+}
+
+func TestAPIEmailsAreNormalized(t *testing.T) {
+	h, _ := newTestServer(t)
+
+	var registered apiSession
+	if resp := call(t, h, "POST", "/api/v1/auth/register", "", `{"email": " Ann@Example.com ", "password": "correct horse battery"}`, &registered); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("register: %d, want 201", resp.StatusCode)
+	}
+	if registered.User.Email != "ann@example.com" {
+		t.Errorf("registered email = %q, want %q", registered.User.Email, "ann@example.com")
+	}
+	if resp := call(t, h, "POST", "/api/v1/auth/register", "", `{"email": "ann@example.com", "password": "correct horse battery"}`, nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("registering the same address in lower case: %d, want 409", resp.StatusCode)
+	}
+	if resp := call(t, h, "POST", "/api/v1/auth/login", "", `{"email": "ANN@example.COM", "password": "correct horse battery"}`, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("login in upper case: %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestAPILoginFailuresCounted(t *testing.T) {
+	h, _ := newTestServer(t)
+	before := testutil.ToFloat64(metrics.LoginFailures)
+	call(t, h, "POST", "/api/v1/auth/login", "", `{"email": "bob@example.com", "password": "wrong password"}`, nil)
+	call(t, h, "POST", "/api/v1/auth/login/2fa", "", `{"challenge": "forged", "code": "123456"}`, nil)
+	if got := testutil.ToFloat64(metrics.LoginFailures) - before; got != 2 {
+		t.Errorf("login failures counted = %v, want 2", got)
+	}
+}
@@ -1,94 +1,238 @@
 package handlers
 
 import (
-    "context"
-    "html/template"
-    "log"
-    "net/http"
-
-    pb "shubam/proto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"shubam/schedule"
+	"shubam/store"
 )
 
-var appointmentClient pb.HospitalServiceClient
-
-func AppointmentHandler(w http.ResponseWriter, r *http.Request) {
-    log.Println("AppointmentHandler called")  // Debug log
-
-    userIDCookie, err := r.Cookie("userID")
-    if err != nil {
-        http.Error(w, "Missing user ID", http.StatusBadRequest)
-        return
-    }
-    userEmailCookie, err := r.Cookie("userEmail")
-    if err != nil {
-        http.Error(w, "Missing user email", http.StatusBadRequest)
-        return
-    }
-
-    log.Println("Cookies:", userIDCookie, userEmailCookie)  // Debug log
-
-    if r.Method == http.MethodGet {
-        tmpl, err := template.ParseFiles("Static/appointment.html")
-        if err != nil {
-            log.Printf("Error parsing appointment template: %v\n", err)
-            http.Error(w, "Error loading appointment page", http.StatusInternalServerError)
-            return
-        }
-
-        data := struct {
-            UserID    string
-            UserEmail string
-        }{
-            UserID:    userIDCookie.Value,
-            UserEmail: userEmailCookie.Value,
-        }
-
-        tmpl.Execute(w, data)
-        return
-    }
-
-    if r.Method == http.MethodPost {
-        err := r.ParseForm()
-        if err != nil {
-            http.Error(w, "Parse form error", http.StatusInternalServerError)
-            return
-        }
-
-        doctorName := r.FormValue("doctor")
-        date := r.FormValue("date")
-        time := r.FormValue("time")
-
-        if date == "" {
-            http.Error(w, "Date is required", http.StatusBadRequest)
-            return
-        }
-
-        if time == "" {
-            http.Error(w, "Time is required", http.StatusBadRequest)
-            return
-        }
-
-        // Add debug log for form values
-        log.Println("Form values:", doctorName, date, time)
-
-        req := &pb.AppointmentRequest{
-            DoctorName: doctorName,
-            UserId:     userIDCookie.Value,
-            Email:      userEmailCookie.Value,
-            Date:       date,
-            Time:       time,
-        }
-
-        log.Println("AppointmentClient:", appointmentClient)  // Debug log
-
-        resp, err := appointmentClient.Appointment(context.Background(), req)
-        if err != nil {
-            log.Printf("Failed to create appointment: %v", err)
-            http.Error(w, "Failed to create appointment", http.StatusInternalServerError)
-            return
-        }
-
-        log.Println(resp.Message)
-        http.Redirect(w, r, "/service", http.StatusSeeOther)
-    }
+type PageData struct {
+	UserID       string
+	UserEmail    string
+	Appointments []Appointment
+}
+
+type Appointment struct {
+	ID         int64
+	DoctorName string
+	Degree     string
+	Experience string
+	Date       string
+	Time       string
+}
+
+// SlotDate is one day button on the booking page.
+type SlotDate struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+}
+
+// BookingPageHandler shows the doctors with their bookable dates and
+// times.
+func (s *Server) BookingPageHandler(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	doctors, err := s.doctors.Doctors(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching doctors", "error", err)
+		http.Error(w, "Error loading appointment page", http.StatusInternalServerError)
+		return
+	}
+
+	// Dates and times are generated here in the hospital time zone so
+	// the page never has to derive them from the browser's clock.
+	var dates []SlotDate
+	for _, d := range schedule.Dates(time.Now(), s.location) {
+		dates = append(dates, SlotDate{Value: schedule.Date(d, s.location), Label: d.Format("Mon Jan 02 2006")})
+	}
+
+	data := struct {
+		UserID       string
+		UserEmail    string
+		Doctors      []store.Doctor
+		Dates        []SlotDate
+		Times        []string
+		BookingNonce string
+	}{
+		UserID:       sess.UserID,
+		UserEmail:    sess.UserEmail,
+		Doctors:      doctors,
+		Dates:        dates,
+		Times:        schedule.Times,
+		BookingNonce: newNonce(),
+	}
+
+	s.render(w, r, "appointment.html", data)
+}
+
+// BookAppointmentHandler books the slot chosen on the booking page.
+func (s *Server) BookAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Parse form error", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = s.bookAppointment(r.Context(), user, r.FormValue("doctor"), r.FormValue("date"), r.FormValue("time"), r.FormValue("booking_nonce"))
+	var invalid *invalidError
+	switch {
+	case errors.Is(err, errUnverified):
+		w.WriteHeader(http.StatusForbidden)
+		s.render(w, r, "verify.html", verifyPage{
+			Title:   "Verify your email first",
+			Message: "Follow the link we emailed you to verify your address before booking appointments.",
+			Resend:  true,
+		})
+		return
+	case errors.As(err, &invalid):
+		http.Error(w, invalid.msg, http.StatusBadRequest)
+		return
+	case errors.Is(err, store.ErrConflict):
+		http.Error(w, "This slot has just been booked; please choose another", http.StatusConflict)
+		return
+	case errors.Is(err, errUnavailable):
+		slog.WarnContext(r.Context(), "Appointment service unavailable", "error", err)
+		s.renderMaintenance(w, r, "Appointment booking")
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Failed to create appointment", "error", err)
+		http.Error(w, "Failed to create appointment", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/profile", http.StatusSeeOther)
+}
+
+// idempotencyKey names one booking attempt, so a resubmitted form or a
+// retried RPC cannot book twice. nonce comes from the rendered booking
+// page; the slot is mixed in so booking a different slot from the same
+// page is a different attempt.
+func idempotencyKey(nonce, doctorName string, start time.Time) string {
+	if nonce == "" {
+		nonce = newNonce()
+	}
+	sum := sha256.Sum256([]byte(nonce + "\x00" + doctorName + "\x00" + start.UTC().Format(time.RFC3339)))
+	return hex.EncodeToString(sum[:])
+}
+
+// newNonce returns 128 random bits in hex.
+func newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// BookedSlotsHandler lists the booked slots of the doctor named in the
+// path.
+func (s *Server) BookedSlotsHandler(w http.ResponseWriter, r *http.Request) {
+	doctorName := r.PathValue("name")
+	if doctorName == "" {
+		http.Error(w, "Doctor name is required", http.StatusBadRequest)
+		return
+	}
+
+	bookedSlots, err := s.bookedSlots(r.Context(), doctorName)
+	if err != nil {
+		http.Error(w, "Failed to fetch booked slots", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error fetching booked slots", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(bookedSlots)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding booked slots", "error", err)
+		return
+	}
+}
+
+func (s *Server) ProfileHandler(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.ParseInt(sess.UserID, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	upcoming, err := s.upcomingAppointments(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error querying appointments", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	var appointments []Appointment
+	for _, a := range upcoming {
+		appointments = append(appointments, Appointment{
+			ID:         a.ID,
+			DoctorName: a.DoctorName,
+			Date:       schedule.Date(a.StartsAt, s.location),
+			Time:       schedule.Clock(a.StartsAt, s.location),
+		})
+	}
+
+	data := PageData{
+		UserID:       sess.UserID,
+		UserEmail:    sess.UserEmail,
+		Appointments: appointments,
+	}
+
+	s.render(w, r, "profile.html", data)
+}
+
+// CancelHandler cancels the patient's upcoming appointment with the ID in
+// the path.
+func (s *Server) CancelHandler(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	appointmentID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Invalid appointment ID", "id", r.PathValue("id"))
+		http.Error(w, "Invalid appointment ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.ParseInt(sess.UserID, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	err = s.cancelAppointment(r.Context(), userID, appointmentID)
+	if errors.Is(err, store.ErrNotFound) {
+		slog.InfoContext(r.Context(), "No appointment to cancel", "appointment_id", appointmentID)
+		http.Error(w, "Appointment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error cancelling appointment", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
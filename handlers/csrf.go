@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"html/template"
+	"log/slog"
+	"net/http"
+)
+
+// Pages carry a CSRF token, which a cross-site form cannot know, signed
+// from the hash of the browser's session token, so each login gets its
+// own. Pages shown before login, which have no session, sign a random
+// secret kept in csrfCookie instead.
+const (
+	// csrfCookie holds the secret for browsers without a session. It is
+	// replaced when a session ends.
+	csrfCookie = "csrf"
+	// Forms send the token in csrfFormField, scripts in csrfHeader.
+	csrfFormField = "csrf_token"
+	csrfHeader    = "X-CSRF-Token"
+	csrfSigner    = "csrf"
+)
+
+// csrfState is what the request's CSRF token is signed from: "session:"
+// and the session token's hash, or "secret:" and the csrf cookie. The
+// context holds a pointer so a page rendered after login or logout gets
+// the new token.
+type csrfState struct {
+	binding string
+}
+
+// csrfStateFrom returns the CSRF state of the browser's cookies, with an
+// empty binding if it has neither a session nor a secret.
+func csrfStateFrom(r *http.Request) *csrfState {
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		return sessionCSRFState(c.Value)
+	}
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return &csrfState{binding: "secret:" + c.Value}
+	}
+	return &csrfState{}
+}
+
+func sessionCSRFState(token string) *csrfState {
+	return &csrfState{binding: "session:" + base64.RawURLEncoding.EncodeToString(hashToken(token))}
+}
+
+type csrfKey struct{}
+
+// csrfFuncs stand in for the per-request template functions at parse time.
+var csrfFuncs = template.FuncMap{
+	"csrfField": func() template.HTML { return "" },
+	"csrfToken": func() string { return "" },
+}
+
+// csrf rejects requests other than GET, HEAD, OPTIONS and TRACE that lack
+// the token of the browser's session or CSRF secret, and gives browsers
+// with neither a secret.
+func (s *Server) csrf(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := csrfStateFrom(r)
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		default:
+			token := r.Header.Get(csrfHeader)
+			if token == "" {
+				token = r.PostFormValue(csrfFormField)
+			}
+			if state.binding == "" || !hmac.Equal([]byte(token), []byte(s.csrfToken(state.binding))) {
+				slog.WarnContext(r.Context(), "CSRF token rejected", "method", r.Method, "path", r.URL.Path)
+				http.Error(w, "Invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+				return
+			}
+		}
+
+		if state.binding == "" {
+			s.setCSRFSecret(w, state)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfKey{}, state)))
+	})
+}
+
+// useSessionCSRF makes the rest of the request use the tokens of the
+// session with token, which has just started.
+func useSessionCSRF(r *http.Request, token string) {
+	if state, ok := r.Context().Value(csrfKey{}).(*csrfState); ok {
+		*state = *sessionCSRFState(token)
+	}
+}
+
+// rotateCSRF gives the browser a new CSRF secret once its session has
+// ended, so tokens from before stop working.
+func (s *Server) rotateCSRF(w http.ResponseWriter, r *http.Request) {
+	state, ok := r.Context().Value(csrfKey{}).(*csrfState)
+	if !ok {
+		state = &csrfState{}
+	}
+	s.setCSRFSecret(w, state)
+}
+
+func (s *Server) setCSRFSecret(w http.ResponseWriter, state *csrfState) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	state.binding = "secret:" + secret
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    secret,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Server) csrfToken(binding string) string {
+	return signLink(s.linkKey, csrfSigner, binding)
+}
+
+// csrfTemplateFuncs returns the template functions giving the request's
+// token: csrfField, a hidden form input, and csrfToken, the bare token.
+func (s *Server) csrfTemplateFuncs(r *http.Request) template.FuncMap {
+	var token string
+	if state, ok := r.Context().Value(csrfKey{}).(*csrfState); ok && state.binding != "" {
+		token = s.csrfToken(state.binding)
+	}
+	return template.FuncMap{
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + csrfFormField + `" value="` + template.HTMLEscapeString(token) + `">`)
+		},
+		"csrfToken": func() string { return token },
+	}
+}
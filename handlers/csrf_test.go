@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var csrfFieldPattern = regexp.MustCompile(`name="` + csrfFormField + `" value="([^"]*)"`)
+
+// browser keeps cookies across requests to a test server, without
+// following redirects.
+type browser struct {
+	t      *testing.T
+	base   string
+	client *http.Client
+}
+
+func newBrowser(t *testing.T, srv *httptest.Server) *browser {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &browser{t: t, base: srv.URL, client: &http.Client{
+		Jar:           jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}}
+}
+
+// token returns the CSRF token in the form on the page at path.
+func (b *browser) token(path string) string {
+	b.t.Helper()
+	resp, err := b.client.Get(b.base + path)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	m := csrfFieldPattern.FindSubmatch(body)
+	if m == nil {
+		b.t.Fatalf("GET %s: no CSRF field in %q", path, body)
+	}
+	return string(m[1])
+}
+
+func (b *browser) post(path string, form url.Values) int {
+	b.t.Helper()
+	resp, _ := b.submit(path, form)
+	return resp.StatusCode
+}
+
+// submit posts form to path and returns the response and its body.
+func (b *browser) submit(path string, form url.Values) (*http.Response, string) {
+	b.t.Helper()
+	resp, err := b.client.Post(b.base+path, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func (b *browser) get(path string) int {
+	b.t.Helper()
+	resp, err := b.client.Get(b.base + path)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// login submits the login form and returns where it redirects to.
+func (b *browser) login(email, password string) string {
+	b.t.Helper()
+	form := url.Values{"email": {email}, "password": {password}, csrfFormField: {b.token("/login")}}
+	resp, _ := b.submit("/login", form)
+	if resp.StatusCode != http.StatusSeeOther {
+		b.t.Fatalf("logging in as %s: %d, want 303", email, resp.StatusCode)
+	}
+	return resp.Header.Get("Location")
+}
+
+// register signs up email through the form, which logs the browser in,
+// and returns the token the register page had.
+func (b *browser) register(email string) string {
+	b.t.Helper()
+	token := b.token("/register")
+	form := url.Values{"email": {email}, "password": {"correct horse battery"}, csrfFormField: {token}}
+	if code := b.post("/register", form); code != http.StatusSeeOther {
+		b.t.Fatalf("registering %s: %d, want 303", email, code)
+	}
+	return token
+}
+
+func TestCSRFTokensBelongToTheSession(t *testing.T) {
+	h, _ := newTestServer(t)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	ann := newBrowser(t, srv)
+	if code := ann.post("/register", url.Values{"email": {"ann@example.com"}, "password": {"correct horse battery"}}); code != http.StatusForbidden {
+		t.Errorf("registering without a token: %d, want 403", code)
+	}
+	beforeLogin := ann.register("ann@example.com")
+	annToken := ann.token("/verify")
+	if annToken == beforeLogin {
+		t.Error("the token did not change at login")
+	}
+
+	bob := newBrowser(t, srv)
+	bob.register("bob@example.com")
+	bobToken := bob.token("/verify")
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"token from before login", beforeLogin, http.StatusForbidden},
+		{"another session's token", bobToken, http.StatusForbidden},
+		// Registering just sent a link, so the resend itself is refused.
+		{"own token", annToken, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		if code := ann.post("/verify/resend", url.Values{csrfFormField: {tt.token}}); code != tt.code {
+			t.Errorf("%s: %d, want %d", tt.name, code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"shubam/auth"
+	pb "shubam/proto"
+	pbv2 "shubam/proto/v2"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The gateway under /gateway translates JSON over HTTP into calls to the
+// appointment server's HospitalService, following the HTTP annotations in
+// proto/service.proto (v1) and proto/v2/service.proto (v2), so new RPCs
+// appear once annotated and regenerated. Like the JSON API it takes the
+// session token as a bearer token; calls are made on behalf of its user.
+// Errors are google.rpc.Status objects, as the generated specs describe.
+
+// newGateway returns the reverse proxy to the v2 service on client and, if
+// clientV1 is not nil, the v1 service.
+func newGateway(client pbv2.HospitalServiceClient, clientV1 pb.HospitalServiceClient) (*runtime.ServeMux, error) {
+	gw := runtime.NewServeMux(
+		// No HTTP header is forwarded as gRPC metadata: the Authorization
+		// header would clash with the call's own credentials, which name
+		// the patient.
+		runtime.WithIncomingHeaderMatcher(func(string) (string, bool) { return "", false }),
+		runtime.WithRoutingErrorHandler(func(ctx context.Context, mux *runtime.ServeMux, m runtime.Marshaler, w http.ResponseWriter, r *http.Request, code int) {
+			runtime.DefaultRoutingErrorHandler(withoutCall(ctx), mux, m, w, r, code)
+		}),
+	)
+	ctx := context.Background()
+	if err := pbv2.RegisterHospitalServiceHandlerClient(ctx, gw, client); err != nil {
+		return nil, fmt.Errorf("error registering v2 gateway: %w", err)
+	}
+	if clientV1 != nil {
+		if err := pb.RegisterHospitalServiceHandlerClient(ctx, gw, clientV1); err != nil {
+			return nil, fmt.Errorf("error registering v1 gateway: %w", err)
+		}
+	}
+	return gw, nil
+}
+
+// GatewayHandler authenticates the request's bearer token and passes the
+// request to the gateway. Only users who verified their email may call it,
+// as only they may book.
+func (s *Server) GatewayHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	_, marshaler := runtime.MarshalerForRequest(s.gateway, r)
+	fail := func(err error) {
+		runtime.HTTPError(withoutCall(ctx), s.gateway, marshaler, w, r, err)
+	}
+
+	user, err := s.bearerUser(r)
+	switch {
+	case errors.Is(err, errNoSession):
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		fail(status.Error(codes.Unauthenticated, "A valid bearer token is required"))
+		return
+	case err != nil:
+		slog.ErrorContext(ctx, "Error loading session", "error", err)
+		fail(status.Error(codes.Internal, "Server error"))
+		return
+	case user.VerifiedAt.IsZero():
+		fail(status.Error(codes.PermissionDenied, "Please verify your email address first"))
+		return
+	}
+
+	ctx = auth.WithPatient(ctx, strconv.FormatInt(user.ID, 10), user.Email)
+	s.gateway.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// withoutCall gives the gateway's error handler the empty metadata of a
+// call that was never made, which it otherwise logs as missing.
+func withoutCall(ctx context.Context) context.Context {
+	return runtime.NewServerMetadataContext(ctx, runtime.ServerMetadata{})
+}
+
+// GatewayOpenAPIHandler serves the OpenAPI description generated for the
+// v2 gateway.
+func (s *Server) GatewayOpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	serveOpenAPI(w, pbv2.OpenAPI)
+}
+
+// GatewayV1OpenAPIHandler serves the OpenAPI description generated for the
+// v1 gateway.
+func (s *Server) GatewayV1OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	serveOpenAPI(w, pb.OpenAPI)
+}
+
+func serveOpenAPI(w http.ResponseWriter, doc []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(doc)
+}
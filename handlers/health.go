@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// readinessTimeout bounds each dependency check made by /readyz.
+const readinessTimeout = 2 * time.Second
+
+// ReadinessCheck is one dependency reported by /readyz. Only critical
+// checks make the server unready when they fail.
+type ReadinessCheck struct {
+	Name     string
+	Critical bool
+	Check    func(ctx context.Context) error
+}
+
+// GRPCHealthCheck asks the server behind conn, using the standard
+// grpc.health.v1 protocol, whether service is serving.
+func GRPCHealthCheck(conn grpc.ClientConnInterface, service string) func(ctx context.Context) error {
+	client := healthpb.NewHealthClient(conn)
+	return func(ctx context.Context) error {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return err
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("%s is %s", service, resp.Status)
+		}
+		return nil
+	}
+}
+
+// Drain makes /readyz fail so load balancers stop sending new requests
+// while the server shuts down.
+func (s *Server) Drain() {
+	s.draining.Store(true)
+}
+
+// HealthzHandler reports that the process is up. It checks no
+// dependencies, so a database outage does not get the web tier restarted.
+func (s *Server) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// ReadyzHandler runs every readiness check concurrently and answers 200
+// only if the server is not draining and all critical checks pass.
+func (s *Server) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, len(s.readinessChecks))
+	for _, c := range s.readinessChecks {
+		go func(c ReadinessCheck) {
+			results <- result{c.Name, c.Check(ctx)}
+		}(c)
+	}
+
+	ready := !s.draining.Load()
+	checks := make(map[string]string, len(s.readinessChecks))
+	for range s.readinessChecks {
+		res := <-results
+		checks[res.name] = "ok"
+		if res.err != nil {
+			checks[res.name] = res.err.Error()
+			for _, c := range s.readinessChecks {
+				if c.Name == res.name && c.Critical {
+					ready = false
+				}
+			}
+		}
+	}
+
+	body := struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}{Status: "ready", Checks: checks}
+	code := http.StatusOK
+	if s.draining.Load() {
+		body.Status = "draining"
+	}
+	if !ready {
+		code = http.StatusServiceUnavailable
+		if body.Status == "ready" {
+			body.Status = "unavailable"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"shubam/store"
+)
+
+// Login throttling. Failures are counted per account (email) and per
+// client IP over loginWindow:
+//   - from loginBackoffAfter account failures on, each further attempt
+//     must wait twice as long as the last, up to loginMaxBackoff;
+//   - loginLockoutAfter failures lock the account for loginLockout;
+//   - loginIPLimit failures from one IP block it for loginIPBlock.
+//
+// Accounts are tracked by email whether or not they exist, so throttling
+// reveals nothing about which emails are registered.
+const (
+	loginWindow       = 15 * time.Minute
+	loginBackoffAfter = 3
+	loginBackoffBase  = time.Second
+	loginMaxBackoff   = 5 * time.Minute
+	loginLockoutAfter = 10
+	loginLockout      = 15 * time.Minute
+	loginIPLimit      = 50
+	loginIPBlock      = 15 * time.Minute
+
+	// loginMinDuration is the least time any login response takes, so
+	// unknown emails, wrong passwords and throttled attempts cannot be
+	// told apart by timing.
+	loginMinDuration = 500 * time.Millisecond
+)
+
+// loginDelay returns how long the client must wait before another attempt
+// is considered, or 0 if it may try now.
+func loginDelay(f store.LoginFailures, now time.Time) time.Duration {
+	var until time.Time
+	switch {
+	case f.Account >= loginLockoutAfter:
+		until = f.LastAccount.Add(loginLockout)
+	case f.Account >= loginBackoffAfter:
+		backoff := loginBackoffBase << (f.Account - loginBackoffAfter)
+		until = f.LastAccount.Add(min(backoff, loginMaxBackoff))
+	}
+	if f.IP >= loginIPLimit {
+		if blocked := f.LastIP.Add(loginIPBlock); blocked.After(until) {
+			until = blocked
+		}
+	}
+	return max(until.Sub(now), 0)
+}
+
+// clientIP is the address the request came from. X-Forwarded-For is not
+// trusted: a client could set it to dodge the per-IP limit.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// padUntil waits until deadline or until ctx is done.
+func padUntil(ctx context.Context, deadline time.Time) {
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
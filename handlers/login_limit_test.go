@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"shubam/store"
+)
+
+func TestLoginDelay(t *testing.T) {
+	now := time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+	tests := []struct {
+		name string
+		f    store.LoginFailures
+		want time.Duration
+	}{
+		{"no failures", store.LoginFailures{}, 0},
+		{"below the backoff", store.LoginFailures{Account: loginBackoffAfter - 1, LastAccount: now}, 0},
+		{"first backoff step", store.LoginFailures{Account: loginBackoffAfter, LastAccount: now}, time.Second},
+		{"second backoff step", store.LoginFailures{Account: loginBackoffAfter + 1, LastAccount: now}, 2 * time.Second},
+		{"last step before lockout", store.LoginFailures{Account: loginLockoutAfter - 1, LastAccount: now}, 64 * time.Second},
+		{"backoff partly waited", store.LoginFailures{Account: loginBackoffAfter + 1, LastAccount: ago(1500 * time.Millisecond)}, 500 * time.Millisecond},
+		{"backoff over", store.LoginFailures{Account: loginBackoffAfter + 1, LastAccount: ago(2 * time.Second)}, 0},
+		{"lockout", store.LoginFailures{Account: loginLockoutAfter, LastAccount: now}, loginLockout},
+		{"lockout partly waited", store.LoginFailures{Account: loginLockoutAfter + 5, LastAccount: ago(14 * time.Minute)}, time.Minute},
+		{"IP below the limit", store.LoginFailures{IP: loginIPLimit - 1, LastIP: now}, 0},
+		{"IP blocked", store.LoginFailures{IP: loginIPLimit, LastIP: now}, loginIPBlock},
+		{"IP block outlasts the backoff", store.LoginFailures{Account: loginBackoffAfter, LastAccount: now, IP: loginIPLimit, LastIP: ago(time.Minute)}, loginIPBlock - time.Minute},
+		{"lockout outlasts the IP block", store.LoginFailures{Account: loginLockoutAfter, LastAccount: now, IP: loginIPLimit, LastIP: ago(time.Minute)}, loginLockout},
+	}
+	for _, tt := range tests {
+		if got := loginDelay(tt.f, now); got != tt.want {
+			t.Errorf("%s: loginDelay = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoginThrottling(t *testing.T) {
+	s, _, _, _ := newTestService(t)
+	ctx := context.Background()
+	for _, email := range []string{"ann@example.com", "bob@example.com", "cat@example.com"} {
+		if _, err := s.register(ctx, email, "correct horse battery"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+
+	t.Run("backoff and reset", func(t *testing.T) {
+		steps := []struct {
+			after    time.Duration
+			password string
+			want     error // nil for success; errThrottled for a *throttledError
+		}{
+			{0, "wrong password", errInvalidLogin},
+			{time.Second, "wrong password", errInvalidLogin},
+			{2 * time.Second, "wrong password", errInvalidLogin},
+			// Three failures: the next attempt waits a second.
+			{2500 * time.Millisecond, "correct horse battery", errThrottled},
+			{3 * time.Second, "wrong password", errInvalidLogin},
+			// Four: two seconds.
+			{4 * time.Second, "wrong password", errThrottled},
+			{5 * time.Second, "correct horse battery", nil},
+			// The success reset the count.
+			{5 * time.Second, "wrong password", errInvalidLogin},
+			{5 * time.Second, "wrong password", errInvalidLogin},
+			{5 * time.Second, "wrong password", errInvalidLogin},
+			{5 * time.Second, "wrong password", errThrottled},
+		}
+		for i, step := range steps {
+			_, _, err := s.login(ctx, "ann@example.com", step.password, "192.0.2.1", start.Add(step.after))
+			if !sameLoginError(err, step.want) {
+				t.Errorf("step %d at +%v: %v, want %v", i, step.after, err, step.want)
+			}
+		}
+	})
+
+	t.Run("lockout", func(t *testing.T) {
+		at := fail(t, s, "bob@example.com", "192.0.2.2", start, loginLockoutAfter)
+		_, _, err := s.login(ctx, "BOB@example.com ", "correct horse battery", "192.0.2.3", at.Add(time.Minute))
+		var throttled *throttledError
+		if !errors.As(err, &throttled) || throttled.wait != loginLockout-time.Minute {
+			t.Fatalf("correct password during the lockout: %v, want a wait of %v", err, loginLockout-time.Minute)
+		}
+		if _, _, err := s.login(ctx, "bob@example.com", "correct horse battery", "192.0.2.3", at.Add(loginLockout)); err != nil {
+			t.Errorf("correct password after the lockout: %v", err)
+		}
+	})
+
+	t.Run("window expiry", func(t *testing.T) {
+		at := fail(t, s, "cat@example.com", "192.0.2.4", start, loginBackoffAfter)
+		if _, _, err := s.login(ctx, "cat@example.com", "wrong password", "192.0.2.4", at); !sameLoginError(err, errThrottled) {
+			t.Fatalf("straight after %d failures: %v, want throttled", loginBackoffAfter, err)
+		}
+		// Once the failures are older than the window, each of the next
+		// ones is answered without a wait until the backoff starts over.
+		later := start.Add(loginWindow + time.Second)
+		for i := 0; i < loginBackoffAfter; i++ {
+			if _, _, err := s.login(ctx, "cat@example.com", "wrong password", "192.0.2.4", later); !sameLoginError(err, errInvalidLogin) {
+				t.Errorf("failure %d after the window: %v, want errInvalidLogin", i+1, err)
+			}
+		}
+	})
+}
+
+// errThrottled stands for any *throttledError in the tables above.
+var errThrottled = errors.New("throttled")
+
+func sameLoginError(err, want error) bool {
+	var throttled *throttledError
+	if want == errThrottled {
+		return errors.As(err, &throttled)
+	}
+	return errors.Is(err, want)
+}
+
+// fail makes n failed logins for email from ip, starting at start and
+// waiting out each backoff, and returns the time of the last.
+func fail(t *testing.T, s *Server, email, ip string, start time.Time, n int) time.Time {
+	t.Helper()
+	at := start
+	for failures := 0; failures < n; {
+		_, _, err := s.login(context.Background(), email, "wrong password", ip, at)
+		var throttled *throttledError
+		if errors.As(err, &throttled) {
+			at = at.Add(throttled.wait)
+			continue
+		}
+		if !errors.Is(err, errInvalidLogin) {
+			t.Fatalf("failed login %d: %v", failures+1, err)
+		}
+		failures++
+	}
+	return at
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// The password policy follows current guidance: length matters, forced
+// character classes do not, and well-known passwords are refused.
+const (
+	minPasswordLength = 10
+	maxPasswordLength = 128
+)
+
+// commonPasswords are refused whatever their length.
+var commonPasswords = map[string]bool{
+	"1234567890": true, "0123456789": true, "12345678910": true, "123456789012": true,
+	"qwertyuiop": true, "1q2w3e4r5t": true, "password123": true, "password1234": true,
+	"passwordpassword": true, "iloveyou123": true, "letmein123": true, "welcome123": true,
+	"administrator": true, "hospital123": true, "changeme123": true, "qwerty123456": true,
+}
+
+// checkPassword returns why password does not meet the policy, or nil.
+// The reason reads as a sentence after "Password ".
+func checkPassword(password string) error {
+	n := utf8.RuneCountInString(password)
+	if n < minPasswordLength {
+		return fmt.Errorf("must be at least %d characters long", minPasswordLength)
+	}
+	if n > maxPasswordLength {
+		return fmt.Errorf("must be at most %d characters long", maxPasswordLength)
+	}
+	if commonPasswords[strings.ToLower(password)] {
+		return errors.New("is too common; choose another")
+	}
+	first, _ := utf8.DecodeRuneInString(password)
+	if strings.Count(password, string(first)) == n {
+		return errors.New("must not be one character repeated")
+	}
+	return nil
+}
+
+// Passwords are stored as argon2id hashes in the PHC string format, with
+// the parameters OWASP recommends for argon2id at 19 MiB.
+const (
+	argonTime    = 2
+	argonMemory  = 19 * 1024 // KiB
+	argonThreads = 1
+	argonKeyLen  = 32
+	argonSaltLen = 16
+	argonPrefix  = "$argon2id$"
+)
+
+// HashPassword returns the hash of password, with a new random salt, to
+// store as store.User.PasswordHash.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argonPrefix, argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// isPasswordHash reports whether stored is a hash rather than one of the
+// plaintext passwords kept before passwords were hashed.
+func isPasswordHash(stored string) bool {
+	return strings.HasPrefix(stored, argonPrefix)
+}
+
+// passwordMatches reports whether password is the one stored, a hash or,
+// for accounts not yet upgraded, the plaintext password.
+func passwordMatches(stored, password string) bool {
+	if !isPasswordHash(stored) {
+		return subtle.ConstantTimeCompare([]byte(password), []byte(stored)) == 1
+	}
+	var version int
+	var memory, iterations uint32
+	var threads uint8
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// dummyHash is checked against when the email is unknown, so that path
+// does the same work as a wrong password.
+var dummyHash = sync.OnceValue(func() string {
+	h, err := HashPassword("not-a-real-password-but-just-as-long")
+	if err != nil {
+		panic(err)
+	}
+	return h
+})
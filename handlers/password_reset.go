@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"shubam/email"
+	"shubam/store"
+)
+
+const (
+	// resetTTL is how long a password reset link works.
+	resetTTL = time.Hour
+	// At most one reset email is sent per resetGap and resetLimit per
+	// resetWindow for an account, so the form cannot flood an inbox.
+	resetGap    = time.Minute
+	resetLimit  = 5
+	resetWindow = 24 * time.Hour
+	// resetSendTimeout bounds sending a reset email, which outlives the
+	// request that asked for it.
+	resetSendTimeout = time.Minute
+)
+
+// forgotPage is the data of forgot.html.
+type forgotPage struct {
+	Sent bool
+}
+
+// resetPage is the data of reset.html.
+type resetPage struct {
+	Token string
+	Error string
+	Done  bool
+}
+
+// ForgotPasswordPageHandler asks for the email address to send a password
+// reset link to.
+func (s *Server) ForgotPasswordPageHandler(w http.ResponseWriter, r *http.Request) {
+	s.render(w, r, "forgot.html", forgotPage{})
+}
+
+// ForgotPasswordHandler mails a password reset link to the address given.
+// The answer is the same, and takes as long, whether or not the address
+// has an account.
+func (s *Server) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	now := time.Now()
+	defer padUntil(ctx, now.Add(loginMinDuration))
+
+	user, err := s.userByEmail(ctx, r.FormValue("email"))
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		slog.InfoContext(ctx, "Password reset requested for unknown email")
+	case err != nil:
+		slog.ErrorContext(ctx, "Error querying database", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	default:
+		if err := s.requestPasswordReset(ctx, user, now); err != nil {
+			slog.ErrorContext(ctx, "Error creating password reset", "error", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	s.render(w, r, "forgot.html", forgotPage{Sent: true})
+}
+
+// requestPasswordReset records a reset link for user and emails it in the
+// background, so the response does not reveal that the account exists by
+// waiting on the mail server. Throttled requests are dropped silently.
+func (s *Server) requestPasswordReset(ctx context.Context, user store.User, now time.Time) error {
+	sent, last, err := s.passwordResets.PasswordResetsSince(ctx, user.ID, now.Add(-resetWindow))
+	if err != nil {
+		return err
+	}
+	if sent >= resetLimit || (sent > 0 && now.Before(last.Add(resetGap))) {
+		slog.WarnContext(ctx, "Password reset throttled", "user_id", user.ID, "sent", sent)
+		return nil
+	}
+
+	token, hash := newToken()
+	err = s.passwordResets.CreatePasswordReset(ctx, store.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hash,
+		ExpiresAt: now.Add(resetTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := s.publicURL + "/reset?" + url.Values{"token": {token}}.Encode()
+	msg := email.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account. To choose a new one, open the link below:\n\n%s\n\n"+
+			"The link works once and expires in %d minutes. If you did not ask for this, ignore this email; your password is unchanged.\n",
+			link, int(resetTTL/time.Minute)),
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resetSendTimeout)
+		defer cancel()
+		if err := s.mailer.Send(ctx, msg); err != nil {
+			slog.ErrorContext(ctx, "Error sending password reset email", "error", err)
+		}
+	}()
+	slog.InfoContext(ctx, "Password reset sent", "user_id", user.ID)
+	return nil
+}
+
+// ResetPasswordPageHandler shows the form a reset link leads to.
+func (s *Server) ResetPasswordPageHandler(w http.ResponseWriter, r *http.Request) {
+	s.render(w, r, "reset.html", resetPage{Token: r.URL.Query().Get("token")})
+}
+
+// ResetPasswordHandler sets the new password, ending every session of the
+// user.
+func (s *Server) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	password := r.FormValue("password")
+	page := resetPage{Token: token}
+	if password != r.FormValue("confirm") {
+		page.Error = "The passwords do not match"
+	} else if err := checkPassword(password); err != nil {
+		page.Error = "Password " + err.Error()
+	}
+	if page.Error != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		s.render(w, r, "reset.html", page)
+		return
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error hashing password", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	user, err := s.passwordResets.ResetPassword(r.Context(), hashToken(token), hash, time.Now())
+	if errors.Is(err, store.ErrNotFound) {
+		slog.InfoContext(r.Context(), "Password reset link rejected")
+		w.WriteHeader(http.StatusBadRequest)
+		s.render(w, r, "reset.html", resetPage{Error: "This reset link is invalid, has expired or has already been used. Ask for a new one."})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error resetting password", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Password reset", "user_id", user.ID)
+	s.clearSession(w, r)
+	s.render(w, r, "reset.html", resetPage{Done: true})
+}
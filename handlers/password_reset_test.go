@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"shubam/store"
+)
+
+func TestPasswordReset(t *testing.T) {
+	s, mail, mem, _ := newTestService(t)
+	srv := httptest.NewServer(s.Routes())
+	defer srv.Close()
+	ctx := context.Background()
+
+	ann := newBrowser(t, srv)
+	ann.register("ann@example.com")
+	elsewhere := newBrowser(t, srv)
+	elsewhere.login("ann@example.com", "correct horse battery")
+	if ann.get("/profile") != http.StatusOK || elsewhere.get("/profile") != http.StatusOK {
+		t.Fatal("ann is not logged in")
+	}
+
+	b := newBrowser(t, srv)
+	if got := b.post("/forgot", url.Values{"email": {" Ann@Example.com"}, csrfFormField: {b.token("/forgot")}}); got != http.StatusOK {
+		t.Fatalf("asking for a reset: %d, want 200", got)
+	}
+	waitForMail(t, mail, 2)
+	link := mail.link(t)
+	reset := func(path string) int {
+		t.Helper()
+		u, err := url.Parse(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.post("/reset", url.Values{
+			"token":       {u.Query().Get("token")},
+			"password":    {"staple battery horse"},
+			"confirm":     {"staple battery horse"},
+			csrfFormField: {b.token(path)},
+		})
+	}
+
+	if got := reset(link); got != http.StatusOK {
+		t.Fatalf("resetting: %d, want 200", got)
+	}
+	if ann.get("/profile") == http.StatusOK || elsewhere.get("/profile") == http.StatusOK {
+		t.Error("a session from before the reset still works")
+	}
+	if got := reset(link); got != http.StatusBadRequest {
+		t.Errorf("using the link again: %d, want 400", got)
+	}
+	login := func(password string) int {
+		body := `{"email": "ann@example.com", "password": "` + password + `"}`
+		return call(t, s.Routes(), "POST", "/api/v1/auth/login", "", body, nil).StatusCode
+	}
+	if got := login("correct horse battery"); got != http.StatusUnauthorized {
+		t.Errorf("login with the old password: %d, want 401", got)
+	}
+	if got := login("staple battery horse"); got != http.StatusOK {
+		t.Errorf("login with the new password: %d, want 200", got)
+	}
+
+	user, err := mem.UserByEmail(ctx, "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, hash := newToken()
+	if err := mem.CreatePasswordReset(ctx, store.PasswordReset{UserID: user.ID, TokenHash: hash, ExpiresAt: time.Now().Add(-time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := reset("/reset?" + url.Values{"token": {token}}.Encode()); got != http.StatusBadRequest {
+		t.Errorf("an expired link: %d, want 400", got)
+	}
+}
+
+// waitForMail waits for mail to hold n messages, for mail sent in the
+// background.
+func waitForMail(t *testing.T, mail *mailbox, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		mail.mu.Lock()
+		got := len(mail.messages)
+		mail.mu.Unlock()
+		if got >= n {
+			return
+		}
+	}
+	t.Fatalf("want %d emails, got fewer", n)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		ok       bool
+	}{
+		{"long enough", "correct horse battery", true},
+		{"too short", "short", false},
+		{"ten runes, more bytes", "ééééé12345", true},
+		{"nine runes, more bytes", "éééééé123", false},
+		{"too long", strings.Repeat("ab", 65), false},
+		{"common", "Password123", false},
+		{"one character repeated", "aaaaaaaaaaaa", false},
+		{"one multibyte character repeated", "éééééééééééé", false},
+		{"starts with a repeated multibyte character", "éééééééééééa", true},
+	}
+	for _, tt := range tests {
+		if err := checkPassword(tt.password); (err == nil) != tt.ok {
+			t.Errorf("%s: checkPassword(%q) = %v, want ok %v", tt.name, tt.password, err, tt.ok)
+		}
+	}
+}
+
+func TestPasswordHash(t *testing.T) {
+	hash, err := HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isPasswordHash(hash) || strings.Contains(hash, "correct horse battery") {
+		t.Fatalf("HashPassword = %q, want an argon2id hash", hash)
+	}
+	if !passwordMatches(hash, "correct horse battery") {
+		t.Error("the hash does not match its password")
+	}
+	if passwordMatches(hash, "correct horse battery!") {
+		t.Error("the hash matches another password")
+	}
+	if again, _ := HashPassword("correct horse battery"); again == hash {
+		t.Error("hashing twice gave the same salt")
+	}
+	if !passwordMatches("plaintext password", "plaintext password") || passwordMatches("plaintext password", "other") {
+		t.Error("a plaintext password is not compared as it is")
+	}
+	if passwordMatches("$argon2id$v=19$m=19456,t=2,p=1$bm90IGJhc2U2NA", "") {
+		t.Error("a malformed hash matches")
+	}
+}
+
+func TestPlaintextPasswordRehashedAtLogin(t *testing.T) {
+	h, _, mem, _ := newTestServerWithStore(t)
+	ctx := context.Background()
+	user, err := mem.CreateUser(ctx, "ann@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := `{"email": "ann@example.com", "password": "correct horse battery"}`
+	if resp := call(t, h, "POST", "/api/v1/auth/login", "", login, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("login with a plaintext password: %d, want 200", resp.StatusCode)
+	}
+	user, err = mem.UserByID(ctx, user.ID)
+	if err != nil || !isPasswordHash(user.PasswordHash) {
+		t.Fatalf("password after login = %q, %v, want a hash", user.PasswordHash, err)
+	}
+	if resp := call(t, h, "POST", "/api/v1/auth/login", "", login, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("login with the rehashed password: %d, want 200", resp.StatusCode)
+	}
+}
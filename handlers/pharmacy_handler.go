@@ -1,44 +1,20 @@
-package handlers;
+package handlers
 
 import (
-
-
-	"html/template"
-	"log"
 	"net/http"
-
-	_ "github.com/lib/pq"
 )
-type PageData struct {
-	UserID   string
-	UserEmail string
-}
 
-
-func PharmacyHandler(w http.ResponseWriter, r *http.Request) {
-	userIDCookie, err := r.Cookie("userID")
-	if err != nil {
-		http.Error(w, "Missing user ID", http.StatusBadRequest)
-		return
-	}
-	userEmailCookie, err := r.Cookie("userEmail")
-	if err != nil {
-		http.Error(w, "Missing user email", http.StatusBadRequest)
+// PharmacyHandler shows the pharmacy's medicine inventory.
+func (s *Server) PharmacyHandler(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.requireSession(w, r)
+	if !ok {
 		return
 	}
 
 	data := PageData{
-		UserID:   userIDCookie.Value,
-		UserEmail: userEmailCookie.Value,
+		UserID:    sess.UserID,
+		UserEmail: sess.UserEmail,
 	}
 
-	tmpl, err := template.ParseFiles("Static/appointment.html")
-if err != nil {
-    log.Printf("Error parsing pharmacy template: %v\n", err)
-    http.Error(w, "Error loading pharmacy page", http.StatusInternalServerError)
-    return
+	s.render(w, r, "pharmacy.html", data)
 }
-log.Println("Pharmacy called")
-
-	tmpl.Execute(w, data)
-}
\ No newline at end of file
@@ -0,0 +1,279 @@
+// Package handlers is the HTTP layer of the web server. A Server holds
+// everything the handlers need, so Register_User/main.go only has to build
+// the dependencies and mount Server.Routes.
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"shubam/email"
+	"shubam/logging"
+	"shubam/metrics"
+	pb "shubam/proto"
+	pharmacypb "shubam/proto/pharmacy"
+	pbv2 "shubam/proto/v2"
+	"shubam/store"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// pages are the templates parsed from the template directory at startup.
+var pages = []string{"service.html", "login.html", "appointment.html", "profile.html", "pharmacy.html", "maintenance.html", "verify.html", "forgot.html", "reset.html", "twofactor.html", "twofactor_setup.html", "register.html"}
+
+// Options are the dependencies of a Server.
+type Options struct {
+	Users        store.UserStore
+	Appointments store.AppointmentStore
+	Doctors      store.DoctorStore
+	// LoginAttempts throttles logins and keeps their audit trail.
+	LoginAttempts  store.LoginAttemptStore
+	Verifications  store.EmailVerificationStore
+	Sessions       store.SessionStore
+	PasswordResets store.PasswordResetStore
+	TwoFactor      store.TwoFactorStore
+	Prescriptions  store.PrescriptionStore
+	PharmacyOrders store.PharmacyOrderStore
+
+	// Mailer sends verification and password reset emails, with links
+	// to PublicURL; verification links are signed with LinkKey. An
+	// https PublicURL also marks the session cookie Secure.
+	Mailer    email.Sender
+	PublicURL string
+	LinkKey   []byte
+
+	AppointmentClient pbv2.HospitalServiceClient
+	// AppointmentClientV1 reaches the v1 service of the appointment
+	// server, for the gateway only; without it the gateway serves v2.
+	AppointmentClientV1 pb.HospitalServiceClient
+	// PharmacyClient places the pharmacy orders of the JSON API.
+	PharmacyClient pharmacypb.PharmacyServiceClient
+
+	// Location is the hospital time zone.
+	Location *time.Location
+	// TemplateDir holds the page templates and the static files, which
+	// are served under /static/.
+	TemplateDir string
+	// RequestTimeout bounds the database and gRPC calls made for one
+	// request. Zero means no limit beyond the client disconnecting.
+	RequestTimeout time.Duration
+
+	// ReadinessChecks are the dependencies reported by /readyz.
+	ReadinessChecks []ReadinessCheck
+}
+
+type Server struct {
+	users             store.UserStore
+	appointments      store.AppointmentStore
+	doctors           store.DoctorStore
+	loginAttempts     store.LoginAttemptStore
+	verifications     store.EmailVerificationStore
+	sessions          store.SessionStore
+	passwordResets    store.PasswordResetStore
+	twoFactor         store.TwoFactorStore
+	prescriptions     store.PrescriptionStore
+	pharmacyOrders    store.PharmacyOrderStore
+	mailer            email.Sender
+	publicURL         string
+	linkKey           []byte
+	secureCookies     bool
+	appointmentClient pbv2.HospitalServiceClient
+	pharmacyClient    pharmacypb.PharmacyServiceClient
+	gateway           *runtime.ServeMux
+	location          *time.Location
+	templateDir       string
+	requestTimeout    time.Duration
+	templates         map[string]*template.Template
+	readinessChecks   []ReadinessCheck
+	draining          atomic.Bool
+}
+
+// New parses the page templates and returns a Server using opts.
+func New(opts Options) (*Server, error) {
+	s := &Server{
+		users:             opts.Users,
+		appointments:      opts.Appointments,
+		doctors:           opts.Doctors,
+		loginAttempts:     opts.LoginAttempts,
+		verifications:     opts.Verifications,
+		mailer:            opts.Mailer,
+		publicURL:         strings.TrimSuffix(opts.PublicURL, "/"),
+		linkKey:           opts.LinkKey,
+		sessions:          opts.Sessions,
+		passwordResets:    opts.PasswordResets,
+		twoFactor:         opts.TwoFactor,
+		prescriptions:     opts.Prescriptions,
+		pharmacyOrders:    opts.PharmacyOrders,
+		secureCookies:     strings.HasPrefix(opts.PublicURL, "https:"),
+		appointmentClient: opts.AppointmentClient,
+		pharmacyClient:    opts.PharmacyClient,
+		location:          opts.Location,
+		templateDir:       opts.TemplateDir,
+		requestTimeout:    opts.RequestTimeout,
+		templates:         make(map[string]*template.Template),
+		readinessChecks:   opts.ReadinessChecks,
+	}
+
+	for _, name := range pages {
+		tmpl, err := template.New(name).Funcs(csrfFuncs).ParseFiles(filepath.Join(opts.TemplateDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing template %s: %w", name, err)
+		}
+		s.templates[name] = tmpl
+	}
+
+	gw, err := newGateway(opts.AppointmentClient, opts.AppointmentClientV1)
+	if err != nil {
+		return nil, err
+	}
+	s.gateway = gw
+	return s, nil
+}
+
+// Routes returns the web server's handler. Every route names its method,
+// so a request with another method gets 405 with an Allow header.
+func (s *Server) Routes() http.Handler {
+	mux := http.NewServeMux()
+
+	// Every route is measured and traced under its pattern, not its
+	// path, and logged with a request ID. Probes and scrapes are left out
+	// of the logs and traces.
+	route := func(mux *http.ServeMux, pattern string, h http.Handler) {
+		h = logging.Middleware(s.withTimeout(h))
+		mux.Handle(pattern, metrics.Middleware(pattern, otelhttp.NewHandler(h, pattern)))
+	}
+	// Requests from the pages that change anything must carry the CSRF
+	// token of the page they came from.
+	handle := func(pattern string, h http.Handler) {
+		route(mux, pattern, s.csrf(h))
+	}
+	// Logins answer 401 to a wrong password or code, on the pages and in
+	// the API alike.
+	countLoginFailures := func(h http.HandlerFunc) http.HandlerFunc {
+		return metrics.CountStatus(http.StatusUnauthorized, metrics.LoginFailures, h).ServeHTTP
+	}
+
+	// Static files live under their own prefix rather than at "/", which
+	// would catch every GET and hide the 405s of POST-only routes.
+	handle("GET /static/", http.StripPrefix("/static", s.staticFiles(http.FileServer(http.Dir(s.templateDir)))))
+	handle("GET /{$}", http.RedirectHandler("/login", http.StatusSeeOther))
+	handle("GET /register.html", http.RedirectHandler("/register", http.StatusMovedPermanently))
+	handle("GET /login.html", http.RedirectHandler("/login", http.StatusMovedPermanently))
+
+	handle("GET /register", http.HandlerFunc(s.RegisterPageHandler))
+	handle("POST /register", http.HandlerFunc(s.RegisterHandler))
+	handle("GET /verify", http.HandlerFunc(s.VerifyHandler))
+	handle("POST /verify/resend", http.HandlerFunc(s.ResendVerificationHandler))
+	handle("GET /forgot", http.HandlerFunc(s.ForgotPasswordPageHandler))
+	handle("POST /forgot", http.HandlerFunc(s.ForgotPasswordHandler))
+	handle("GET /reset", http.HandlerFunc(s.ResetPasswordPageHandler))
+	handle("POST /reset", http.HandlerFunc(s.ResetPasswordHandler))
+	handle("GET /login", http.HandlerFunc(s.LoginPageHandler))
+	handle("POST /login", countLoginFailures(s.LoginHandler))
+	handle("GET /login/2fa", http.HandlerFunc(s.TwoFactorLoginPageHandler))
+	handle("POST /login/2fa", countLoginFailures(s.TwoFactorLoginHandler))
+	handle("GET /2fa/setup", http.HandlerFunc(s.TwoFactorSetupPageHandler))
+	handle("POST /2fa/setup", http.HandlerFunc(s.TwoFactorSetupHandler))
+	handle("POST /2fa/disable", http.HandlerFunc(s.TwoFactorDisableHandler))
+
+	handle("GET /service", http.HandlerFunc(s.ServiceHandler))
+	handle("GET /profile", http.HandlerFunc(s.ProfileHandler))
+	handle("GET /appointments/new", http.HandlerFunc(s.BookingPageHandler))
+	handle("POST /appointments", http.HandlerFunc(s.BookAppointmentHandler))
+	handle("POST /appointments/{id}/cancel", http.HandlerFunc(s.CancelHandler))
+	handle("GET /doctors/{name}/booked-slots", http.HandlerFunc(s.BookedSlotsHandler))
+	handle("GET /pharmacy", http.HandlerFunc(s.PharmacyHandler))
+
+	// The JSON API takes bearer tokens rather than cookies and answers
+	// unknown routes with its own error envelope.
+	api := http.NewServeMux()
+	handleAPI := func(pattern string, h http.HandlerFunc) {
+		route(api, pattern, h)
+	}
+	handleAPI("GET /api/v1/openapi.json", s.OpenAPIHandler)
+	handleAPI("POST /api/v1/auth/register", s.APIRegisterHandler)
+	handleAPI("POST /api/v1/auth/login", countLoginFailures(s.APILoginHandler))
+	handleAPI("POST /api/v1/auth/login/2fa", countLoginFailures(s.APILoginSecondFactorHandler))
+	handleAPI("POST /api/v1/auth/logout", s.APILogoutHandler)
+	handleAPI("GET /api/v1/me", s.APIMeHandler)
+	handleAPI("GET /api/v1/doctors", s.APIDoctorsHandler)
+	handleAPI("GET /api/v1/doctors/{name}/slots", s.APIDoctorSlotsHandler)
+	handleAPI("GET /api/v1/appointments", s.APIAppointmentsHandler)
+	handleAPI("POST /api/v1/appointments", s.APIBookAppointmentHandler)
+	handleAPI("DELETE /api/v1/appointments/{id}", s.APICancelAppointmentHandler)
+	handleAPI("GET /api/v1/prescriptions", s.APIPrescriptionsHandler)
+	handleAPI("POST /api/v1/prescriptions", s.APIPrescribeHandler)
+	handleAPI("GET /api/v1/pharmacy/orders", s.APIPharmacyOrdersHandler)
+	handleAPI("POST /api/v1/pharmacy/orders", s.APIOrderPrescriptionHandler)
+	mux.Handle("/api/v1/", apiFallback(api))
+
+	// The gRPC gateway routes by the HTTP annotations of the protos itself.
+	route(mux, "GET /gateway/v2/openapi.json", http.HandlerFunc(s.GatewayOpenAPIHandler))
+	route(mux, "GET /gateway/v1/openapi.json", http.HandlerFunc(s.GatewayV1OpenAPIHandler))
+	route(mux, "/gateway/", http.HandlerFunc(s.GatewayHandler))
+
+	mux.HandleFunc("GET /healthz", s.HealthzHandler)
+	mux.HandleFunc("GET /readyz", s.ReadyzHandler)
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	return mux
+}
+
+// withTimeout cancels the request context after the request timeout. The
+// context is already cancelled if the client goes away.
+func (s *Server) withTimeout(next http.Handler) http.Handler {
+	if s.requestTimeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), s.requestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// staticFiles serves the template directory, except the page templates,
+// which only make sense rendered by their handlers, and directory listings.
+func (s *Server) staticFiles(files http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") || slices.Contains(pages, strings.TrimPrefix(r.URL.Path, "/")) {
+			http.NotFound(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// render executes the named page template, with the request's CSRF token.
+func (s *Server) render(w http.ResponseWriter, r *http.Request, name string, data any) {
+	tmpl, err := s.templates[name].Clone()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error cloning template", "template", name, "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.Funcs(s.csrfTemplateFuncs(r)).Execute(w, data); err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering template", "template", name, "error", err)
+	}
+}
+
+// maintenanceRetryAfter is the Retry-After sent with the maintenance page,
+// in seconds, matching the client circuit breaker's cooldown.
+const maintenanceRetryAfter = "30"
+
+// renderMaintenance answers 503 with a friendly page when service cannot
+// be reached.
+func (s *Server) renderMaintenance(w http.ResponseWriter, r *http.Request, service string) {
+	w.Header().Set("Retry-After", maintenanceRetryAfter)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	s.render(w, r, "maintenance.html", struct{ Service string }{service})
+}
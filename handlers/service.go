@@ -0,0 +1,392 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"shubam/auth"
+	pharmacypb "shubam/proto/pharmacy"
+	pbv2 "shubam/proto/v2"
+	"shubam/schedule"
+	"shubam/store"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The operations below are shared by the HTML pages and the JSON API,
+// which differ only in how they read requests and present the results.
+
+var (
+	errInvalidLogin = errors.New("invalid email or password")
+	errInvalidCode  = errors.New("invalid second factor code")
+	errUnverified   = errors.New("email address not verified")
+	errNotDoctor    = errors.New("only doctors may do this")
+	// errUnavailable wraps the error of a backend that cannot be reached.
+	errUnavailable = errors.New("service unavailable")
+)
+
+// invalidError is a request the user can correct; its message is meant for
+// them.
+type invalidError struct {
+	msg string
+}
+
+func (e *invalidError) Error() string { return e.msg }
+
+// throttledError is a login refused because of recent failures.
+type throttledError struct {
+	wait time.Duration
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("too many login attempts, retry in %v", e.wait)
+}
+
+// retryAfter is the wait in whole seconds, rounded up, for Retry-After.
+func (e *throttledError) retryAfter() string {
+	return strconv.Itoa(int((e.wait + time.Second - 1) / time.Second))
+}
+
+// NormalizeEmail returns email in the form accounts are stored, looked up
+// and throttled under: without surrounding space and in lower case, so
+// one address cannot hold two accounts.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// userByEmail returns the account of email however it was typed.
+func (s *Server) userByEmail(ctx context.Context, email string) (store.User, error) {
+	return s.users.UserByEmail(ctx, NormalizeEmail(email))
+}
+
+// register creates a patient account and emails a verification link. It
+// returns an *invalidError for a password the policy refuses and
+// store.ErrConflict for a registered email.
+func (s *Server) register(ctx context.Context, email, password string) (store.User, error) {
+	if err := checkPassword(password); err != nil {
+		return store.User{}, &invalidError{"Password " + err.Error()}
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return store.User{}, fmt.Errorf("error hashing password: %w", err)
+	}
+	user, err := s.users.CreateUser(ctx, NormalizeEmail(email), hash)
+	if err != nil {
+		return store.User{}, err
+	}
+	// The account exists either way; if the email cannot be sent now the
+	// patient can ask for another from the verify page.
+	if err := s.sendVerification(ctx, user); err != nil {
+		slog.ErrorContext(ctx, "Error sending verification email", "error", err)
+	}
+	return user, nil
+}
+
+// login checks email and password from ip, throttling repeated failures.
+// With the right password it returns the user and the second factor step
+// still due, if any; the attempt is then recorded once that step is done,
+// so a known password alone never resets the failure count. It returns a
+// *throttledError or errInvalidLogin when the login is refused.
+func (s *Server) login(ctx context.Context, email, password, ip string, now time.Time) (store.User, string, error) {
+	attempt := store.LoginAttempt{Email: NormalizeEmail(email), IP: ip, AttemptedAt: now}
+	failures, err := s.loginAttempts.LoginFailures(ctx, attempt.Email, attempt.IP, now.Add(-loginWindow))
+	if err != nil {
+		return store.User{}, "", fmt.Errorf("error querying login attempts: %w", err)
+	}
+	if wait := loginDelay(failures, now); wait > 0 {
+		slog.WarnContext(ctx, "Login throttled", "account_failures", failures.Account, "ip_failures", failures.IP, "wait", wait)
+		return store.User{}, "", &throttledError{wait}
+	}
+
+	user, err := s.userByEmail(ctx, email)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return store.User{}, "", err
+	}
+	// An unknown email is checked against a dummy hash so it takes as
+	// long as a wrong password, and both get the same answer.
+	stored := dummyHash()
+	if err == nil {
+		stored = user.PasswordHash
+	}
+	attempt.Success = passwordMatches(stored, password) && err == nil
+	if attempt.Success && !isPasswordHash(user.PasswordHash) {
+		s.rehashPassword(ctx, user, password)
+	}
+
+	if attempt.Success {
+		step, err := s.secondFactorStep(ctx, user)
+		if err != nil {
+			return store.User{}, "", fmt.Errorf("error querying two-factor settings: %w", err)
+		}
+		if step != "" {
+			return user, step, nil
+		}
+	}
+
+	if err := s.loginAttempts.RecordLoginAttempt(ctx, attempt); err != nil {
+		return store.User{}, "", fmt.Errorf("error recording login attempt: %w", err)
+	}
+	if !attempt.Success {
+		slog.InfoContext(ctx, "Login failed", "account_failures", failures.Account+1)
+		return store.User{}, "", errInvalidLogin
+	}
+	return user, "", nil
+}
+
+// rehashPassword replaces the plaintext password of an account created
+// before passwords were hashed. A failure is logged and retried at the
+// next login.
+func (s *Server) rehashPassword(ctx context.Context, user store.User, password string) {
+	hash, err := HashPassword(password)
+	if err == nil {
+		err = s.users.RehashPassword(ctx, user.ID, user.PasswordHash, hash)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error rehashing password", "user_id", user.ID, "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "Password rehashed", "user_id", user.ID)
+}
+
+// loginSecondFactor completes the login of userID with an authenticator
+// or recovery code. Wrong codes count as failed logins, so the same
+// throttling and lockout apply; they return errInvalidCode.
+func (s *Server) loginSecondFactor(ctx context.Context, userID int64, code, ip string, now time.Time) (store.User, error) {
+	user, err := s.users.UserByID(ctx, userID)
+	if err != nil {
+		return store.User{}, err
+	}
+	attempt := store.LoginAttempt{Email: NormalizeEmail(user.Email), IP: ip, AttemptedAt: now}
+	failures, err := s.loginAttempts.LoginFailures(ctx, attempt.Email, attempt.IP, now.Add(-loginWindow))
+	if err != nil {
+		return store.User{}, fmt.Errorf("error querying login attempts: %w", err)
+	}
+	if wait := loginDelay(failures, now); wait > 0 {
+		slog.WarnContext(ctx, "Login throttled", "account_failures", failures.Account, "ip_failures", failures.IP, "wait", wait)
+		return store.User{}, &throttledError{wait}
+	}
+
+	attempt.Success, err = s.checkSecondFactor(ctx, userID, code, now)
+	if err != nil {
+		return store.User{}, fmt.Errorf("error checking second factor: %w", err)
+	}
+	if err := s.loginAttempts.RecordLoginAttempt(ctx, attempt); err != nil {
+		return store.User{}, fmt.Errorf("error recording login attempt: %w", err)
+	}
+	if !attempt.Success {
+		slog.InfoContext(ctx, "Login failed: wrong second factor", "user_id", userID, "account_failures", failures.Account+1)
+		return store.User{}, errInvalidCode
+	}
+	return user, nil
+}
+
+// bookAppointment books the slot at the hospital-local date and clock
+// with the doctor for user, through the appointment server. nonce names
+// the booking attempt, so repeating it books only once. It returns
+// errUnverified until the user has verified their email, an
+// *invalidError for a slot that cannot be booked, an error wrapping
+// store.ErrConflict for a slot already taken, and one wrapping
+// errUnavailable when the appointment server cannot be reached.
+func (s *Server) bookAppointment(ctx context.Context, user store.User, doctorName, date, clock, nonce string) (store.Appointment, error) {
+	if user.VerifiedAt.IsZero() {
+		return store.Appointment{}, errUnverified
+	}
+	if date == "" {
+		return store.Appointment{}, &invalidError{"Date is required"}
+	}
+	if clock == "" {
+		return store.Appointment{}, &invalidError{"Time is required"}
+	}
+
+	slog.DebugContext(ctx, "Booking requested", "doctor", doctorName, "date", date, "time", clock)
+
+	start, err := schedule.ParseSlot(date, clock, s.location)
+	if err != nil {
+		return store.Appointment{}, &invalidError{"Invalid date or time"}
+	}
+	if err := schedule.Validate(start, time.Now(), s.location); err != nil {
+		return store.Appointment{}, &invalidError{fmt.Sprintf("Cannot book this slot: %v", err)}
+	}
+
+	userID := strconv.FormatInt(user.ID, 10)
+	req := &pbv2.AppointmentRequest{
+		DoctorName:     doctorName,
+		UserId:         userID,
+		Email:          user.Email,
+		Start:          timestamppb.New(start),
+		Duration:       durationpb.New(schedule.SlotDuration),
+		TimeZone:       s.location.String(),
+		IdempotencyKey: idempotencyKey(nonce, doctorName, start),
+	}
+
+	// The appointment server books for the patient named in the call's
+	// token, not for the request's UserId.
+	resp, err := s.appointmentClient.Appointment(auth.WithPatient(ctx, userID, user.Email), req)
+	switch status.Code(err) {
+	case codes.OK:
+	case codes.Unavailable:
+		return store.Appointment{}, fmt.Errorf("%w: %w", errUnavailable, err)
+	case codes.AlreadyExists:
+		return store.Appointment{}, fmt.Errorf("%w: %w", store.ErrConflict, err)
+	case codes.InvalidArgument:
+		return store.Appointment{}, &invalidError{status.Convert(err).Message()}
+	default:
+		return store.Appointment{}, err
+	}
+
+	slog.InfoContext(ctx, "Appointment booked", "appointment_id", resp.Id)
+	return store.Appointment{
+		ID:         resp.Id,
+		DoctorName: doctorName,
+		UserID:     user.ID,
+		Email:      user.Email,
+		StartsAt:   start,
+		Duration:   schedule.SlotDuration,
+		Status:     store.StatusBooked,
+	}, nil
+}
+
+// upcomingAppointments lists the patient's appointments from the start of
+// the hospital's today, not the database server's.
+func (s *Server) upcomingAppointments(ctx context.Context, userID int64) ([]store.Appointment, error) {
+	return s.appointments.UpcomingAppointments(ctx, userID, schedule.StartOfDay(time.Now(), s.location))
+}
+
+// cancelAppointment cancels the patient's upcoming appointment with id.
+// Patients may only cancel their own appointments that have not yet
+// passed; anything else returns store.ErrNotFound.
+func (s *Server) cancelAppointment(ctx context.Context, userID, id int64) error {
+	upcoming, err := s.upcomingAppointments(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(upcoming, func(a store.Appointment) bool { return a.ID == id }) {
+		return store.ErrNotFound
+	}
+	if _, err := s.appointments.DeleteAppointment(ctx, id); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Appointment cancelled", "appointment_id", id)
+	return nil
+}
+
+// bookedSlots returns the doctor's booked slots keyed by hospital-local
+// date ("2006-01-02"), with hospital-local start times ("15:04") as
+// values, matching the booking page's buttons.
+func (s *Server) bookedSlots(ctx context.Context, doctorName string) (map[string][]string, error) {
+	booked, err := s.appointments.BookedSlots(ctx, doctorName, time.Time{}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	slots := make(map[string][]string)
+	for _, a := range booked {
+		date := schedule.Date(a.StartsAt, s.location)
+		slots[date] = append(slots[date], schedule.Clock(a.StartsAt, s.location))
+	}
+	return slots, nil
+}
+
+// maxPrescribedQuantity bounds the quantity of a prescription.
+const maxPrescribedQuantity = 1000
+
+// prescribe records a prescription by doctor for the patient with
+// patientEmail. It returns errNotDoctor unless doctor has the doctor role,
+// an *invalidError for an incomplete prescription and store.ErrNotFound
+// for an unknown patient.
+func (s *Server) prescribe(ctx context.Context, doctor store.User, patientEmail, medicine, dosage string, quantity int) (store.Prescription, error) {
+	if doctor.Role != store.RoleDoctor {
+		return store.Prescription{}, errNotDoctor
+	}
+	switch {
+	case medicine == "":
+		return store.Prescription{}, &invalidError{"Medicine is required"}
+	case dosage == "":
+		return store.Prescription{}, &invalidError{"Dosage is required"}
+	case quantity < 1 || quantity > maxPrescribedQuantity:
+		return store.Prescription{}, &invalidError{fmt.Sprintf("Quantity must be between 1 and %d", maxPrescribedQuantity)}
+	}
+	patient, err := s.userByEmail(ctx, patientEmail)
+	if err != nil {
+		return store.Prescription{}, err
+	}
+
+	rx, err := s.prescriptions.CreatePrescription(ctx, store.Prescription{
+		UserID:       patient.ID,
+		PrescriberID: doctor.ID,
+		PrescribedBy: doctor.Email,
+		Medicine:     medicine,
+		Dosage:       dosage,
+		Quantity:     quantity,
+	})
+	if err != nil {
+		return store.Prescription{}, err
+	}
+	slog.InfoContext(ctx, "Prescription written", "prescription_id", rx.ID, "patient_id", patient.ID)
+	return rx, nil
+}
+
+// orderPrescription orders user's prescription with id from the pharmacy
+// service. An order is stored as pending before it is sent and marked
+// placed once the pharmacy accepts it, so if the pharmacy cannot be
+// reached, ordering again sends the same order, which the pharmacy places
+// only once. It returns errUnverified until the user has verified their
+// email, store.ErrNotFound if the prescription is not theirs,
+// store.ErrConflict if it was already ordered, and an error wrapping
+// errUnavailable when the pharmacy cannot be reached.
+func (s *Server) orderPrescription(ctx context.Context, user store.User, id int64) (store.PharmacyOrder, error) {
+	if user.VerifiedAt.IsZero() {
+		return store.PharmacyOrder{}, errUnverified
+	}
+	rx, err := s.prescriptions.PrescriptionByID(ctx, id)
+	if err != nil {
+		return store.PharmacyOrder{}, err
+	}
+	if rx.UserID != user.ID {
+		return store.PharmacyOrder{}, store.ErrNotFound
+	}
+
+	order, err := s.pharmacyOrders.CreatePharmacyOrder(ctx, store.PharmacyOrder{UserID: user.ID, PrescriptionID: rx.ID})
+	if errors.Is(err, store.ErrConflict) {
+		order, err = s.pharmacyOrders.PharmacyOrderByPrescription(ctx, rx.ID)
+		if err == nil && order.Status != store.OrderPending {
+			return store.PharmacyOrder{}, store.ErrConflict
+		}
+	}
+	if err != nil {
+		return store.PharmacyOrder{}, err
+	}
+
+	userID := strconv.FormatInt(user.ID, 10)
+	resp, err := s.pharmacyClient.PlaceOrder(auth.WithPatient(ctx, userID, user.Email), &pharmacypb.PlaceOrderRequest{
+		OrderId:      strconv.FormatInt(order.ID, 10),
+		UserId:       userID,
+		Email:        user.Email,
+		Medicine:     rx.Medicine,
+		Dosage:       rx.Dosage,
+		Quantity:     int32(rx.Quantity),
+		PrescribedBy: rx.PrescribedBy,
+	})
+	switch status.Code(err) {
+	case codes.OK:
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return store.PharmacyOrder{}, fmt.Errorf("%w: %w", errUnavailable, err)
+	default:
+		return store.PharmacyOrder{}, err
+	}
+
+	order, err = s.pharmacyOrders.PlacePharmacyOrder(ctx, order.ID, resp.Reference)
+	if err != nil {
+		return store.PharmacyOrder{}, err
+	}
+	slog.InfoContext(ctx, "Pharmacy order placed", "order_id", order.ID, "prescription_id", rx.ID)
+	return order, nil
+}
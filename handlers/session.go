@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"shubam/store"
+)
+
+// sessionCookie holds the session token. The server keeps only its hash,
+// so sessions can be ended server-side, e.g. on a password reset.
+const sessionCookie = "session"
+
+// sessionTTL is how long a login lasts.
+const sessionTTL = 24 * time.Hour
+
+// session is the patient identified by the session cookie.
+type session struct {
+	UserID    string
+	UserEmail string
+}
+
+// requireSession loads the request's session. Without a valid one it sends
+// page views to the login page and answers anything else 401.
+func (s *Server) requireSession(w http.ResponseWriter, r *http.Request) (session, bool) {
+	sess, ok, err := s.sessionFrom(r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error loading session", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return session{}, false
+	}
+	if !ok {
+		if r.Method == http.MethodGet {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+		} else {
+			http.Error(w, "Not logged in", http.StatusUnauthorized)
+		}
+		return session{}, false
+	}
+	return sess, true
+}
+
+// sessionFrom loads the request's session, reporting false if it has no
+// valid one.
+func (s *Server) sessionFrom(r *http.Request) (session, bool, error) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return session{}, false, nil
+	}
+	found, err := s.sessions.SessionByTokenHash(r.Context(), hashToken(c.Value), time.Now())
+	if errors.Is(err, store.ErrNotFound) {
+		return session{}, false, nil
+	}
+	if err != nil {
+		return session{}, false, err
+	}
+	return session{UserID: strconv.FormatInt(found.UserID, 10), UserEmail: found.Email}, true, nil
+}
+
+// startSession logs user in, ending the session the request came with, if
+// any, so a session token planted before login is worthless after it. CSRF
+// tokens are signed from the new session from then on.
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, user store.User) error {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if err := s.sessions.DeleteSession(r.Context(), hashToken(c.Value)); err != nil {
+			return err
+		}
+	}
+
+	token, expires, err := s.createSession(r.Context(), user)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	useSessionCSRF(r, token)
+	return nil
+}
+
+// createSession stores a new session for user and returns its token.
+func (s *Server) createSession(ctx context.Context, user store.User) (token string, expires time.Time, err error) {
+	token, hash := newToken()
+	expires = time.Now().Add(sessionTTL)
+	err = s.sessions.CreateSession(ctx, store.Session{UserID: user.ID, TokenHash: hash, ExpiresAt: expires})
+	return token, expires, err
+}
+
+// clearSession removes the session cookie from the browser and replaces
+// its CSRF secret.
+func (s *Server) clearSession(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	s.rotateCSRF(w, r)
+}
+
+// newToken returns a random 256-bit token and the hash to store for it.
+func newToken() (token string, hash []byte) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token)
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"shubam/store"
+	"shubam/totp"
+
+	"rsc.io/qr"
+)
+
+const (
+	// totpIssuer names the account in authenticator apps.
+	totpIssuer = "Hospital"
+	// recoveryCodeCount is how many recovery codes enrolment issues.
+	recoveryCodeCount = 10
+
+	// challengeCookie carries a login from the password step to the
+	// second factor or to enrolment; it is valid for challengeTTL.
+	challengeCookie = "login_challenge"
+	challengeTTL    = 5 * time.Minute
+	// Challenge purposes: a code is due, or enrolment is mandatory.
+	challengeCode   = "code"
+	challengeEnrol  = "enrol"
+	challengeSigner = "login-challenge"
+)
+
+// twoFactorRoles must use two-factor authentication; for everyone else it
+// is optional.
+var twoFactorRoles = map[string]bool{
+	store.RoleDoctor:     true,
+	store.RolePharmacist: true,
+	store.RoleAdmin:      true,
+}
+
+// twoFactorPage is the data of twofactor.html, the login step.
+type twoFactorPage struct {
+	Error string
+}
+
+// twoFactorSetupPage is the data of twofactor_setup.html.
+type twoFactorSetupPage struct {
+	// Enabled is set once two-factor authentication is on; Mandatory
+	// hides the option to turn it off.
+	Enabled   bool
+	Mandatory bool
+	// Secret, URI and QR describe a pending enrolment.
+	Secret string
+	URI    string
+	QR     template.URL
+	// RecoveryCodes are shown once, right after enrolment.
+	RecoveryCodes []string
+	Error         string
+}
+
+// secondFactorStep returns where a user who just gave the right password
+// must go before getting a session: the code prompt if they have two-factor
+// authentication, enrolment if their role requires it, or "" if neither.
+func (s *Server) secondFactorStep(ctx context.Context, user store.User) (string, error) {
+	t, err := s.twoFactor.TOTP(ctx, user.ID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return "", err
+	}
+	switch {
+	case err == nil && !t.EnabledAt.IsZero():
+		return challengeCode, nil
+	case twoFactorRoles[user.Role]:
+		return challengeEnrol, nil
+	default:
+		return "", nil
+	}
+}
+
+// TwoFactorLoginPageHandler asks for the authenticator or recovery code
+// after a correct password.
+func (s *Server) TwoFactorLoginPageHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.challengeFrom(r, challengeCode); !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	s.render(w, r, "twofactor.html", twoFactorPage{})
+}
+
+// TwoFactorLoginHandler checks the code and completes the login. Wrong
+// codes count as failed logins, so the same throttling and lockout apply.
+func (s *Server) TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.challengeFrom(r, challengeCode)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	ctx := r.Context()
+	now := time.Now()
+	defer padUntil(ctx, now.Add(loginMinDuration))
+
+	user, err := s.loginSecondFactor(ctx, userID, r.FormValue("code"), clientIP(r), now)
+	var throttled *throttledError
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", throttled.retryAfter())
+		http.Error(w, "Too many login attempts; please try again later", http.StatusTooManyRequests)
+		return
+	case errors.Is(err, errInvalidCode):
+		w.WriteHeader(http.StatusUnauthorized)
+		s.render(w, r, "twofactor.html", twoFactorPage{Error: "That code is not valid"})
+		return
+	case err != nil:
+		slog.ErrorContext(ctx, "Error logging in", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.clearChallenge(w)
+	if err := s.startSession(w, r, user); err != nil {
+		slog.ErrorContext(ctx, "Error starting session", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/service", http.StatusSeeOther)
+}
+
+// checkSecondFactor reports whether code is a current authenticator code
+// not used before or an unused recovery code, using it up either way.
+func (s *Server) checkSecondFactor(ctx context.Context, userID int64, code string, now time.Time) (bool, error) {
+	t, err := s.twoFactor.TOTP(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if step, ok := totp.Validate(t.Secret, code, now); ok {
+		err := s.twoFactor.UseTOTPStep(ctx, userID, step)
+		if errors.Is(err, store.ErrConflict) {
+			slog.WarnContext(ctx, "Authenticator code replayed", "user_id", userID)
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	err = s.twoFactor.UseRecoveryCode(ctx, userID, hashRecoveryCode(code), now)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err == nil {
+		slog.InfoContext(ctx, "Recovery code used", "user_id", userID)
+	}
+	return err == nil, err
+}
+
+// TwoFactorSetupPageHandler starts enrolling the user in two-factor
+// authentication, showing a new secret as a QR code. Staff whose role
+// requires two-factor authentication arrive here from the login page
+// before getting a session.
+func (s *Server) TwoFactorSetupPageHandler(w http.ResponseWriter, r *http.Request) {
+	user, _, t, ok := s.enrolment(w, r)
+	if !ok {
+		return
+	}
+	if !t.EnabledAt.IsZero() {
+		s.render(w, r, "twofactor_setup.html", twoFactorSetupPage{Enabled: true, Mandatory: twoFactorRoles[user.Role]})
+		return
+	}
+
+	secret := totp.NewSecret()
+	if err := s.twoFactor.StartTOTP(r.Context(), user.ID, secret); err != nil {
+		slog.ErrorContext(r.Context(), "Error starting two-factor enrolment", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	s.renderEnrolment(w, r, user, secret, "")
+}
+
+// TwoFactorSetupHandler confirms the pending secret with a code and shows
+// the recovery codes, completing the login of staff sent here by it.
+func (s *Server) TwoFactorSetupHandler(w http.ResponseWriter, r *http.Request) {
+	user, fromLogin, t, ok := s.enrolment(w, r)
+	if !ok {
+		return
+	}
+	mandatory := twoFactorRoles[user.Role]
+	if !t.EnabledAt.IsZero() {
+		s.render(w, r, "twofactor_setup.html", twoFactorSetupPage{Enabled: true, Mandatory: mandatory})
+		return
+	}
+	if t.Secret == "" {
+		http.Redirect(w, r, "/2fa/setup", http.StatusSeeOther)
+		return
+	}
+
+	ctx := r.Context()
+	now := time.Now()
+	step, valid := totp.Validate(t.Secret, r.FormValue("code"), now)
+	if !valid {
+		w.WriteHeader(http.StatusBadRequest)
+		s.renderEnrolment(w, r, user, t.Secret, "That code is not valid; check your device's clock and try again")
+		return
+	}
+
+	codes := newRecoveryCodes()
+	hashes := make([][]byte, len(codes))
+	for i, c := range codes {
+		hashes[i] = hashRecoveryCode(c)
+	}
+	if err := s.twoFactor.EnableTOTP(ctx, user.ID, step, hashes, now); err != nil {
+		slog.ErrorContext(ctx, "Error enabling two-factor authentication", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "Two-factor authentication enabled", "user_id", user.ID)
+
+	if fromLogin {
+		// The password and now a code have been given: the login is
+		// complete.
+		s.clearChallenge(w)
+		attempt := store.LoginAttempt{Email: NormalizeEmail(user.Email), IP: clientIP(r), Success: true, AttemptedAt: now}
+		if err := s.loginAttempts.RecordLoginAttempt(ctx, attempt); err != nil {
+			slog.ErrorContext(ctx, "Error recording login attempt", "error", err)
+		}
+		if err := s.startSession(w, r, user); err != nil {
+			slog.ErrorContext(ctx, "Error starting session", "error", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	s.render(w, r, "twofactor_setup.html", twoFactorSetupPage{Enabled: true, Mandatory: mandatory, RecoveryCodes: codes})
+}
+
+// TwoFactorDisableHandler turns two-factor authentication off, given a
+// current code, for users whose role does not require it.
+func (s *Server) TwoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if twoFactorRoles[user.Role] {
+		http.Error(w, "Two-factor authentication is required for your role", http.StatusForbidden)
+		return
+	}
+
+	ctx := r.Context()
+	valid, err := s.checkSecondFactor(ctx, user.ID, r.FormValue("code"), time.Now())
+	if errors.Is(err, store.ErrNotFound) {
+		http.Redirect(w, r, "/2fa/setup", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error checking second factor", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		s.render(w, r, "twofactor_setup.html", twoFactorSetupPage{Enabled: true, Error: "That code is not valid"})
+		return
+	}
+	if err := s.twoFactor.DisableTOTP(ctx, user.ID); err != nil {
+		slog.ErrorContext(ctx, "Error disabling two-factor authentication", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "Two-factor authentication disabled", "user_id", user.ID)
+	http.Redirect(w, r, "/2fa/setup", http.StatusSeeOther)
+}
+
+// enrolment loads the enrolling user and their two-factor settings, which
+// are zero if they have none yet.
+func (s *Server) enrolment(w http.ResponseWriter, r *http.Request) (user store.User, fromLogin bool, t store.TOTP, ok bool) {
+	user, fromLogin, ok = s.enrollingUser(w, r)
+	if !ok {
+		return user, false, t, false
+	}
+	t, err := s.twoFactor.TOTP(r.Context(), user.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		return user, fromLogin, store.TOTP{}, true
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error querying two-factor settings", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return user, false, t, false
+	}
+	return user, fromLogin, t, true
+}
+
+// enrollingUser identifies the user on the enrolment page: the session's,
+// or, with fromLogin set, the one sent here by the login page.
+func (s *Server) enrollingUser(w http.ResponseWriter, r *http.Request) (user store.User, fromLogin, ok bool) {
+	userID, fromLogin := s.challengeFrom(r, challengeEnrol)
+	if !fromLogin {
+		user, ok = s.sessionUser(w, r)
+		return user, false, ok
+	}
+	user, err := s.users.UserByID(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return store.User{}, false, false
+	}
+	return user, true, true
+}
+
+// renderEnrolment shows secret for the user to add to their authenticator.
+func (s *Server) renderEnrolment(w http.ResponseWriter, r *http.Request, user store.User, secret, errMsg string) {
+	uri := totp.URI(totpIssuer, user.Email, secret)
+	page := twoFactorSetupPage{Mandatory: twoFactorRoles[user.Role], Secret: secret, URI: uri, Error: errMsg}
+	if code, err := qr.Encode(uri, qr.M); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding QR code", "error", err)
+	} else {
+		page.QR = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG()))
+	}
+	s.render(w, r, "twofactor_setup.html", page)
+}
+
+// setChallenge lets the browser continue the login of userID at the step
+// named by purpose.
+func (s *Server) setChallenge(w http.ResponseWriter, userID int64, purpose string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     challengeCookie,
+		Value:    s.challengeToken(userID, purpose),
+		Path:     "/",
+		MaxAge:   int(challengeTTL / time.Second),
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// challengeFrom returns the user whose login the request continues at the
+// step named by purpose.
+func (s *Server) challengeFrom(r *http.Request, purpose string) (int64, bool) {
+	c, err := r.Cookie(challengeCookie)
+	if err != nil {
+		return 0, false
+	}
+	return s.parseChallenge(c.Value, purpose)
+}
+
+// challengeToken returns a signed token continuing the login of userID at
+// the step named by purpose, "<user>.<expiry>.<purpose>.<signature>".
+func (s *Server) challengeToken(userID int64, purpose string) string {
+	payload := fmt.Sprintf("%d.%d.%s", userID, time.Now().Add(challengeTTL).Unix(), purpose)
+	return payload + "." + signLink(s.linkKey, challengeSigner, payload)
+}
+
+// parseChallenge checks token's signature, expiry and purpose and returns
+// the user whose login it continues.
+func (s *Server) parseChallenge(token, purpose string) (int64, bool) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 || !hmac.Equal([]byte(token[i+1:]), []byte(signLink(s.linkKey, challengeSigner, token[:i]))) {
+		return 0, false
+	}
+	parts := strings.Split(token[:i], ".")
+	if len(parts) != 3 || parts[2] != purpose {
+		return 0, false
+	}
+	userID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || !time.Now().Before(time.Unix(expires, 0)) {
+		return 0, false
+	}
+	return userID, true
+}
+
+func (s *Server) clearChallenge(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: challengeCookie, Path: "/", MaxAge: -1, HttpOnly: true, Secure: s.secureCookies, SameSite: http.SameSiteLaxMode})
+}
+
+var recoveryEncoding = base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns recoveryCodeCount random codes of 50 bits,
+// written as two groups of five characters.
+func newRecoveryCodes() []string {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		rand.Read(b)
+		c := recoveryEncoding.EncodeToString(b)[:10]
+		codes[i] = c[:5] + "-" + c[5:]
+	}
+	return codes
+}
+
+// hashRecoveryCode hashes code as stored, ignoring case, spaces and
+// dashes.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return sum[:]
+}
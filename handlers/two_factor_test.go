@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"shubam/metrics"
+	"shubam/store"
+	"shubam/totp"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var recoveryCodePattern = regexp.MustCompile(`([a-z2-9]{5}-[a-z2-9]{5})<br>`)
+
+func TestTwoFactorEnrolmentAndLogin(t *testing.T) {
+	h, _, mem, _ := newTestServerWithStore(t)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	ctx := context.Background()
+
+	newBrowser(t, srv).register("ann@example.com")
+	ann, err := mem.UserByEmail(ctx, "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.SetRole(ctx, ann.ID, store.RoleDoctor); err != nil {
+		t.Fatal(err)
+	}
+
+	// Staff must enrol before their first session.
+	doctor := newBrowser(t, srv)
+	if to := doctor.login("ann@example.com", "correct horse battery"); to != "/2fa/setup" {
+		t.Fatalf("doctor's login redirects to %s, want /2fa/setup", to)
+	}
+	if code := doctor.get("/profile"); code == http.StatusOK {
+		t.Fatal("the doctor has a session before enrolling")
+	}
+	token := doctor.token("/2fa/setup")
+	pending, err := mem.TOTP(ctx, ann.ID)
+	if err != nil || pending.Secret == "" || !pending.EnabledAt.IsZero() {
+		t.Fatalf("TOTP after the setup page = %+v, %v, want a pending secret", pending, err)
+	}
+	code := func(step int64) string {
+		c, err := totp.Code(pending.Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	if got := doctor.post("/2fa/setup", url.Values{"code": {"000000"}, csrfFormField: {token}}); got != http.StatusBadRequest {
+		t.Errorf("enrolling with a wrong code: %d, want 400", got)
+	}
+	enrolStep := totp.Step(time.Now())
+	resp, body := doctor.submit("/2fa/setup", url.Values{"code": {code(enrolStep)}, csrfFormField: {token}})
+	recovery := recoveryCodePattern.FindAllStringSubmatch(body, -1)
+	if resp.StatusCode != http.StatusOK || len(recovery) != recoveryCodeCount {
+		t.Fatalf("enrolling: %d with %d recovery codes, want 200 with %d", resp.StatusCode, len(recovery), recoveryCodeCount)
+	}
+	if got := doctor.get("/profile"); got != http.StatusOK {
+		t.Errorf("profile page after enrolling: %d, want 200", got)
+	}
+
+	// Each login is a new browser: the password, then the second factor.
+	secondFactor := func(code string) int {
+		t.Helper()
+		b := newBrowser(t, srv)
+		if to := b.login("ann@example.com", "correct horse battery"); to != "/login/2fa" {
+			t.Fatalf("login redirects to %s, want /login/2fa", to)
+		}
+		return b.post("/login/2fa", url.Values{"code": {code}, csrfFormField: {b.token("/login/2fa")}})
+	}
+	before := testutil.ToFloat64(metrics.LoginFailures)
+	tests := []struct {
+		name string
+		code string
+		want int
+	}{
+		{"wrong code", "000000", http.StatusUnauthorized},
+		{"enrolment code replayed", code(enrolStep), http.StatusUnauthorized},
+		{"next code", code(enrolStep + 1), http.StatusSeeOther},
+		{"same code again", code(enrolStep + 1), http.StatusUnauthorized},
+		{"recovery code", recovery[0][1], http.StatusSeeOther},
+		{"used recovery code", recovery[0][1], http.StatusUnauthorized},
+		{"another recovery code, upper case", strings.ToUpper(recovery[1][1]), http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		if got := secondFactor(tt.code); got != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.LoginFailures) - before; got != 4 {
+		t.Errorf("login failures counted = %v, want 4", got)
+	}
+}
+
+func TestSecondFactorPageNeedsAChallenge(t *testing.T) {
+	h, _ := newTestServer(t)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	b := newBrowser(t, srv)
+	resp, _ := b.submit("/login/2fa", url.Values{"code": {"000000"}, csrfFormField: {b.token("/login")}})
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/login" {
+		t.Errorf("second factor without a password first: %d to %q, want 303 to /login", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"shubam/store"
+)
+
+// RegisterPageHandler shows the registration form.
+func (s *Server) RegisterPageHandler(w http.ResponseWriter, r *http.Request) {
+	s.render(w, r, "register.html", nil)
+}
+
+// RegisterHandler creates the account, emails a verification link and
+// logs the patient in.
+func (s *Server) RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Parse form error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.register(r.Context(), r.FormValue("email"), r.FormValue("password"))
+	var invalid *invalidError
+	if errors.As(err, &invalid) {
+		http.Redirect(w, r, "/register?error="+url.QueryEscape(invalid.msg), http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, store.ErrConflict) {
+		errorMessage := "Email already exists"
+		http.Redirect(w, r, "/register?error="+url.QueryEscape(errorMessage), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error inserting user into database", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.startSession(w, r, user); err != nil {
+		slog.ErrorContext(r.Context(), "Error starting session", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/verify", http.StatusSeeOther)
+}
+
+// LoginPageHandler shows the login form.
+func (s *Server) LoginPageHandler(w http.ResponseWriter, r *http.Request) {
+	s.render(w, r, "login.html", nil)
+}
+
+// LoginHandler checks the email and password, throttling repeated
+// failures, and sends staff on to their second factor.
+func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	now := time.Now()
+	defer padUntil(ctx, now.Add(loginMinDuration))
+
+	user, step, err := s.login(ctx, r.FormValue("email"), r.FormValue("password"), clientIP(r), now)
+	var throttled *throttledError
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", throttled.retryAfter())
+		http.Error(w, "Too many login attempts; please try again later", http.StatusTooManyRequests)
+		return
+	case errors.Is(err, errInvalidLogin):
+		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		return
+	case err != nil:
+		slog.ErrorContext(ctx, "Error logging in", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	switch step {
+	case challengeCode:
+		s.setChallenge(w, user.ID, step)
+		http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+		return
+	case challengeEnrol:
+		s.setChallenge(w, user.ID, step)
+		http.Redirect(w, r, "/2fa/setup", http.StatusSeeOther)
+		return
+	}
+
+	if err := s.startSession(w, r, user); err != nil {
+		slog.ErrorContext(ctx, "Error starting session", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/service", http.StatusSeeOther)
+}
+
+func (s *Server) ServiceHandler(w http.ResponseWriter, r *http.Request) {
+	s.render(w, r, "service.html", nil)
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"shubam/email"
+	"shubam/store"
+)
+
+const (
+	// verificationTTL is how long a verification link works.
+	verificationTTL = 24 * time.Hour
+	// A patient may ask for another link once per verificationResendGap
+	// and at most verificationResendLimit times per
+	// verificationResendWindow, counting the one sent on registration.
+	verificationResendGap    = time.Minute
+	verificationResendLimit  = 5
+	verificationResendWindow = time.Hour
+)
+
+var errInvalidVerificationToken = errors.New("invalid verification token")
+
+// verifyPage is the data of verify.html.
+type verifyPage struct {
+	Title   string
+	Message string
+	// Resend offers to send another link.
+	Resend bool
+}
+
+// VerifyHandler verifies the email address of the user named in the
+// token query parameter. Without a token it asks the patient to check
+// their inbox.
+func (s *Server) VerifyHandler(w http.ResponseWriter, r *http.Request) {
+	_, loggedIn, err := s.sessionFrom(r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error loading session", "error", err)
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		s.render(w, r, "verify.html", verifyPage{
+			Title:   "Check your email",
+			Message: "We sent a verification link to your email address. Follow it to finish setting up your account; until then you cannot book appointments.",
+			Resend:  loggedIn,
+		})
+		return
+	}
+
+	now := time.Now()
+	userID, hash, err := parseVerificationToken(s.linkKey, token, now)
+	if err == nil {
+		_, err = s.verifications.UseEmailVerification(r.Context(), userID, hash, now)
+	}
+	switch {
+	case err == nil:
+		slog.InfoContext(r.Context(), "Email verified", "user_id", userID)
+		s.render(w, r, "verify.html", verifyPage{
+			Title:   "Email verified",
+			Message: "Thank you, your email address is verified.",
+		})
+	case errors.Is(err, errInvalidVerificationToken), errors.Is(err, store.ErrNotFound):
+		slog.InfoContext(r.Context(), "Verification link rejected", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		s.render(w, r, "verify.html", verifyPage{
+			Title:   "Link not valid",
+			Message: "This verification link is invalid, has expired or has already been used.",
+			Resend:  loggedIn,
+		})
+	default:
+		slog.ErrorContext(r.Context(), "Error verifying email", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+	}
+}
+
+// ResendVerificationHandler emails the logged-in patient a new
+// verification link, throttled per account.
+func (s *Server) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if !user.VerifiedAt.IsZero() {
+		http.Redirect(w, r, "/service", http.StatusSeeOther)
+		return
+	}
+
+	now := time.Now()
+	sent, last, err := s.verifications.EmailVerificationsSince(r.Context(), user.ID, now.Add(-verificationResendWindow))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error counting verification emails", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	var wait time.Duration
+	if sent > 0 {
+		wait = last.Add(verificationResendGap).Sub(now)
+	}
+	if sent >= verificationResendLimit {
+		wait = last.Add(verificationResendWindow).Sub(now)
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		w.WriteHeader(http.StatusTooManyRequests)
+		s.render(w, r, "verify.html", verifyPage{
+			Title:   "Please wait",
+			Message: "We recently sent you a verification link. Check your inbox, including the spam folder, or try again later.",
+		})
+		return
+	}
+
+	if err := s.sendVerification(r.Context(), user); err != nil {
+		slog.ErrorContext(r.Context(), "Error sending verification email", "error", err)
+		http.Error(w, "Could not send the verification email; please try again later", http.StatusInternalServerError)
+		return
+	}
+	s.render(w, r, "verify.html", verifyPage{
+		Title:   "Check your email",
+		Message: "We sent you a new verification link.",
+	})
+}
+
+// sendVerification records a new verification link for user and emails
+// it to them.
+func (s *Server) sendVerification(ctx context.Context, user store.User) error {
+	expires := time.Now().Add(verificationTTL)
+	token, hash := verificationToken(s.linkKey, user.ID, expires)
+	err := s.verifications.CreateEmailVerification(ctx, store.EmailVerification{
+		UserID:    user.ID,
+		TokenHash: hash,
+		ExpiresAt: expires,
+	})
+	if err != nil {
+		return err
+	}
+	link := s.publicURL + "/verify?" + url.Values{"token": {token}}.Encode()
+	return s.mailer.Send(ctx, email.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Welcome! Confirm this is your email address by opening the link below:\n\n%s\n\n"+
+			"The link works once and expires in %d hours. If you did not create an account, ignore this email.\n",
+			link, int(verificationTTL/time.Hour)),
+	})
+}
+
+// sessionUser loads the session's user, answering the request itself and
+// returning false when that fails.
+func (s *Server) sessionUser(w http.ResponseWriter, r *http.Request) (store.User, bool) {
+	sess, ok := s.requireSession(w, r)
+	if !ok {
+		return store.User{}, false
+	}
+	userID, err := strconv.ParseInt(sess.UserID, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return store.User{}, false
+	}
+	user, err := s.users.UserByID(r.Context(), userID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Unknown user", http.StatusUnauthorized)
+		return store.User{}, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return store.User{}, false
+	}
+	return user, true
+}
+
+// verificationToken returns a signed token for userID expiring at
+// expires, "<user>.<expiry>.<nonce>.<signature>", and the hash of its
+// nonce to store. The signature lets forged links be rejected without a
+// database lookup; the stored hash makes each link single-use.
+func verificationToken(key []byte, userID int64, expires time.Time) (token string, hash []byte) {
+	nonce := make([]byte, 32)
+	rand.Read(nonce)
+	payload := fmt.Sprintf("%d.%d.%s", userID, expires.Unix(), base64.RawURLEncoding.EncodeToString(nonce))
+	sum := sha256.Sum256(nonce)
+	return payload + "." + signLink(key, "verify-email", payload), sum[:]
+}
+
+// parseVerificationToken checks token's signature and expiry and returns
+// the user it verifies and the hash of its nonce.
+func parseVerificationToken(key []byte, token string, now time.Time) (int64, []byte, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 || !hmac.Equal([]byte(token[i+1:]), []byte(signLink(key, "verify-email", token[:i]))) {
+		return 0, nil, errInvalidVerificationToken
+	}
+	payload := token[:i]
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 {
+		return 0, nil, errInvalidVerificationToken
+	}
+	userID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, nil, errInvalidVerificationToken
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, nil, errInvalidVerificationToken
+	}
+	if !now.Before(time.Unix(expires, 0)) {
+		return 0, nil, fmt.Errorf("%w: expired", errInvalidVerificationToken)
+	}
+	nonce, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return 0, nil, errInvalidVerificationToken
+	}
+	sum := sha256.Sum256(nonce)
+	return userID, sum[:], nil
+}
+
+// signLink signs payload for purpose, so a signature made for one kind of
+// link is not valid for another.
+func signLink(key []byte, purpose, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose + "\x00" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"shubam/store"
+)
+
+func TestVerificationLinks(t *testing.T) {
+	s, mail, mem, _ := newTestService(t)
+	srv := httptest.NewServer(s.Routes())
+	defer srv.Close()
+	ctx := context.Background()
+
+	b := newBrowser(t, srv)
+	b.register("ann@example.com")
+	ann, err := mem.UserByEmail(ctx, "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := mail.link(t)
+
+	// link stores a verification for ann expiring at stored, and returns
+	// the path of a token for it signed to expire at signed.
+	link := func(signed, stored time.Time) string {
+		token, hash := verificationToken(s.linkKey, ann.ID, signed)
+		err := mem.CreateEmailVerification(ctx, store.EmailVerification{UserID: ann.ID, TokenHash: hash, ExpiresAt: stored})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "/verify?" + url.Values{"token": {token}}.Encode()
+	}
+	later := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Second)
+	id := strconv.FormatInt(ann.ID, 10)
+	otherUser := strings.Replace(link(later, later), "token="+id+".", "token="+id+"0.", 1)
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"signed expiry passed", link(past, later), http.StatusBadRequest},
+		{"stored expiry passed", link(later, past), http.StatusBadRequest},
+		{"user changed after signing", otherUser, http.StatusBadRequest},
+		{"the link sent", sent, http.StatusOK},
+		{"the link sent, again", sent, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if got := b.get(tt.path); got != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if ann, err := mem.UserByID(ctx, ann.ID); err != nil || ann.VerifiedAt.IsZero() {
+		t.Errorf("after verifying, user = %+v, %v, want verified", ann, err)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	pbv2 "shubam/proto/v2"
+	"shubam/schedule"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// pageSize is how many appointments are fetched per call.
+const pageSize = 500
+
+var errUsage = errors.New("invalid arguments")
+
+// cli runs the commands against the appointment server, reading and
+// printing times in the hospital time zone.
+type cli struct {
+	client   pbv2.HospitalServiceClient
+	location *time.Location
+	out      io.Writer
+}
+
+// run runs the command named by args[0].
+func (c *cli) run(ctx context.Context, args []string) error {
+	switch args[0] {
+	case "doctors":
+		return c.doctors(ctx, args[1:])
+	case "appointments":
+		return c.appointments(ctx, args[1:])
+	case "cancel":
+		return c.cancel(ctx, args[1:])
+	case "reschedule":
+		return c.reschedule(ctx, args[1:])
+	case "slots":
+		return c.slots(ctx, args[1:])
+	default:
+		return fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+	}
+}
+
+// output is the -o flag every command takes.
+type output struct {
+	format string
+}
+
+func newFlags(name string) (*flag.FlagSet, *output) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	o := &output{}
+	fs.StringVar(&o.format, "o", "table", "output format: table or json")
+	return fs, o
+}
+
+// parse parses the command's flags and checks it got want positional
+// arguments.
+func parse(fs *flag.FlagSet, o *output, args []string, want int) error {
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %s: %v", errUsage, fs.Name(), err)
+	}
+	if o.format != "table" && o.format != "json" {
+		return fmt.Errorf("%w: -o must be table or json, got %q", errUsage, o.format)
+	}
+	if fs.NArg() != want {
+		return fmt.Errorf("%w: wrong number of arguments to %s", errUsage, fs.Name())
+	}
+	return nil
+}
+
+func (c *cli) doctors(ctx context.Context, args []string) error {
+	fs, o := newFlags("doctors")
+	if err := parse(fs, o, args, 0); err != nil {
+		return err
+	}
+	resp, err := c.client.ListDoctors(ctx, &pbv2.ListDoctorsRequest{})
+	if err != nil {
+		return err
+	}
+	if o.format == "json" {
+		return c.printJSON(resp)
+	}
+	return c.printTable([]string{"ID", "NAME", "SPECIALTY", "EXPERIENCE"}, len(resp.Doctors), func(i int) []string {
+		d := resp.Doctors[i]
+		return []string{strconv.FormatInt(d.Id, 10), d.Name, d.Specialty, d.Experience}
+	})
+}
+
+func (c *cli) appointments(ctx context.Context, args []string) error {
+	fs, o := newFlags("appointments")
+	patient := fs.String("patient", "", "patient email or user ID")
+	doctor := fs.String("doctor", "", "doctor name")
+	date := fs.String("date", "", "day, YYYY-MM-DD")
+	from := fs.String("from", "", "first day, YYYY-MM-DD")
+	to := fs.String("to", "", "last day, YYYY-MM-DD")
+	limit := fs.Int("limit", 0, "list at most this many; 0 lists every match")
+	if err := parse(fs, o, args, 0); err != nil {
+		return err
+	}
+	start, end, err := c.dateRange(*date, *from, *to)
+	if err != nil {
+		return err
+	}
+
+	req := &pbv2.ListAppointmentsRequest{DoctorName: *doctor, From: timestamp(start), To: timestamp(end), PageSize: pageSize}
+	if _, err := strconv.ParseInt(*patient, 10, 64); err == nil {
+		req.UserId = *patient
+	} else {
+		req.Email = *patient
+	}
+
+	all := &pbv2.ListAppointmentsResponse{}
+	for {
+		resp, err := c.client.ListAppointments(ctx, req)
+		if err != nil {
+			return err
+		}
+		all.TimeZone = resp.TimeZone
+		all.Appointments = append(all.Appointments, resp.Appointments...)
+		if *limit > 0 && len(all.Appointments) >= *limit {
+			all.Appointments = all.Appointments[:*limit]
+			break
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+
+	if o.format == "json" {
+		return c.printJSON(all)
+	}
+	return c.printAppointments(all.Appointments...)
+}
+
+func (c *cli) cancel(ctx context.Context, args []string) error {
+	fs, o := newFlags("cancel")
+	if err := parse(fs, o, args, 1); err != nil {
+		return err
+	}
+	id, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	a, err := c.client.CancelAppointment(ctx, &pbv2.CancelAppointmentRequest{Id: id})
+	if err != nil {
+		return err
+	}
+	if o.format == "json" {
+		return c.printJSON(a)
+	}
+	return c.printAppointments(a)
+}
+
+func (c *cli) reschedule(ctx context.Context, args []string) error {
+	fs, o := newFlags("reschedule")
+	if err := parse(fs, o, args, 3); err != nil {
+		return err
+	}
+	id, err := parseID(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	start, err := schedule.ParseSlot(fs.Arg(1), fs.Arg(2), c.location)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	a, err := c.client.RescheduleAppointment(ctx, &pbv2.RescheduleAppointmentRequest{Id: id, Start: timestamppb.New(start)})
+	if err != nil {
+		return err
+	}
+	if o.format == "json" {
+		return c.printJSON(a)
+	}
+	return c.printAppointments(a)
+}
+
+func (c *cli) slots(ctx context.Context, args []string) error {
+	fs, o := newFlags("slots")
+	date := fs.String("date", "", "day, YYYY-MM-DD")
+	from := fs.String("from", "", "first day, YYYY-MM-DD")
+	to := fs.String("to", "", "last day, YYYY-MM-DD")
+	if err := parse(fs, o, args, 1); err != nil {
+		return err
+	}
+	start, end, err := c.dateRange(*date, *from, *to)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.GetBookedSlots(ctx, &pbv2.GetBookedSlotsRequest{DoctorName: fs.Arg(0), From: timestamp(start), To: timestamp(end)})
+	if err != nil {
+		return err
+	}
+	if o.format == "json" {
+		return c.printJSON(resp)
+	}
+	return c.printTable([]string{"DATE", "TIME", "MINUTES"}, len(resp.Slots), func(i int) []string {
+		s := resp.Slots[i]
+		start := s.Start.AsTime()
+		return []string{schedule.Date(start, c.location), schedule.Clock(start, c.location), minutes(s.Duration.AsDuration())}
+	})
+}
+
+// dateRange returns the instants bounding the hospital-local days from
+// from to to, both included, or the single day date. An empty day leaves
+// its bound open, as a zero time.
+func (c *cli) dateRange(date, from, to string) (time.Time, time.Time, error) {
+	if date != "" {
+		if from != "" || to != "" {
+			return time.Time{}, time.Time{}, fmt.Errorf("%w: -date cannot be combined with -from or -to", errUsage)
+		}
+		from, to = date, date
+	}
+	var start, end time.Time
+	if from != "" {
+		d, err := c.parseDate(from)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		start = d
+	}
+	if to != "" {
+		d, err := c.parseDate(to)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		end = d.AddDate(0, 0, 1)
+	}
+	return start, end, nil
+}
+
+// parseDate returns the start of the hospital-local day s.
+func (c *cli) parseDate(s string) (time.Time, error) {
+	d, err := time.ParseInLocation("2006-01-02", s, c.location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid date %q, want YYYY-MM-DD", errUsage, s)
+	}
+	return schedule.StartOfDay(d, c.location), nil
+}
+
+func parseID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("%w: invalid appointment ID %q", errUsage, s)
+	}
+	return id, nil
+}
+
+// timestamp converts t, leaving the zero time unset.
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func minutes(d time.Duration) string {
+	return strconv.Itoa(int(d / time.Minute))
+}
+
+func (c *cli) printAppointments(appointments ...*pbv2.AppointmentDetails) error {
+	header := []string{"ID", "DATE", "TIME", "MINUTES", "DOCTOR", "USER ID", "EMAIL", "STATUS"}
+	return c.printTable(header, len(appointments), func(i int) []string {
+		a := appointments[i]
+		start := a.Start.AsTime()
+		return []string{
+			strconv.FormatInt(a.Id, 10),
+			schedule.Date(start, c.location),
+			schedule.Clock(start, c.location),
+			minutes(a.Duration.AsDuration()),
+			a.DoctorName,
+			a.UserId,
+			a.Email,
+			a.Status,
+		}
+	})
+}
+
+// printTable prints n rows under header in aligned columns.
+func (c *cli) printTable(header []string, n int, row func(i int) []string) error {
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for i := range n {
+		fmt.Fprintln(tw, strings.Join(row(i), "\t"))
+	}
+	return tw.Flush()
+}
+
+// printJSON prints m in the protobuf JSON mapping, as the gateway serves
+// it. protojson varies its spacing on purpose, so it is indented again
+// for output that is stable.
+func (c *cli) printJSON(m proto.Message) error {
+	b, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, b, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(c.out)
+	return err
+}
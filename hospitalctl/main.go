@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"shubam/auth"
+	"shubam/certs"
+	"shubam/config"
+	pbv2 "shubam/proto/v2"
+	"shubam/schedule"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const usage = `usage: %[1]s [flags] COMMAND [command flags] [ARGS]
+
+  doctors
+        list the doctors
+  appointments [-patient EMAIL|ID] [-doctor NAME] [-date DATE | -from DATE -to DATE] [-limit N]
+        list the appointments matching every filter given, earliest first
+  cancel ID
+        cancel an appointment
+  reschedule ID DATE TIME
+        move an appointment to another free slot of its doctor
+  slots [-date DATE | -from DATE -to DATE] DOCTOR
+        list a doctor's booked slots
+
+DATE is YYYY-MM-DD and TIME is HH:MM in the hospital time zone; -from and
+-to include both days. Every command takes -o table (the default) or
+-o json. Run %[1]s -h for the connection flags.
+`
+
+// rpcTimeout gives every call a deadline of d, so listing many pages does
+// not share one deadline.
+func rpcTimeout(d time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// dial creates a client of the appointment server whose calls are signed
+// with the operator key, on behalf of no patient.
+func dial(cfg *config.Config) (*grpc.ClientConn, error) {
+	creds, err := certs.ClientCredentials(cfg.TLS, auth.OperatorService)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up TLS: %w", err)
+	}
+	return grpc.NewClient(cfg.AppointmentAddr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithPerRPCCredentials(auth.Credentials{
+			Key:      []byte(cfg.OperatorTokenKey),
+			Service:  auth.OperatorService,
+			Audience: "appointment-server",
+			Secure:   cfg.TLS.Mode != "off",
+		}),
+		grpc.WithChainUnaryInterceptor(rpcTimeout(cfg.RPCTimeout)),
+	)
+}
+
+func main() {
+	os.Exit(run(os.Args[0], os.Args[1:]))
+}
+
+// run runs the command in args and returns the exit status: 2 for
+// invalid arguments, 1 for any other failure.
+func run(program string, args []string) int {
+	cfg, args, err := config.Load(config.CLI, program, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", program, err)
+		return 2
+	}
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, usage, program)
+		return 2
+	}
+
+	location, err := schedule.LoadLocation(cfg.HospitalTimezone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", program, err)
+		return 1
+	}
+	conn, err := dial(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", program, err)
+		return 1
+	}
+	defer conn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := &cli{client: pbv2.NewHospitalServiceClient(conn), location: location, out: os.Stdout}
+	err = c.run(ctx, args)
+	if err == nil {
+		return 0
+	}
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n\n", program, err)
+		fmt.Fprintf(os.Stderr, usage, program)
+		return 2
+	}
+	if s, ok := status.FromError(err); ok {
+		fmt.Fprintf(os.Stderr, "%s: %s: %s\n", program, s.Code(), s.Message())
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", program, err)
+	}
+	return 1
+}
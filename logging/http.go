@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// RequestIDHeader carries the request ID in HTTP requests and responses.
+const RequestIDHeader = "X-Request-ID"
+
+// Middleware gives each request an ID, reusing a well-formed one sent by
+// the client or a proxy, echoes it in the response and logs the request
+// once it completes. Handlers log with the request context to be tagged
+// with the ID.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := WithRequestID(r.Context(), id)
+
+		start := time.Now()
+		rec := NewStatusRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if rec.Code >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "HTTP request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.Code,
+			"duration", time.Since(start))
+	})
+}
+
+// StatusRecorder remembers the status code written through it, for
+// middleware that reports on responses.
+type StatusRecorder struct {
+	http.ResponseWriter
+	// Code is the status written, 200 if the handler wrote none.
+	Code int
+}
+
+// NewStatusRecorder returns a StatusRecorder writing through w.
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, Code: http.StatusOK}
+}
+
+func (r *StatusRecorder) WriteHeader(code int) {
+	r.Code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *StatusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor records the count, status code and latency of
+// every unary RPC the server handles.
+func UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	grpcServerDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+	grpcServerHandled.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+	return resp, err
+}
+
+// UnaryClientInterceptor records the count, status code and latency of
+// every unary RPC made over the connection.
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	grpcClientDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	grpcClientHandled.WithLabelValues(method, status.Code(err).String()).Inc()
+	return err
+}
+
+// CountCalls returns a client interceptor that increments c for each
+// successful call to the fully qualified method.
+func CountCalls(method string, c prometheus.Counter) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, m string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, m, req, reply, cc, opts...)
+		if err == nil && m == method {
+			c.Inc()
+		}
+		return err
+	}
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	pharmacypb "shubam/proto/pharmacy"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+)
+
+func TestCountCalls(t *testing.T) {
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_orders_total"})
+	count := CountCalls(pharmacypb.PharmacyService_PlaceOrder_FullMethodName, c)
+	ok := func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error { return nil }
+	failed := func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error {
+		return errors.New("unavailable")
+	}
+
+	// The client the web server orders through calls exactly this method.
+	client := pharmacypb.NewPharmacyServiceClient(fakeConn{func(ctx context.Context, method string, req, reply any, opts ...grpc.CallOption) error {
+		return count(ctx, method, req, reply, nil, ok, opts...)
+	}})
+	if _, err := client.PlaceOrder(context.Background(), &pharmacypb.PlaceOrderRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	count(context.Background(), pharmacypb.PharmacyService_PlaceOrder_FullMethodName, nil, nil, nil, failed)
+	count(context.Background(), "/hospital.pharmacy.v1.PharmacyService/Other", nil, nil, nil, ok)
+
+	if got := testutil.ToFloat64(c); got != 1 {
+		t.Errorf("counter = %v, want 1 for the one successful PlaceOrder", got)
+	}
+}
+
+// fakeConn hands unary calls to invoke.
+type fakeConn struct {
+	invoke func(ctx context.Context, method string, req, reply any, opts ...grpc.CallOption) error
+}
+
+func (c fakeConn) Invoke(ctx context.Context, method string, req, reply any, opts ...grpc.CallOption) error {
+	return c.invoke(ctx, method, req, reply, opts...)
+}
+
+func (fakeConn) NewStream(context.Context, *grpc.StreamDesc, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+	return nil, errors.New("not supported")
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"net/http"
+
+	"shubam/logging"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Middleware records the count and latency of requests to next under
+// route, which should be the mux pattern rather than the request path so
+// the number of series stays bounded.
+func Middleware(route string, next http.Handler) http.Handler {
+	labels := prometheus.Labels{"route": route}
+	return promhttp.InstrumentHandlerDuration(httpDuration.MustCurryWith(labels),
+		promhttp.InstrumentHandlerCounter(httpRequests.MustCurryWith(labels), next))
+}
+
+// CountStatus increments c each time next answers with status code.
+func CountStatus(code int, c prometheus.Counter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := logging.NewStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+		if rec.Code == code {
+			c.Inc()
+		}
+	})
+}
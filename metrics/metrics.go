@@ -0,0 +1,92 @@
+// Package metrics defines the Prometheus metrics exported by both binaries
+// and the HTTP middleware, gRPC interceptors and store decorator that
+// record them, so handlers and RPC methods need no metrics code of their
+// own.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "hospital"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	grpcServerHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_server_handled_total",
+		Help:      "RPCs completed by the server, by method and status code.",
+	}, []string{"method", "code"})
+	grpcServerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_server_handling_seconds",
+		Help:      "Time taken by the server to handle RPCs, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	grpcClientHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_client_handled_total",
+		Help:      "RPCs completed by the client, by method and status code.",
+	}, []string{"method", "code"})
+	grpcClientDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_client_handling_seconds",
+		Help:      "Time taken for RPCs to complete as seen by the client, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	appointmentsBooked = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "appointments_booked_total",
+		Help:      "Appointments booked, by doctor.",
+	}, []string{"doctor"})
+	appointmentsCancelled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "appointments_cancelled_total",
+		Help:      "Appointments cancelled, by doctor.",
+	}, []string{"doctor"})
+
+	// LoginFailures counts rejected logins; see CountStatus.
+	LoginFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_failures_total",
+		Help:      "Login attempts rejected for a wrong email or password.",
+	})
+	// PharmacyOrders counts the PharmacyService.PlaceOrder calls that
+	// succeeded; the web server's pharmacy client increments it through
+	// CountCalls.
+	PharmacyOrders = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pharmacy_orders_total",
+		Help:      "Orders accepted by the pharmacy service.",
+	})
+)
+
+// RegisterDB exports the connection pool statistics of db, labelled with
+// the database name.
+func RegisterDB(db *sql.DB, name string) error {
+	return prometheus.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
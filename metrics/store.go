@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"context"
+
+	"shubam/store"
+)
+
+// appointmentStore counts bookings and cancellations as they reach the
+// store, whichever binary or handler made them.
+type appointmentStore struct {
+	store.AppointmentStore
+}
+
+// InstrumentAppointments wraps s so that every appointment it creates or
+// deletes is counted against its doctor.
+func InstrumentAppointments(s store.AppointmentStore) store.AppointmentStore {
+	return appointmentStore{s}
+}
+
+func (s appointmentStore) CreateAppointment(ctx context.Context, a store.Appointment) (store.Appointment, error) {
+	a, err := s.AppointmentStore.CreateAppointment(ctx, a)
+	if err == nil {
+		appointmentsBooked.WithLabelValues(a.DoctorName).Inc()
+	}
+	return a, err
+}
+
+func (s appointmentStore) DeleteAppointment(ctx context.Context, id int64) (store.Appointment, error) {
+	a, err := s.AppointmentStore.DeleteAppointment(ctx, id)
+	if err == nil {
+		appointmentsCancelled.WithLabelValues(a.DoctorName).Inc()
+	}
+	return a, err
+}
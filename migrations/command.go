@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+)
+
+const usage = `usage: %s migrate [up|down|status] [flags]
+
+  up      apply all pending migrations (default)
+  down    revert the most recent migrations (see -steps)
+  status  list migrations and whether they have been applied
+
+`
+
+// Command implements the "migrate" subcommand shared by every binary. args
+// are the arguments after "migrate"; timeZone is the hospital's.
+func Command(ctx context.Context, db *sql.DB, timeZone, program string, args []string, out io.Writer) error {
+	action := "up"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		action, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet("migrate "+action, flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintf(out, usage, program)
+		fs.PrintDefaults()
+	}
+	dryRun := fs.Bool("dry-run", false, "print the SQL that would run without executing it")
+	steps := fs.Int("steps", 1, "number of migrations to revert with down")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	opts := Options{DryRun: *dryRun, Out: out, TimeZone: timeZone}
+	switch action {
+	case "up":
+		return Up(ctx, db, opts)
+	case "down":
+		if *steps < 1 {
+			return fmt.Errorf("-steps must be at least 1")
+		}
+		return Down(ctx, db, *steps, opts)
+	case "status":
+		return Status(ctx, db, out)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown migrate action %q", action)
+	}
+}
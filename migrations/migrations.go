@@ -0,0 +1,308 @@
+// Package migrations holds the database schema as ordered SQL files embedded
+// in every binary, and applies them with a schema_migrations table to record
+// what has run. A Postgres advisory lock serialises concurrent runners, so
+// several instances may start at once without racing.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID is the pg_advisory_lock key shared by every runner of this schema.
+const lockID = 7_106_220_028
+
+// Migration is one numbered schema change, loaded from
+// sql/<version>_<name>.up.sql and its matching .down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Options controls how migrations are applied.
+type Options struct {
+	// DryRun prints the SQL that would run without executing it or
+	// touching schema_migrations.
+	DryRun bool
+	// Out receives progress messages and, in dry-run mode, the SQL.
+	Out io.Writer
+	// TimeZone is the hospital's IANA time zone, which migrations that
+	// convert between local and absolute times read as hospital.timezone.
+	TimeZone string
+}
+
+// Load returns the embedded migrations ordered by version.
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		name := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s: expected .up.sql or .down.sql suffix", name)
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		num, label, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: expected <version>_<name>", name)
+		}
+		version, err := strconv.Atoi(num)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: bad version: %w", name, err)
+		}
+
+		body, err := files.ReadFile(path.Join("sql", name))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		} else if m.Name != label {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies every migration that has not run yet, oldest first.
+func Up(ctx context.Context, db *sql.DB, opts Options) error {
+	return withLock(ctx, db, func(conn *sql.Conn) error {
+		all, applied, err := state(ctx, conn, opts.DryRun)
+		if err != nil {
+			return err
+		}
+
+		pending := 0
+		for _, m := range all {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			pending++
+			if err := apply(ctx, conn, opts, m, m.Up, "up"); err != nil {
+				return err
+			}
+		}
+		if pending == 0 {
+			fmt.Fprintln(opts.out(), "Database schema is up to date")
+		}
+		return nil
+	})
+}
+
+// Down reverts the most recently applied steps migrations, newest first.
+func Down(ctx context.Context, db *sql.DB, steps int, opts Options) error {
+	return withLock(ctx, db, func(conn *sql.Conn) error {
+		all, applied, err := state(ctx, conn, opts.DryRun)
+		if err != nil {
+			return err
+		}
+
+		for i := len(all) - 1; i >= 0 && steps > 0; i-- {
+			m := all[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if err := apply(ctx, conn, opts, m, m.Down, "down"); err != nil {
+				return err
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// Status writes one line per known migration saying whether it has run.
+func Status(ctx context.Context, db *sql.DB, out io.Writer) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	all, applied, err := state(ctx, conn, true)
+	if err != nil {
+		return err
+	}
+	for _, m := range all {
+		if at, ok := applied[m.Version]; ok {
+			fmt.Fprintf(out, "%04d_%s\tapplied %s\n", m.Version, m.Name, at.Format(time.RFC3339))
+		} else {
+			fmt.Fprintf(out, "%04d_%s\tpending\n", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// Check returns an error naming the migrations that have not run yet, if
+// any. The stores query the latest schema, so the binaries call it at
+// startup rather than fail on their first query.
+func Check(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	all, applied, err := state(ctx, conn, true)
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, m := range all {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, fmt.Sprintf("%04d_%s", m.Version, m.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is missing migrations %s; run migrate or set DB_AUTO_MIGRATE=true", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// withLock runs fn on a single connection holding the session-level
+// advisory lock, so the lock and the migrations share a session.
+func withLock(ctx context.Context, db *sql.DB, fn func(*sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		return fmt.Errorf("error acquiring migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockID)
+
+	return fn(conn)
+}
+
+// state loads the embedded migrations and the versions already applied.
+// Unless readOnly is set it creates schema_migrations when missing.
+func state(ctx context.Context, conn *sql.Conn, readOnly bool) ([]Migration, map[int]time.Time, error) {
+	all, err := Load()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !readOnly {
+		_, err := conn.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS schema_migrations (
+				version    INTEGER PRIMARY KEY,
+				name       TEXT NOT NULL,
+				applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			)`)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating schema_migrations: %w", err)
+		}
+	}
+
+	applied := make(map[int]time.Time)
+	var exists bool
+	err = conn.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !exists {
+		return all, applied, nil
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, nil, err
+		}
+		applied[version] = at
+	}
+	return all, applied, rows.Err()
+}
+
+// apply runs one direction of m in its own transaction together with the
+// matching schema_migrations bookkeeping.
+func apply(ctx context.Context, conn *sql.Conn, opts Options, m Migration, body, direction string) error {
+	out := opts.out()
+	if opts.DryRun {
+		fmt.Fprintf(out, "-- %04d_%s (%s, dry run, hospital.timezone %q)\n%s\n", m.Version, m.Name, direction, opts.TimeZone, strings.TrimSpace(body))
+		return nil
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT set_config('hospital.timezone', $1, true)", opts.TimeZone); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return fmt.Errorf("migration %04d_%s %s: %w", m.Version, m.Name, direction, err)
+	}
+	if direction == "up" {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("migration %04d_%s: recording %s: %w", m.Version, m.Name, direction, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Applied migration %04d_%s (%s)\n", m.Version, m.Name, direction)
+	return nil
+}
+
+func (o Options) out() io.Writer {
+	if o.Out == nil {
+		return io.Discard
+	}
+	return o.Out
+}
@@ -0,0 +1,2 @@
+-- Dropping the table also drops created_at on an adopted database.
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,11 @@
+-- IF NOT EXISTS lets databases created by hand before migrations existed
+-- adopt this history. Their users table has only id, email and password,
+-- so the columns added since are guarded the same way.
+CREATE TABLE IF NOT EXISTS users (
+    id         SERIAL PRIMARY KEY,
+    email      TEXT NOT NULL UNIQUE,
+    password   TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
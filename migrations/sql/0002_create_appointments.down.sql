@@ -0,0 +1,2 @@
+-- Dropping the table also drops any column 0002 added to an adopted one.
+DROP TABLE IF EXISTS appointments;
@@ -0,0 +1,21 @@
+-- The original schema, with the slot stored as separate local DATE and
+-- TIME columns. IF NOT EXISTS lets databases created by hand before
+-- migrations existed adopt this history; the column guards make sure an
+-- adopted table has every column later migrations expect.
+CREATE TABLE IF NOT EXISTS appointments (
+    id          SERIAL PRIMARY KEY,
+    doctor_name TEXT NOT NULL,
+    user_id     INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email       TEXT NOT NULL,
+    date        DATE NOT NULL,
+    time        TIME NOT NULL,
+    status      TEXT NOT NULL DEFAULT 'BOOKED'
+);
+
+ALTER TABLE appointments
+    ADD COLUMN IF NOT EXISTS doctor_name TEXT NOT NULL,
+    ADD COLUMN IF NOT EXISTS user_id     INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS email       TEXT NOT NULL,
+    ADD COLUMN IF NOT EXISTS date        DATE NOT NULL,
+    ADD COLUMN IF NOT EXISTS time        TIME NOT NULL,
+    ADD COLUMN IF NOT EXISTS status      TEXT NOT NULL DEFAULT 'BOOKED';
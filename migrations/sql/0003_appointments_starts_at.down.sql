@@ -0,0 +1,23 @@
+DO $$
+BEGIN
+    IF nullif(current_setting('hospital.timezone', true), '') IS NULL THEN
+        RAISE EXCEPTION 'hospital.timezone is not set; run this migration with HOSPITAL_TIMEZONE set';
+    END IF;
+END $$;
+
+DROP INDEX IF EXISTS appointments_doctor_slot_key;
+DROP INDEX IF EXISTS appointments_user_id_starts_at_idx;
+
+ALTER TABLE appointments
+    ADD COLUMN date DATE,
+    ADD COLUMN time TIME;
+
+UPDATE appointments
+   SET date = (starts_at AT TIME ZONE current_setting('hospital.timezone'))::date,
+       time = (starts_at AT TIME ZONE current_setting('hospital.timezone'))::time;
+
+ALTER TABLE appointments
+    ALTER COLUMN date SET NOT NULL,
+    ALTER COLUMN time SET NOT NULL,
+    DROP COLUMN starts_at,
+    DROP COLUMN duration_minutes;
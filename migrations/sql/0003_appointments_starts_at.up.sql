@@ -0,0 +1,47 @@
+-- Replace the local DATE/TIME pair with a single instant and a duration.
+-- Existing rows are wall-clock time in the hospital time zone, which the
+-- runner passes in as hospital.timezone (HOSPITAL_TIMEZONE) rather than
+-- leaving it to the session TimeZone.
+DO $$
+BEGIN
+    IF nullif(current_setting('hospital.timezone', true), '') IS NULL THEN
+        RAISE EXCEPTION 'hospital.timezone is not set; run this migration with HOSPITAL_TIMEZONE set';
+    END IF;
+END $$;
+
+ALTER TABLE appointments
+    ADD COLUMN starts_at        TIMESTAMPTZ,
+    ADD COLUMN duration_minutes INTEGER NOT NULL DEFAULT 60 CHECK (duration_minutes > 0);
+
+UPDATE appointments SET starts_at = (date + time) AT TIME ZONE current_setting('hospital.timezone');
+
+ALTER TABLE appointments
+    ALTER COLUMN starts_at SET NOT NULL,
+    DROP COLUMN date,
+    DROP COLUMN time;
+
+CREATE INDEX appointments_user_id_starts_at_idx ON appointments (user_id, starts_at);
+
+-- The old schema let a slot be booked twice. Which booking stands is for
+-- the hospital to decide, so stop and name them instead of picking one.
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(format('%s at %s (appointments %s)', doctor_name, local_start, ids), '; ')
+      INTO duplicates
+      FROM (SELECT doctor_name,
+                   starts_at AT TIME ZONE current_setting('hospital.timezone') AS local_start,
+                   string_agg(id::text, ', ' ORDER BY id) AS ids
+              FROM appointments
+             WHERE status = 'BOOKED'
+             GROUP BY doctor_name, starts_at
+            HAVING count(*) > 1) AS d;
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'slots booked more than once, cancel all but one booking of each and migrate again: %', duplicates;
+    END IF;
+END $$;
+
+-- A doctor can only hold one booking per slot.
+CREATE UNIQUE INDEX appointments_doctor_slot_key ON appointments (doctor_name, starts_at)
+    WHERE status = 'BOOKED';
@@ -0,0 +1,12 @@
+CREATE TABLE doctors (
+    id         SERIAL PRIMARY KEY,
+    name       TEXT NOT NULL UNIQUE,
+    specialty  TEXT NOT NULL,
+    experience TEXT NOT NULL DEFAULT '',
+    photo_url  TEXT NOT NULL DEFAULT ''
+);
+
+-- The doctors that used to be hardcoded in appointment.html.
+INSERT INTO doctors (name, specialty, photo_url) VALUES
+    ('Dr. John Doe', 'Cardiology', 'https://i.pravatar.cc/120?img=12'),
+    ('Dr. Jane Doe', 'Neurology', 'https://i.pravatar.cc/120?img=5');
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS appointments_user_idempotency_key;
+
+ALTER TABLE appointments DROP COLUMN idempotency_key;
@@ -0,0 +1,6 @@
+-- Lets the appointment server recognise a retried booking: a patient's
+-- repeated request with the same key returns the first appointment.
+ALTER TABLE appointments ADD COLUMN idempotency_key TEXT;
+
+CREATE UNIQUE INDEX appointments_user_idempotency_key ON appointments (user_id, idempotency_key)
+    WHERE idempotency_key IS NOT NULL;
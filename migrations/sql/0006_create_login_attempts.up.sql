@@ -0,0 +1,12 @@
+-- Every login attempt, kept both to rate-limit logins and as an audit
+-- trail. Emails are stored lower-cased and need not belong to a user.
+CREATE TABLE login_attempts (
+    id           BIGSERIAL PRIMARY KEY,
+    email        TEXT NOT NULL,
+    ip           INET NOT NULL,
+    success      BOOLEAN NOT NULL,
+    attempted_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX login_attempts_email_attempted_at_idx ON login_attempts (email, attempted_at);
+CREATE INDEX login_attempts_ip_attempted_at_idx ON login_attempts (ip, attempted_at);
@@ -0,0 +1,15 @@
+-- Accounts start unverified until the patient follows the link emailed to
+-- them. Each link sent is recorded so it can be used only once and so
+-- resends can be throttled; only a hash of the link's nonce is stored.
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+
+CREATE TABLE email_verifications (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash BYTEA NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ
+);
+
+CREATE INDEX email_verifications_user_id_created_at_idx ON email_verifications (user_id, created_at);
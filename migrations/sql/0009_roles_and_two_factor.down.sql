@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS recovery_codes;
+
+DROP TABLE IF EXISTS user_totp;
+
+ALTER TABLE users DROP COLUMN role;
@@ -0,0 +1,22 @@
+-- Staff roles, some of which must use two-factor authentication, and the
+-- TOTP secrets and recovery codes behind it. A secret without enabled_at
+-- is an enrolment the user has not confirmed yet. last_step is the time
+-- step of the last accepted code, so a code cannot be used twice.
+-- Recovery codes are stored hashed.
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'patient'
+    CHECK (role IN ('patient', 'doctor', 'pharmacist', 'admin'));
+
+CREATE TABLE user_totp (
+    user_id    INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret     TEXT NOT NULL,
+    enabled_at TIMESTAMPTZ,
+    last_step  BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE recovery_codes (
+    id        BIGSERIAL PRIMARY KEY,
+    user_id   INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash BYTEA NOT NULL,
+    used_at   TIMESTAMPTZ,
+    UNIQUE (user_id, code_hash)
+);
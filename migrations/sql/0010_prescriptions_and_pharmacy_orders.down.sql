@@ -0,0 +1,2 @@
+DROP TABLE pharmacy_orders;
+DROP TABLE prescriptions;
@@ -0,0 +1,27 @@
+-- Prescriptions doctors write for patients, and the orders patients place
+-- for them with the pharmacy service. A prescription is ordered at most
+-- once. An order is PENDING until the pharmacy accepts it and returns its
+-- reference.
+CREATE TABLE prescriptions (
+    id            BIGSERIAL PRIMARY KEY,
+    user_id       INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    prescriber_id INTEGER NOT NULL REFERENCES users (id),
+    prescribed_by TEXT NOT NULL,
+    medicine      TEXT NOT NULL,
+    dosage        TEXT NOT NULL,
+    quantity      INTEGER NOT NULL CHECK (quantity > 0),
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX prescriptions_user_id_id_idx ON prescriptions (user_id, id);
+
+CREATE TABLE pharmacy_orders (
+    id              BIGSERIAL PRIMARY KEY,
+    user_id         INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    prescription_id BIGINT NOT NULL UNIQUE REFERENCES prescriptions (id) ON DELETE CASCADE,
+    status          TEXT NOT NULL DEFAULT 'PENDING' CHECK (status IN ('PENDING', 'PLACED')),
+    reference       TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX pharmacy_orders_user_id_id_idx ON pharmacy_orders (user_id, id);
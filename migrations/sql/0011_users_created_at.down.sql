@@ -0,0 +1,3 @@
+-- created_at belongs to 0001; an adopted table keeps it until 0001 is
+-- rolled back.
+SELECT 1;
@@ -0,0 +1,3 @@
+-- Databases adopted before 0001 guarded created_at applied it without
+-- adding the column to their hand-made users table.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
@@ -0,0 +1,2 @@
+-- The emails stay normalized; only the index goes.
+DROP INDEX IF EXISTS users_email_lower_key;
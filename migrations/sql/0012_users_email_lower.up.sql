@@ -0,0 +1,22 @@
+-- Emails are stored trimmed and in lower case, and one address holds one
+-- account however it is typed. Accounts registered before differing only
+-- in case or spacing must be merged by hand, so stop and name them.
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(format('%s (users %s)', email, ids), '; ')
+      INTO duplicates
+      FROM (SELECT lower(btrim(email)) AS email,
+                   string_agg(id::text, ', ' ORDER BY id) AS ids
+              FROM users
+             GROUP BY lower(btrim(email))
+            HAVING count(*) > 1) AS d;
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'emails registered more than once in different case, merge or rename all but one account of each and migrate again: %', duplicates;
+    END IF;
+END $$;
+
+UPDATE users SET email = lower(btrim(email)) WHERE email <> lower(btrim(email));
+
+CREATE UNIQUE INDEX users_email_lower_key ON users (lower(email));
@@ -0,0 +1,122 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             v5.27.0
+// source: proto/pharmacy/pharmacy.proto
+
+package pharmacypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	PharmacyService_PlaceOrder_FullMethodName = "/hospital.pharmacy.v1.PharmacyService/PlaceOrder"
+)
+
+// PharmacyServiceClient is the client API for PharmacyService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// PharmacyService is what the web server needs of the pharmacy service at
+// PHARMACY_ADDR: it fills prescriptions that patients order. Calls carry
+// the patient in their token, like those to the appointment server.
+type PharmacyServiceClient interface {
+	// PlaceOrder is idempotent in orderId: placing an order again returns
+	// the reference it was given the first time.
+	PlaceOrder(ctx context.Context, in *PlaceOrderRequest, opts ...grpc.CallOption) (*PlaceOrderResponse, error)
+}
+
+type pharmacyServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPharmacyServiceClient(cc grpc.ClientConnInterface) PharmacyServiceClient {
+	return &pharmacyServiceClient{cc}
+}
+
+func (c *pharmacyServiceClient) PlaceOrder(ctx context.Context, in *PlaceOrderRequest, opts ...grpc.CallOption) (*PlaceOrderResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PlaceOrderResponse)
+	err := c.cc.Invoke(ctx, PharmacyService_PlaceOrder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PharmacyServiceServer is the server API for PharmacyService service.
+// All implementations must embed UnimplementedPharmacyServiceServer
+// for forward compatibility
+//
+// PharmacyService is what the web server needs of the pharmacy service at
+// PHARMACY_ADDR: it fills prescriptions that patients order. Calls carry
+// the patient in their token, like those to the appointment server.
+type PharmacyServiceServer interface {
+	// PlaceOrder is idempotent in orderId: placing an order again returns
+	// the reference it was given the first time.
+	PlaceOrder(context.Context, *PlaceOrderRequest) (*PlaceOrderResponse, error)
+	mustEmbedUnimplementedPharmacyServiceServer()
+}
+
+// UnimplementedPharmacyServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedPharmacyServiceServer struct {
+}
+
+func (UnimplementedPharmacyServiceServer) PlaceOrder(context.Context, *PlaceOrderRequest) (*PlaceOrderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PlaceOrder not implemented")
+}
+func (UnimplementedPharmacyServiceServer) mustEmbedUnimplementedPharmacyServiceServer() {}
+
+// UnsafePharmacyServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PharmacyServiceServer will
+// result in compilation errors.
+type UnsafePharmacyServiceServer interface {
+	mustEmbedUnimplementedPharmacyServiceServer()
+}
+
+func RegisterPharmacyServiceServer(s grpc.ServiceRegistrar, srv PharmacyServiceServer) {
+	s.RegisterService(&PharmacyService_ServiceDesc, srv)
+}
+
+func _PharmacyService_PlaceOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PlaceOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PharmacyServiceServer).PlaceOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PharmacyService_PlaceOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PharmacyServiceServer).PlaceOrder(ctx, req.(*PlaceOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PharmacyService_ServiceDesc is the grpc.ServiceDesc for PharmacyService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PharmacyService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hospital.pharmacy.v1.PharmacyService",
+	HandlerType: (*PharmacyServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PlaceOrder",
+			Handler:    _PharmacyService_PlaceOrder_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/pharmacy/pharmacy.proto",
+}
@@ -26,6 +26,9 @@ const (
 // HospitalServiceClient is the client API for HospitalService service.
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// The HTTP annotations expose every method as JSON through the web
+// server's gateway, under /gateway.
 type HospitalServiceClient interface {
 	Appointment(ctx context.Context, in *AppointmentRequest, opts ...grpc.CallOption) (*AppointmentResponse, error)
 	GetBookedSlots(ctx context.Context, in *GetBookedSlotsRequest, opts ...grpc.CallOption) (*GetBookedSlotsResponse, error)
@@ -62,6 +65,9 @@ func (c *hospitalServiceClient) GetBookedSlots(ctx context.Context, in *GetBooke
 // HospitalServiceServer is the server API for HospitalService service.
 // All implementations must embed UnimplementedHospitalServiceServer
 // for forward compatibility
+//
+// The HTTP annotations expose every method as JSON through the web
+// server's gateway, under /gateway.
 type HospitalServiceServer interface {
 	Appointment(context.Context, *AppointmentRequest) (*AppointmentResponse, error)
 	GetBookedSlots(context.Context, *GetBookedSlotsRequest) (*GetBookedSlotsResponse, error)
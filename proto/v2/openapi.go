@@ -0,0 +1,9 @@
+package hospitalv2
+
+import _ "embed"
+
+// OpenAPI describes the HTTP gateway to HospitalService in OpenAPI 2.0
+// (Swagger). protoc-gen-openapiv2 generates it from service.proto.
+//
+//go:embed service.swagger.json
+var OpenAPI []byte
@@ -0,0 +1,320 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             v5.27.0
+// source: proto/v2/service.proto
+
+package hospitalv2
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	HospitalService_Appointment_FullMethodName           = "/hospital.v2.HospitalService/Appointment"
+	HospitalService_GetBookedSlots_FullMethodName        = "/hospital.v2.HospitalService/GetBookedSlots"
+	HospitalService_ListDoctors_FullMethodName           = "/hospital.v2.HospitalService/ListDoctors"
+	HospitalService_ListAppointments_FullMethodName      = "/hospital.v2.HospitalService/ListAppointments"
+	HospitalService_CancelAppointment_FullMethodName     = "/hospital.v2.HospitalService/CancelAppointment"
+	HospitalService_RescheduleAppointment_FullMethodName = "/hospital.v2.HospitalService/RescheduleAppointment"
+)
+
+// HospitalServiceClient is the client API for HospitalService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// HospitalService is the v2 booking API. Unlike v1, appointment times are
+// absolute instants; timeZone only says how the hospital displays them.
+//
+// ListAppointments, CancelAppointment and RescheduleAppointment are for
+// operators: only calls the hospitalctl tool makes on its own behalf may
+// use them.
+//
+// The HTTP annotations expose every method as JSON through the web
+// server's gateway, under /gateway.
+type HospitalServiceClient interface {
+	Appointment(ctx context.Context, in *AppointmentRequest, opts ...grpc.CallOption) (*AppointmentResponse, error)
+	GetBookedSlots(ctx context.Context, in *GetBookedSlotsRequest, opts ...grpc.CallOption) (*GetBookedSlotsResponse, error)
+	ListDoctors(ctx context.Context, in *ListDoctorsRequest, opts ...grpc.CallOption) (*ListDoctorsResponse, error)
+	ListAppointments(ctx context.Context, in *ListAppointmentsRequest, opts ...grpc.CallOption) (*ListAppointmentsResponse, error)
+	CancelAppointment(ctx context.Context, in *CancelAppointmentRequest, opts ...grpc.CallOption) (*AppointmentDetails, error)
+	RescheduleAppointment(ctx context.Context, in *RescheduleAppointmentRequest, opts ...grpc.CallOption) (*AppointmentDetails, error)
+}
+
+type hospitalServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewHospitalServiceClient(cc grpc.ClientConnInterface) HospitalServiceClient {
+	return &hospitalServiceClient{cc}
+}
+
+func (c *hospitalServiceClient) Appointment(ctx context.Context, in *AppointmentRequest, opts ...grpc.CallOption) (*AppointmentResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AppointmentResponse)
+	err := c.cc.Invoke(ctx, HospitalService_Appointment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hospitalServiceClient) GetBookedSlots(ctx context.Context, in *GetBookedSlotsRequest, opts ...grpc.CallOption) (*GetBookedSlotsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetBookedSlotsResponse)
+	err := c.cc.Invoke(ctx, HospitalService_GetBookedSlots_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hospitalServiceClient) ListDoctors(ctx context.Context, in *ListDoctorsRequest, opts ...grpc.CallOption) (*ListDoctorsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDoctorsResponse)
+	err := c.cc.Invoke(ctx, HospitalService_ListDoctors_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hospitalServiceClient) ListAppointments(ctx context.Context, in *ListAppointmentsRequest, opts ...grpc.CallOption) (*ListAppointmentsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListAppointmentsResponse)
+	err := c.cc.Invoke(ctx, HospitalService_ListAppointments_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hospitalServiceClient) CancelAppointment(ctx context.Context, in *CancelAppointmentRequest, opts ...grpc.CallOption) (*AppointmentDetails, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AppointmentDetails)
+	err := c.cc.Invoke(ctx, HospitalService_CancelAppointment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hospitalServiceClient) RescheduleAppointment(ctx context.Context, in *RescheduleAppointmentRequest, opts ...grpc.CallOption) (*AppointmentDetails, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AppointmentDetails)
+	err := c.cc.Invoke(ctx, HospitalService_RescheduleAppointment_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// HospitalServiceServer is the server API for HospitalService service.
+// All implementations must embed UnimplementedHospitalServiceServer
+// for forward compatibility
+//
+// HospitalService is the v2 booking API. Unlike v1, appointment times are
+// absolute instants; timeZone only says how the hospital displays them.
+//
+// ListAppointments, CancelAppointment and RescheduleAppointment are for
+// operators: only calls the hospitalctl tool makes on its own behalf may
+// use them.
+//
+// The HTTP annotations expose every method as JSON through the web
+// server's gateway, under /gateway.
+type HospitalServiceServer interface {
+	Appointment(context.Context, *AppointmentRequest) (*AppointmentResponse, error)
+	GetBookedSlots(context.Context, *GetBookedSlotsRequest) (*GetBookedSlotsResponse, error)
+	ListDoctors(context.Context, *ListDoctorsRequest) (*ListDoctorsResponse, error)
+	ListAppointments(context.Context, *ListAppointmentsRequest) (*ListAppointmentsResponse, error)
+	CancelAppointment(context.Context, *CancelAppointmentRequest) (*AppointmentDetails, error)
+	RescheduleAppointment(context.Context, *RescheduleAppointmentRequest) (*AppointmentDetails, error)
+	mustEmbedUnimplementedHospitalServiceServer()
+}
+
+// UnimplementedHospitalServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedHospitalServiceServer struct {
+}
+
+func (UnimplementedHospitalServiceServer) Appointment(context.Context, *AppointmentRequest) (*AppointmentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Appointment not implemented")
+}
+func (UnimplementedHospitalServiceServer) GetBookedSlots(context.Context, *GetBookedSlotsRequest) (*GetBookedSlotsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBookedSlots not implemented")
+}
+func (UnimplementedHospitalServiceServer) ListDoctors(context.Context, *ListDoctorsRequest) (*ListDoctorsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDoctors not implemented")
+}
+func (UnimplementedHospitalServiceServer) ListAppointments(context.Context, *ListAppointmentsRequest) (*ListAppointmentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAppointments not implemented")
+}
+func (UnimplementedHospitalServiceServer) CancelAppointment(context.Context, *CancelAppointmentRequest) (*AppointmentDetails, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelAppointment not implemented")
+}
+func (UnimplementedHospitalServiceServer) RescheduleAppointment(context.Context, *RescheduleAppointmentRequest) (*AppointmentDetails, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RescheduleAppointment not implemented")
+}
+func (UnimplementedHospitalServiceServer) mustEmbedUnimplementedHospitalServiceServer() {}
+
+// UnsafeHospitalServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to HospitalServiceServer will
+// result in compilation errors.
+type UnsafeHospitalServiceServer interface {
+	mustEmbedUnimplementedHospitalServiceServer()
+}
+
+func RegisterHospitalServiceServer(s grpc.ServiceRegistrar, srv HospitalServiceServer) {
+	s.RegisterService(&HospitalService_ServiceDesc, srv)
+}
+
+func _HospitalService_Appointment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AppointmentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HospitalServiceServer).Appointment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HospitalService_Appointment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HospitalServiceServer).Appointment(ctx, req.(*AppointmentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HospitalService_GetBookedSlots_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBookedSlotsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HospitalServiceServer).GetBookedSlots(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HospitalService_GetBookedSlots_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HospitalServiceServer).GetBookedSlots(ctx, req.(*GetBookedSlotsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HospitalService_ListDoctors_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDoctorsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HospitalServiceServer).ListDoctors(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HospitalService_ListDoctors_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HospitalServiceServer).ListDoctors(ctx, req.(*ListDoctorsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HospitalService_ListAppointments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAppointmentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HospitalServiceServer).ListAppointments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HospitalService_ListAppointments_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HospitalServiceServer).ListAppointments(ctx, req.(*ListAppointmentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HospitalService_CancelAppointment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelAppointmentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HospitalServiceServer).CancelAppointment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HospitalService_CancelAppointment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HospitalServiceServer).CancelAppointment(ctx, req.(*CancelAppointmentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HospitalService_RescheduleAppointment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RescheduleAppointmentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HospitalServiceServer).RescheduleAppointment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HospitalService_RescheduleAppointment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HospitalServiceServer).RescheduleAppointment(ctx, req.(*RescheduleAppointmentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// HospitalService_ServiceDesc is the grpc.ServiceDesc for HospitalService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var HospitalService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hospital.v2.HospitalService",
+	HandlerType: (*HospitalServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Appointment",
+			Handler:    _HospitalService_Appointment_Handler,
+		},
+		{
+			MethodName: "GetBookedSlots",
+			Handler:    _HospitalService_GetBookedSlots_Handler,
+		},
+		{
+			MethodName: "ListDoctors",
+			Handler:    _HospitalService_ListDoctors_Handler,
+		},
+		{
+			MethodName: "ListAppointments",
+			Handler:    _HospitalService_ListAppointments_Handler,
+		},
+		{
+			MethodName: "CancelAppointment",
+			Handler:    _HospitalService_CancelAppointment_Handler,
+		},
+		{
+			MethodName: "RescheduleAppointment",
+			Handler:    _HospitalService_RescheduleAppointment_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/v2/service.proto",
+}
@@ -0,0 +1,133 @@
+// Package schedule holds the hospital's booking calendar: which slots exist,
+// how far ahead patients may book, and how instants map to the hospital's
+// local dates and clock times. Every binary that shows or accepts a slot
+// goes through here so they all agree on the hospital time zone.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// SlotDuration is the length of every bookable slot.
+	SlotDuration = time.Hour
+
+	// BookingWindowDays is how many calendar days, starting today, are
+	// offered on the booking page.
+	BookingWindowDays = 7
+
+	dateLayout  = "2006-01-02"
+	clockLayout = "15:04"
+)
+
+// Times lists the hospital-local start times of the slots offered each day.
+var Times = []string{"09:00", "10:00", "11:00", "14:00", "15:00", "16:00"}
+
+var (
+	ErrInPast        = errors.New("slot has already started")
+	ErrOutsideWindow = errors.New("slot is outside the booking window")
+	ErrNotASlot      = errors.New("not a bookable slot time")
+)
+
+// LoadLocation resolves the hospital time zone. An empty name means UTC.
+func LoadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		name = "UTC"
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("error loading hospital time zone %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+// Date formats t as a hospital-local calendar date ("2006-01-02").
+func Date(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(dateLayout)
+}
+
+// Clock formats t as a hospital-local wall-clock time ("15:04").
+func Clock(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(clockLayout)
+}
+
+// StartOfDay returns local midnight of the hospital day containing t.
+func StartOfDay(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// Dates returns local midnight of each day in the booking window, starting
+// with the hospital's today. Days are stepped by calendar date rather than
+// 24h so a DST change never skips or repeats a day.
+func Dates(now time.Time, loc *time.Location) []time.Time {
+	today := StartOfDay(now, loc)
+	dates := make([]time.Time, BookingWindowDays)
+	for i := range dates {
+		dates[i] = today.AddDate(0, 0, i)
+	}
+	return dates
+}
+
+// ParseSlot reads a hospital-local date and clock time as an instant. Wall
+// times that do not exist because the clocks sprang forward are rejected
+// rather than silently shifted by an hour; for times that occur twice when
+// the clocks fall back, the first occurrence is used.
+func ParseSlot(date, clock string, loc *time.Location) (time.Time, error) {
+	wall, err := time.Parse(dateLayout+" "+clockLayout, date+" "+clock)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("date %q and time %q: %w", date, clock, err)
+	}
+
+	// time.Date leaves unspecified which instant an ambiguous wall time
+	// becomes, so try every offset in use around it: each one that gives
+	// back the same wall time is an occurrence, and the largest offset is
+	// the earliest.
+	var (
+		t     time.Time
+		found bool
+	)
+	for _, near := range []time.Time{wall.Add(-24 * time.Hour), wall, wall.Add(24 * time.Hour)} {
+		_, offset := near.In(loc).Zone()
+		candidate := wall.Add(-time.Duration(offset) * time.Second)
+		if candidate.In(loc).Format(dateLayout+" "+clockLayout) != date+" "+clock {
+			continue
+		}
+		if !found || candidate.Before(t) {
+			t, found = candidate, true
+		}
+	}
+	if !found {
+		return time.Time{}, fmt.Errorf("%s %s does not exist in %s", date, clock, loc)
+	}
+	return t.In(loc), nil
+}
+
+// Validate reports whether start is a slot a patient may book at now: one
+// of Times on a day inside the booking window, and not already started.
+func Validate(start, now time.Time, loc *time.Location) error {
+	if !start.After(now) {
+		return ErrInPast
+	}
+
+	day := StartOfDay(start, loc)
+	first := StartOfDay(now, loc)
+	if day.Before(first) || !day.Before(first.AddDate(0, 0, BookingWindowDays)) {
+		return ErrOutsideWindow
+	}
+
+	local := start.In(loc)
+	if local.Second() != 0 || local.Nanosecond() != 0 {
+		return ErrNotASlot
+	}
+	clock := local.Format(clockLayout)
+	for _, t := range Times {
+		if t == clock {
+			return nil
+		}
+	}
+	return ErrNotASlot
+}
@@ -0,0 +1,163 @@
+package schedule
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newYork(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	return loc
+}
+
+func TestParseSlot(t *testing.T) {
+	loc := newYork(t)
+	tests := []struct {
+		name        string
+		date, clock string
+		want        string // RFC 3339, or "" for an error
+	}{
+		{"winter", "2024-01-15", "09:00", "2024-01-15T09:00:00-05:00"},
+		{"summer", "2024-07-15", "09:00", "2024-07-15T09:00:00-04:00"},
+		{"before spring forward", "2024-03-10", "01:30", "2024-03-10T01:30:00-05:00"},
+		{"spring forward gap", "2024-03-10", "02:30", ""},
+		{"after spring forward", "2024-03-10", "03:30", "2024-03-10T03:30:00-04:00"},
+		{"fall back first occurrence", "2024-11-03", "01:30", "2024-11-03T01:30:00-04:00"},
+		{"after fall back", "2024-11-03", "02:30", "2024-11-03T02:30:00-05:00"},
+		{"bad date", "2024-02-30", "09:00", ""},
+		{"bad clock", "2024-01-15", "9am", ""},
+		{"unpadded", "2024-1-15", "09:00", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSlot(tt.date, tt.clock, loc)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("ParseSlot(%q, %q) = %v, want error", tt.date, tt.clock, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSlot(%q, %q): %v", tt.date, tt.clock, err)
+			}
+			if s := got.Format(time.RFC3339); s != tt.want {
+				t.Errorf("ParseSlot(%q, %q) = %s, want %s", tt.date, tt.clock, s, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartOfDay(t *testing.T) {
+	loc := newYork(t)
+	tests := []struct {
+		name  string
+		t     time.Time
+		want  string
+		hours float64 // length of the day
+	}{
+		{"spring forward", time.Date(2024, 3, 10, 20, 0, 0, 0, time.UTC), "2024-03-10T00:00:00-05:00", 23},
+		{"fall back", time.Date(2024, 11, 3, 20, 0, 0, 0, time.UTC), "2024-11-03T00:00:00-04:00", 25},
+		{"ordinary", time.Date(2024, 7, 15, 20, 0, 0, 0, time.UTC), "2024-07-15T00:00:00-04:00", 24},
+		// 02:00 UTC is still the previous evening in New York.
+		{"previous local day", time.Date(2024, 11, 4, 2, 0, 0, 0, time.UTC), "2024-11-03T00:00:00-04:00", 25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := StartOfDay(tt.t, loc)
+			if s := got.Format(time.RFC3339); s != tt.want {
+				t.Fatalf("StartOfDay(%v) = %s, want %s", tt.t, s, tt.want)
+			}
+			// The day's last instant still belongs to it, and the next
+			// begins tt.hours after it did.
+			end := got.Add(time.Duration(tt.hours) * time.Hour)
+			if last := StartOfDay(end.Add(-time.Nanosecond), loc); !last.Equal(got) {
+				t.Errorf("StartOfDay(%v) = %v, want %v", end.Add(-time.Nanosecond), last, got)
+			}
+			if next := StartOfDay(end, loc); !next.Equal(end) {
+				t.Errorf("StartOfDay(%v) = %v, want %v", end, next, end)
+			}
+		})
+	}
+}
+
+func TestDates(t *testing.T) {
+	loc := newYork(t)
+	tests := []struct {
+		name string
+		now  time.Time
+		want []string
+	}{
+		{
+			"spring forward",
+			time.Date(2024, 3, 8, 15, 0, 0, 0, loc),
+			[]string{"2024-03-08", "2024-03-09", "2024-03-10", "2024-03-11", "2024-03-12", "2024-03-13", "2024-03-14"},
+		},
+		{
+			"fall back",
+			time.Date(2024, 11, 1, 15, 0, 0, 0, loc),
+			[]string{"2024-11-01", "2024-11-02", "2024-11-03", "2024-11-04", "2024-11-05", "2024-11-06", "2024-11-07"},
+		},
+		{
+			"late evening before fall back",
+			time.Date(2024, 11, 2, 23, 30, 0, 0, loc),
+			[]string{"2024-11-02", "2024-11-03", "2024-11-04", "2024-11-05", "2024-11-06", "2024-11-07", "2024-11-08"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Dates(tt.now, loc)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Dates(%v) returned %d days, want %d", tt.now, len(got), len(tt.want))
+			}
+			for i, d := range got {
+				if s := Date(d, loc); s != tt.want[i] {
+					t.Errorf("Dates(%v)[%d] = %s, want %s", tt.now, i, s, tt.want[i])
+				}
+				if c := Clock(d, loc); c != "00:00" {
+					t.Errorf("Dates(%v)[%d] starts at %s, want midnight", tt.now, i, c)
+				}
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	loc := newYork(t)
+	now := time.Date(2024, 11, 1, 12, 0, 0, 0, loc)
+	slot := func(date, clock string) time.Time {
+		t.Helper()
+		s, err := ParseSlot(date, clock, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	tests := []struct {
+		name  string
+		start time.Time
+		want  error
+	}{
+		{"later today", slot("2024-11-01", "14:00"), nil},
+		{"across fall back", slot("2024-11-03", "09:00"), nil},
+		{"last day of window", slot("2024-11-07", "16:00"), nil},
+		{"already started", slot("2024-11-01", "11:00"), ErrInPast},
+		{"now", now, ErrInPast},
+		{"day after window", slot("2024-11-08", "09:00"), ErrOutsideWindow},
+		{"not a slot time", slot("2024-11-02", "12:00"), ErrNotASlot},
+		{"off the hour", slot("2024-11-02", "09:30"), ErrNotASlot},
+		{"seconds past slot", slot("2024-11-02", "09:00").Add(time.Second), ErrNotASlot},
+		{"slot time in another zone", time.Date(2024, 11, 2, 9, 0, 0, 0, time.UTC), ErrNotASlot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate(tt.start, now, loc); !errors.Is(err, tt.want) {
+				t.Errorf("Validate(%v) = %v, want %v", tt.start, err, tt.want)
+			}
+		})
+	}
+}
@@ -3,53 +3,107 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"shubam/auth"
+	"shubam/certs"
+	"shubam/config"
+	"shubam/logging"
+	"shubam/metrics"
+	"shubam/migrations"
 	pb "shubam/proto"
+	pbv2 "shubam/proto/v2"
+	"shubam/schedule"
+	"shubam/store"
+	"shubam/tracing"
 
-	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-var db *sql.DB
+// maxIdempotencyKeyLen bounds the idempotency keys clients may send.
+const maxIdempotencyKeyLen = 128
+
+// ListAppointments returns defaultPageSize appointments unless asked for
+// another page size, up to maxPageSize.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// dbCheckInterval is how often the database is pinged to keep the health
+// service's status current.
+const dbCheckInterval = 10 * time.Second
+
+// bookingService holds what both API versions need to book appointments.
+type bookingService struct {
+	appointments store.AppointmentStore
+	doctors      store.DoctorStore
+	location     *time.Location
+}
 
 type appointmentServer struct {
 	pb.UnimplementedHospitalServiceServer
+	*bookingService
 }
 
-func initDB() error {
-	err := godotenv.Load(".env")
-	if err != nil {
-		return fmt.Errorf("error loading .env file: %w", err)
-	}
-
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_NAME"))
+type appointmentServerV2 struct {
+	pbv2.UnimplementedHospitalServiceServer
+	*bookingService
+}
 
-	db, err = sql.Open("postgres", connStr)
+func initDB(cfg config.DBConfig) (*sql.DB, error) {
+	db, err := tracing.OpenDB(cfg.ConnString())
 	if err != nil {
-		return fmt.Errorf("error connecting to the database: %w", err)
+		return nil, fmt.Errorf("error connecting to the database: %w", err)
 	}
 
-	errPing := db.Ping()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+	defer cancel()
+	errPing := db.PingContext(ctx)
 	if errPing != nil {
-		return fmt.Errorf("error pinging the database: %w", errPing)
+		db.Close()
+		return nil, fmt.Errorf("error pinging the database: %w", errPing)
 	}
 
-	log.Println("Successfully connected to the database")
-	return nil
+	slog.Info("Connected to the database", "db", cfg.String())
+	return db, nil
 }
 
+// Appointment serves v1 clients, which send the slot as free-form date and
+// time strings. They are read as wall-clock time in the hospital time zone
+// and stored exactly like a v2 booking.
 func (s *appointmentServer) Appointment(ctx context.Context, req *pb.AppointmentRequest) (*pb.AppointmentResponse, error) {
-	err := s.saveAppointment(req)
+	start, err := parseLegacySlot(req.Date, req.Time, s.location)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid appointment time: %v", err)
+	}
+
+	patient, err := patientOf(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.book(ctx, req.DoctorName, patient, start, schedule.SlotDuration, "")
 	if err != nil {
 		return nil, err
 	}
@@ -57,33 +111,545 @@ func (s *appointmentServer) Appointment(ctx context.Context, req *pb.Appointment
 	return &pb.AppointmentResponse{Message: "Appointment scheduled successfully"}, nil
 }
 
-func (s *appointmentServer) saveAppointment(req *pb.AppointmentRequest) error {
-	_, err := db.Exec("INSERT INTO appointments (doctor_name, user_id, email, date, time, status) VALUES ($1, $2, $3, $4, $5, $6)",
-		req.DoctorName, req.UserId, req.Email, req.Date, req.Time, "BOOKED")
+// GetBookedSlots serves v1 clients the doctor's booked slots keyed by
+// hospital-local date ("2006-01-02"), with hospital-local start times
+// ("15:04") as values.
+func (s *appointmentServer) GetBookedSlots(ctx context.Context, req *pb.GetBookedSlotsRequest) (*pb.GetBookedSlotsResponse, error) {
+	if req.DoctorName == "" {
+		return nil, status.Error(codes.InvalidArgument, "doctor name is required")
+	}
+
+	booked, err := s.appointments.BookedSlots(ctx, req.DoctorName, time.Time{}, time.Time{})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error fetching booked slots", "error", err)
+		return nil, status.Error(codes.Internal, "failed to fetch booked slots")
+	}
+
+	resp := &pb.GetBookedSlotsResponse{Slots: make(map[string]*pb.TimeSlots)}
+	for _, a := range booked {
+		date := schedule.Date(a.StartsAt, s.location)
+		if resp.Slots[date] == nil {
+			resp.Slots[date] = &pb.TimeSlots{}
+		}
+		resp.Slots[date].Times = append(resp.Slots[date].Times, schedule.Clock(a.StartsAt, s.location))
+	}
+	return resp, nil
+}
+
+func (s *appointmentServerV2) Appointment(ctx context.Context, req *pbv2.AppointmentRequest) (*pbv2.AppointmentResponse, error) {
+	if err := req.GetStart().CheckValid(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid start: %v", err)
+	}
+
+	duration := schedule.SlotDuration
+	if req.Duration != nil {
+		if err := req.Duration.CheckValid(); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid duration: %v", err)
+		}
+		duration = req.Duration.AsDuration()
+		if duration <= 0 || duration%time.Minute != 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "duration must be a positive whole number of minutes, got %s", duration)
+		}
+	}
+
+	if req.TimeZone != "" {
+		if _, err := time.LoadLocation(req.TimeZone); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown time zone %q", req.TimeZone)
+		}
+	}
+
+	if len(req.IdempotencyKey) > maxIdempotencyKeyLen {
+		return nil, status.Errorf(codes.InvalidArgument, "idempotency key longer than %d bytes", maxIdempotencyKeyLen)
+	}
+
+	patient, err := patientOf(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := s.book(ctx, req.DoctorName, patient, req.Start.AsTime(), duration, req.IdempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pbv2.AppointmentResponse{Message: "Appointment scheduled successfully", Id: a.ID}, nil
+}
+
+func (s *appointmentServerV2) GetBookedSlots(ctx context.Context, req *pbv2.GetBookedSlotsRequest) (*pbv2.GetBookedSlotsResponse, error) {
+	if req.DoctorName == "" {
+		return nil, status.Error(codes.InvalidArgument, "doctor name is required")
+	}
+
+	var from, to time.Time
+	if req.From != nil {
+		from = req.From.AsTime()
+	}
+	if req.To != nil {
+		to = req.To.AsTime()
+	}
+
+	booked, err := s.appointments.BookedSlots(ctx, req.DoctorName, from, to)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error fetching booked slots", "error", err)
+		return nil, status.Error(codes.Internal, "failed to fetch booked slots")
+	}
+
+	resp := &pbv2.GetBookedSlotsResponse{TimeZone: s.location.String()}
+	for _, a := range booked {
+		resp.Slots = append(resp.Slots, &pbv2.Slot{
+			Start:    timestamppb.New(a.StartsAt),
+			Duration: durationpb.New(a.Duration),
+		})
+	}
+	return resp, nil
+}
+
+func (s *appointmentServerV2) ListDoctors(ctx context.Context, req *pbv2.ListDoctorsRequest) (*pbv2.ListDoctorsResponse, error) {
+	doctors, err := s.doctors.Doctors(ctx)
 	if err != nil {
-		log.Printf("Error inserting appointment into database: %v", err)
-		return err
+		slog.ErrorContext(ctx, "Error fetching doctors", "error", err)
+		return nil, status.Error(codes.Internal, "failed to fetch doctors")
 	}
 
-	log.Println("Appointment details saved successfully")
+	resp := &pbv2.ListDoctorsResponse{}
+	for _, d := range doctors {
+		resp.Doctors = append(resp.Doctors, &pbv2.Doctor{
+			Id:         d.ID,
+			Name:       d.Name,
+			Specialty:  d.Specialty,
+			Experience: d.Experience,
+		})
+	}
+	return resp, nil
+}
+
+// ListAppointments searches every patient's appointments, for operators.
+// The page token is the position of the previous page's last match.
+func (s *appointmentServerV2) ListAppointments(ctx context.Context, req *pbv2.ListAppointmentsRequest) (*pbv2.ListAppointmentsResponse, error) {
+	if err := requireOperator(ctx); err != nil {
+		return nil, err
+	}
+
+	f := store.AppointmentFilter{Email: req.Email, DoctorName: req.DoctorName}
+	if req.UserId != "" {
+		uid, err := strconv.ParseInt(req.UserId, 10, 64)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid user ID %q", req.UserId)
+		}
+		f.UserID = uid
+	}
+	if req.From != nil {
+		f.From = req.From.AsTime()
+	}
+	if req.To != nil {
+		f.To = req.To.AsTime()
+	}
+
+	size := int(req.PageSize)
+	switch {
+	case size < 0:
+		return nil, status.Error(codes.InvalidArgument, "page size must not be negative")
+	case size == 0:
+		size = defaultPageSize
+	case size > maxPageSize:
+		size = maxPageSize
+	}
+	if req.PageToken != "" {
+		after, err := store.ParseAppointmentCursor(req.PageToken)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		f.After = after
+	}
+	// One more than the page tells whether there is a next one.
+	f.Limit = size + 1
+
+	found, err := s.appointments.SearchAppointments(ctx, f)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error searching appointments", "error", err)
+		return nil, status.Error(codes.Internal, "failed to search appointments")
+	}
+
+	resp := &pbv2.ListAppointmentsResponse{TimeZone: s.location.String()}
+	if len(found) > size {
+		found = found[:size]
+		resp.NextPageToken = store.CursorOf(found[size-1]).String()
+	}
+	for _, a := range found {
+		resp.Appointments = append(resp.Appointments, appointmentDetails(a))
+	}
+	return resp, nil
+}
+
+// CancelAppointment cancels any patient's appointment, for operators.
+func (s *appointmentServerV2) CancelAppointment(ctx context.Context, req *pbv2.CancelAppointmentRequest) (*pbv2.AppointmentDetails, error) {
+	if err := requireOperator(ctx); err != nil {
+		return nil, err
+	}
+
+	a, err := s.appointments.DeleteAppointment(ctx, req.Id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "no appointment %d", req.Id)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error deleting appointment", "error", err)
+		return nil, status.Error(codes.Internal, "failed to cancel appointment")
+	}
+
+	slog.InfoContext(ctx, "Appointment cancelled by operator", "appointment_id", a.ID, "doctor", a.DoctorName)
+	return appointmentDetails(a), nil
+}
+
+// RescheduleAppointment moves any patient's appointment to another slot
+// a patient could book, for operators. Moving it to the slot it already
+// has changes nothing, so a retried call succeeds.
+func (s *appointmentServerV2) RescheduleAppointment(ctx context.Context, req *pbv2.RescheduleAppointmentRequest) (*pbv2.AppointmentDetails, error) {
+	if err := requireOperator(ctx); err != nil {
+		return nil, err
+	}
+	if err := req.GetStart().CheckValid(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid start: %v", err)
+	}
+	start := req.Start.AsTime()
+	if err := schedule.Validate(start, time.Now(), s.location); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid appointment time: %v", err)
+	}
+
+	a, err := s.appointments.RescheduleAppointment(ctx, req.Id, start)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return nil, status.Errorf(codes.NotFound, "no appointment %d", req.Id)
+	case errors.Is(err, store.ErrConflict):
+		return nil, status.Error(codes.AlreadyExists, "slot is already booked")
+	case err != nil:
+		slog.ErrorContext(ctx, "Error rescheduling appointment", "error", err)
+		return nil, status.Error(codes.Internal, "failed to reschedule appointment")
+	}
+
+	slog.InfoContext(ctx, "Appointment rescheduled by operator", "appointment_id", a.ID, "doctor", a.DoctorName)
+	return appointmentDetails(a), nil
+}
+
+func appointmentDetails(a store.Appointment) *pbv2.AppointmentDetails {
+	return &pbv2.AppointmentDetails{
+		Id:         a.ID,
+		DoctorName: a.DoctorName,
+		UserId:     strconv.FormatInt(a.UserID, 10),
+		Email:      a.Email,
+		Start:      timestamppb.New(a.StartsAt),
+		Duration:   durationpb.New(a.Duration),
+		Status:     a.Status,
+	}
+}
+
+// book validates and stores an appointment, translating failures into
+// gRPC status errors. A non-empty key makes it idempotent: if the patient
+// already booked with key, that appointment is returned unchanged.
+func (s *bookingService) book(ctx context.Context, doctorName string, patient auth.Identity, start time.Time, duration time.Duration, key string) (store.Appointment, error) {
+	uid, err := strconv.ParseInt(patient.UserID, 10, 64)
+	if err != nil {
+		return store.Appointment{}, status.Errorf(codes.InvalidArgument, "invalid user ID %q", patient.UserID)
+	}
+
+	// A retry may arrive after the slot's start has passed, so the key is
+	// checked before the time is.
+	if a, ok, err := s.replay(ctx, uid, key); ok || err != nil {
+		return a, err
+	}
+
+	if err := schedule.Validate(start, time.Now(), s.location); err != nil {
+		return store.Appointment{}, status.Errorf(codes.InvalidArgument, "invalid appointment time: %v", err)
+	}
+
+	_, err = s.doctors.DoctorByName(ctx, doctorName)
+	if errors.Is(err, store.ErrNotFound) {
+		return store.Appointment{}, status.Errorf(codes.InvalidArgument, "unknown doctor %q", doctorName)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error looking up doctor", "error", err)
+		return store.Appointment{}, status.Error(codes.Internal, "failed to save appointment")
+	}
+
+	a, err := s.appointments.CreateAppointment(ctx, store.Appointment{
+		DoctorName: doctorName,
+		UserID:     uid,
+		Email:      patient.Email,
+		StartsAt:   start,
+		Duration:   duration,
+
+		IdempotencyKey: key,
+	})
+	if errors.Is(err, store.ErrConflict) {
+		// The conflict may be a concurrent retry that won the race.
+		if a, ok, err := s.replay(ctx, uid, key); ok || err != nil {
+			return a, err
+		}
+		return store.Appointment{}, status.Error(codes.AlreadyExists, "slot is already booked")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error inserting appointment into database", "error", err)
+		return store.Appointment{}, status.Error(codes.Internal, "failed to save appointment")
+	}
+
+	slog.InfoContext(ctx, "Appointment saved", "appointment_id", a.ID, "doctor", a.DoctorName)
+	return a, nil
+}
+
+// patientOf returns the patient the authenticated call is made for. The
+// request's own user ID is legacy and only checked for agreement; the
+// caller cannot book for anyone but the patient in its token. Operators
+// act on no patient's behalf.
+func patientOf(ctx context.Context, requestUserID string) (auth.Identity, error) {
+	id, ok := auth.FromContext(ctx)
+	if !ok || id.UserID == "" || id.Operator {
+		return auth.Identity{}, status.Error(codes.PermissionDenied, "call is not made on behalf of a patient")
+	}
+	if requestUserID != "" && requestUserID != id.UserID {
+		return auth.Identity{}, status.Error(codes.PermissionDenied, "userId does not match the authenticated patient")
+	}
+	return id, nil
+}
+
+// requireOperator rejects calls other than those signed with the operator
+// key on no patient's behalf.
+func requireOperator(ctx context.Context) error {
+	id, ok := auth.FromContext(ctx)
+	if !ok || !id.Operator || id.UserID != "" {
+		return status.Error(codes.PermissionDenied, "method is reserved for operators")
+	}
 	return nil
 }
 
+// replay looks up the appointment the patient booked with key, reporting
+// whether there is one.
+func (s *bookingService) replay(ctx context.Context, userID int64, key string) (store.Appointment, bool, error) {
+	if key == "" {
+		return store.Appointment{}, false, nil
+	}
+	a, err := s.appointments.AppointmentByIdempotencyKey(ctx, userID, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return store.Appointment{}, false, nil
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error looking up idempotency key", "error", err)
+		return store.Appointment{}, false, status.Error(codes.Internal, "failed to save appointment")
+	}
+	slog.InfoContext(ctx, "Replaying booking for repeated idempotency key", "appointment_id", a.ID)
+	return a, true, nil
+}
+
+// parseLegacySlot turns the v1 date ("2006-01-02") and time ("15:04")
+// strings into an instant in loc. Older web tiers sometimes forwarded the
+// RFC3339 strings lib/pq produces for DATE and TIME columns, so those are
+// accepted too and only their date or clock part is used.
+func parseLegacySlot(date, clock string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, date); err == nil {
+		date = t.Format("2006-01-02")
+	}
+	if t, err := time.Parse(time.RFC3339, clock); err == nil {
+		clock = t.Format("15:04")
+	}
+	if len(clock) == len("15:04:05") {
+		clock = clock[:len("15:04")]
+	}
+
+	return schedule.ParseSlot(date, clock, loc)
+}
+
+// monitorHealth reports every service as SERVING while the database
+// answers pings, and NOT_SERVING while it does not, until ctx is done.
+func monitorHealth(ctx context.Context, db *sql.DB, hs *health.Server, services []string) {
+	ticker := time.NewTicker(dbCheckInterval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, dbCheckInterval/2)
+		err := db.PingContext(pingCtx)
+		cancel()
+
+		status := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		if status != last {
+			if err != nil {
+				slog.Error("Database unreachable, reporting NOT_SERVING", "error", err)
+			} else {
+				slog.Info("Database reachable, reporting SERVING")
+			}
+			for _, svc := range services {
+				hs.SetServingStatus(svc, status)
+			}
+			last = status
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func main() {
-	err := initDB()
+	cfg, args, err := config.Load(config.AppointmentServer, os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		logging.Fatal("Error loading configuration", "error", err)
+	}
+	if err := logging.Setup(os.Stderr, cfg.LogLevel); err != nil {
+		logging.Fatal("Error setting up logging", "error", err)
+	}
+	slog.Info("Effective configuration", "config", cfg)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "appointment-server", cfg.TraceExporter, cfg.TraceEndpoint)
+	if err != nil {
+		logging.Fatal("Error setting up tracing", "error", err)
+	}
+
+	db, err := initDB(cfg.DB)
+	if err != nil {
+		logging.Fatal("Error initializing database", "error", err)
+	}
+
+	if len(args) > 0 && args[0] == "migrate" {
+		err = migrations.Command(context.Background(), db, cfg.HospitalTimezone, os.Args[0], args[1:], os.Stdout)
+		if err != nil {
+			logging.Fatal("Error running migrations", "error", err)
+		}
+		return
+	}
+	if len(args) > 0 {
+		logging.Fatal("Unknown command", "command", args[0])
+	}
+	if cfg.DB.AutoMigrate {
+		err = migrations.Up(context.Background(), db, migrations.Options{Out: os.Stdout, TimeZone: cfg.HospitalTimezone})
+		if err != nil {
+			logging.Fatal("Error applying migrations", "error", err)
+		}
+	} else if err := migrations.Check(context.Background(), db); err != nil {
+		logging.Fatal("Error checking database schema", "error", err)
+	}
+
+	location, err := schedule.LoadLocation(cfg.HospitalTimezone)
 	if err != nil {
-		log.Fatalf("Error initializing database: %v", err)
+		logging.Fatal("Error initializing time zone", "error", err)
 	}
-	lis, err := net.Listen("tcp", ":5001") // Listening on port 5001
+	slog.Info("Using hospital time zone", "location", location.String())
+
+	lis, err := net.Listen("tcp", cfg.GRPCAddr)
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		logging.Fatal("Failed to listen", "error", err)
+	}
+
+	if err := metrics.RegisterDB(db, cfg.DB.Name); err != nil {
+		logging.Fatal("Error registering database metrics", "error", err)
 	}
 
-	s := grpc.NewServer()
-	pb.RegisterHospitalServiceServer(s, &appointmentServer{})
+	pg := store.NewPostgres(db)
+	booking := &bookingService{appointments: metrics.InstrumentAppointments(pg), doctors: pg, location: location}
+
+	keys := auth.Keys{Service: []byte(cfg.ServiceTokenKey), Operator: []byte(cfg.OperatorTokenKey)}
+	if cfg.OperatorTokenKey == "" {
+		slog.Warn("OPERATOR_TOKEN_KEY is not set; operator methods are disabled")
+	}
+
+	creds, err := certs.ServerCredentials(cfg.TLS, "appointment-server")
+	if err != nil {
+		logging.Fatal("Error setting up TLS", "error", err)
+	}
+
+	s := grpc.NewServer(
+		grpc.Creds(creds),
+		// Clients ping idle connections every 30s; allow that, and ping
+		// clients in turn so dead connections are cleaned up.
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             20 * time.Second,
+			PermitWithoutStream: true,
+		}),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    time.Minute,
+			Timeout: 10 * time.Second,
+		}),
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(
+			logging.UnaryServerInterceptor,
+			metrics.UnaryServerInterceptor,
+			// Health checks come from load balancers and probes that
+			// hold no token.
+			auth.UnaryServerInterceptor(keys, "appointment-server", healthpb.Health_ServiceDesc.ServiceName),
+		))
+	pb.RegisterHospitalServiceServer(s, &appointmentServer{bookingService: booking})
+	pbv2.RegisterHospitalServiceServer(s, &appointmentServerV2{bookingService: booking})
+	// Reflection lets grpcurl and similar tools discover the services. It
+	// only describes the APIs and, being a streaming service, is not
+	// checked by the token interceptor.
+	reflection.Register(s)
+
+	// The standard grpc.health.v1 service, with "" standing for the server
+	// as a whole.
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
 
-	log.Printf("Appointment gRPC server listening on port %v", lis.Addr())
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go monitorHealth(ctx, db, healthServer, []string{
+		"",
+		pb.HospitalService_ServiceDesc.ServiceName,
+		pbv2.HospitalService_ServiceDesc.ServiceName,
+	})
+
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", metrics.Handler())
+	metricsSrv := &http.Server{Addr: cfg.MetricsAddr, Handler: metricsMux, ReadHeaderTimeout: 10 * time.Second}
+
+	serveErr := make(chan error, 2)
+	go func() {
+		slog.Info("Appointment gRPC server listening", "addr", lis.Addr().String(), "tls", cfg.TLS.Mode)
+		serveErr <- s.Serve(lis)
+	}()
+	go func() {
+		slog.Info("Serving metrics", "addr", cfg.MetricsAddr)
+		serveErr <- metricsSrv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		logging.Fatal("Failed to serve", "error", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	// GracefulStop waits for running RPCs with no deadline of its own, so
+	// fall back to a hard Stop once the shutdown timeout has passed.
+	slog.Info("Shutting down, waiting for in-flight RPCs", "timeout", cfg.ShutdownTimeout)
+	healthServer.Shutdown()
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(cfg.ShutdownTimeout):
+		slog.Warn("Shutdown timeout exceeded, cancelling remaining RPCs")
+		s.Stop()
+	}
+	if err := metricsSrv.Close(); err != nil {
+		slog.Error("Error closing metrics server", "error", err)
+	}
+
+	if err := db.Close(); err != nil {
+		slog.Error("Error closing database", "error", err)
+	}
+	flushCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
 	}
+	slog.Info("Appointment server stopped")
 }
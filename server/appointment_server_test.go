@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"shubam/auth"
+	pb "shubam/proto"
+	pbv2 "shubam/proto/v2"
+	"shubam/schedule"
+	"shubam/store"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	testServiceKey  = []byte("test-service-token-key")
+	testOperatorKey = []byte("test-operator-token-key")
+)
+
+// newTestServer serves both API versions over an in-memory store, with
+// the token check of the real server, and returns a dialer for clients.
+func newTestServer(t *testing.T) (*store.Memory, func(t *testing.T, creds auth.Credentials) *grpc.ClientConn) {
+	t.Helper()
+	mem := store.NewMemory(store.Doctor{Name: "Dr. John Doe", Specialty: "Cardiology"})
+	booking := &bookingService{appointments: mem, doctors: mem, location: time.UTC}
+
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(auth.UnaryServerInterceptor(auth.Keys{Service: testServiceKey, Operator: testOperatorKey}, "appointment-server")))
+	pb.RegisterHospitalServiceServer(s, &appointmentServer{bookingService: booking})
+	pbv2.RegisterHospitalServiceServer(s, &appointmentServerV2{bookingService: booking})
+
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	dial := func(t *testing.T, creds auth.Credentials) *grpc.ClientConn {
+		t.Helper()
+		if creds.Audience == "" {
+			creds.Audience = "appointment-server"
+		}
+		conn, err := grpc.NewClient("passthrough:///bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithPerRPCCredentials(creds),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	return mem, dial
+}
+
+// tomorrow returns the start of a bookable slot tomorrow.
+func tomorrow(t *testing.T, clock string) time.Time {
+	t.Helper()
+	start, err := schedule.ParseSlot(schedule.Date(time.Now().Add(24*time.Hour), time.UTC), clock, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return start
+}
+
+func TestBooking(t *testing.T) {
+	mem, dial := newTestServer(t)
+	client := pbv2.NewHospitalServiceClient(dial(t, auth.Credentials{Key: testServiceKey, Service: "web"}))
+	ctx := auth.WithPatient(context.Background(), "7", "ann@example.com")
+	start := tomorrow(t, "09:00")
+
+	req := &pbv2.AppointmentRequest{DoctorName: "Dr. John Doe", Start: timestamppb.New(start), Duration: durationpb.New(time.Hour), IdempotencyKey: "k1"}
+	resp, err := client.Appointment(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := mem.AppointmentByIdempotencyKey(ctx, 7, "k1")
+	if err != nil || got.ID != resp.Id || got.Email != "ann@example.com" || !got.StartsAt.Equal(start) {
+		t.Fatalf("stored appointment = %+v, %v, want %d for the token's patient at %v", got, err, resp.Id, start)
+	}
+
+	// A retry with the same key returns the first booking.
+	again, err := client.Appointment(ctx, req)
+	if err != nil || again.Id != resp.Id {
+		t.Errorf("retried booking = %v, %v, want appointment %d", again, err, resp.Id)
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  *pbv2.AppointmentRequest
+		code codes.Code
+	}{
+		{"taken slot", auth.WithPatient(context.Background(), "8", "bob@example.com"), &pbv2.AppointmentRequest{DoctorName: "Dr. John Doe", Start: timestamppb.New(start)}, codes.AlreadyExists},
+		{"unknown doctor", ctx, &pbv2.AppointmentRequest{DoctorName: "Dr. Nobody", Start: timestamppb.New(start)}, codes.InvalidArgument},
+		{"not a slot", ctx, &pbv2.AppointmentRequest{DoctorName: "Dr. John Doe", Start: timestamppb.New(start.Add(time.Minute))}, codes.InvalidArgument},
+		{"past", ctx, &pbv2.AppointmentRequest{DoctorName: "Dr. John Doe", Start: timestamppb.New(start.AddDate(0, 0, -2))}, codes.InvalidArgument},
+		{"other patient's user ID", ctx, &pbv2.AppointmentRequest{DoctorName: "Dr. John Doe", UserId: "8", Start: timestamppb.New(tomorrow(t, "10:00"))}, codes.PermissionDenied},
+		{"no patient", context.Background(), &pbv2.AppointmentRequest{DoctorName: "Dr. John Doe", Start: timestamppb.New(tomorrow(t, "10:00"))}, codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		if _, err := client.Appointment(tt.ctx, tt.req); status.Code(err) != tt.code {
+			t.Errorf("%s: %v, want %s", tt.name, err, tt.code)
+		}
+	}
+	operator := pbv2.NewHospitalServiceClient(dial(t, auth.Credentials{Key: testOperatorKey, Service: auth.OperatorService}))
+	if _, err := operator.Appointment(ctx, &pbv2.AppointmentRequest{DoctorName: "Dr. John Doe", Start: timestamppb.New(tomorrow(t, "10:00"))}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("booking with the operator key: %v, want PermissionDenied", err)
+	}
+
+	slots, err := client.GetBookedSlots(ctx, &pbv2.GetBookedSlotsRequest{DoctorName: "Dr. John Doe"})
+	if err != nil || len(slots.Slots) != 1 || !slots.Slots[0].Start.AsTime().Equal(start) {
+		t.Errorf("GetBookedSlots = %v, %v, want the one booked slot", slots, err)
+	}
+
+	v1 := pb.NewHospitalServiceClient(dial(t, auth.Credentials{Key: testServiceKey, Service: "web"}))
+	v1Slots, err := v1.GetBookedSlots(ctx, &pb.GetBookedSlotsRequest{DoctorName: "Dr. John Doe"})
+	date := schedule.Date(start, time.UTC)
+	if err != nil || len(v1Slots.Slots[date].GetTimes()) != 1 || v1Slots.Slots[date].Times[0] != "09:00" {
+		t.Errorf("v1 GetBookedSlots = %v, %v, want 09:00 on %s", v1Slots, err, date)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	_, dial := newTestServer(t)
+	ctx := auth.WithPatient(context.Background(), "7", "ann@example.com")
+	req := &pbv2.GetBookedSlotsRequest{DoctorName: "Dr. John Doe"}
+
+	wrongKey := pbv2.NewHospitalServiceClient(dial(t, auth.Credentials{Key: []byte("another key"), Service: "web"}))
+	if _, err := wrongKey.GetBookedSlots(ctx, req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("token signed with another key: %v, want Unauthenticated", err)
+	}
+
+	wrongAudience := pbv2.NewHospitalServiceClient(dial(t, auth.Credentials{Key: testServiceKey, Service: "web", Audience: "pharmacy"}))
+	if _, err := wrongAudience.GetBookedSlots(ctx, req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("token for another audience: %v, want Unauthenticated", err)
+	}
+}
+
+func TestOperatorMethods(t *testing.T) {
+	mem, dial := newTestServer(t)
+	ann, err := mem.CreateUser(context.Background(), "ann@example.com", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := mem.CreateAppointment(context.Background(), store.Appointment{DoctorName: "Dr. John Doe", UserID: ann.ID, Email: ann.Email, StartsAt: tomorrow(t, "09:00"), Duration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Naming hospitalctl as the service does not make a call an operator
+	// call, and neither does acting for a patient with the operator key.
+	patient := auth.WithPatient(context.Background(), "1", "ann@example.com")
+	refused := []struct {
+		name  string
+		ctx   context.Context
+		creds auth.Credentials
+	}{
+		{"web tier", context.Background(), auth.Credentials{Key: testServiceKey, Service: "web"}},
+		{"web tier for a patient", patient, auth.Credentials{Key: testServiceKey, Service: "web"}},
+		{"service key claiming hospitalctl", context.Background(), auth.Credentials{Key: testServiceKey, Service: auth.OperatorService}},
+		{"operator key for a patient", patient, auth.Credentials{Key: testOperatorKey, Service: auth.OperatorService}},
+	}
+	for _, tt := range refused {
+		client := pbv2.NewHospitalServiceClient(dial(t, tt.creds))
+		if _, err := client.ListAppointments(tt.ctx, &pbv2.ListAppointmentsRequest{}); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("ListAppointments from %s: %v, want PermissionDenied", tt.name, err)
+		}
+		if _, err := client.CancelAppointment(tt.ctx, &pbv2.CancelAppointmentRequest{Id: a.ID}); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("CancelAppointment from %s: %v, want PermissionDenied", tt.name, err)
+		}
+	}
+
+	ctx := context.Background()
+	operator := pbv2.NewHospitalServiceClient(dial(t, auth.Credentials{Key: testOperatorKey, Service: auth.OperatorService}))
+	list, err := operator.ListAppointments(ctx, &pbv2.ListAppointmentsRequest{Email: "ANN@example.com"})
+	if err != nil || len(list.Appointments) != 1 || list.Appointments[0].Id != a.ID {
+		t.Fatalf("ListAppointments = %v, %v, want appointment %d", list, err, a.ID)
+	}
+
+	later := tomorrow(t, "10:00")
+	moved, err := operator.RescheduleAppointment(ctx, &pbv2.RescheduleAppointmentRequest{Id: a.ID, Start: timestamppb.New(later)})
+	if err != nil || !moved.Start.AsTime().Equal(later) {
+		t.Errorf("RescheduleAppointment = %v, %v, want the appointment at %v", moved, err, later)
+	}
+	if _, err := operator.RescheduleAppointment(ctx, &pbv2.RescheduleAppointmentRequest{Id: a.ID, Start: timestamppb.New(later.Add(time.Minute))}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("rescheduling off the slot grid: %v, want InvalidArgument", err)
+	}
+
+	if _, err := operator.CancelAppointment(ctx, &pbv2.CancelAppointmentRequest{Id: a.ID}); err != nil {
+		t.Errorf("CancelAppointment: %v", err)
+	}
+	if _, err := operator.CancelAppointment(ctx, &pbv2.CancelAppointmentRequest{Id: a.ID}); status.Code(err) != codes.NotFound {
+		t.Errorf("cancelling twice: %v, want NotFound", err)
+	}
+}
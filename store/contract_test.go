@@ -0,0 +1,712 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"shubam/migrations"
+	"shubam/store"
+
+	_ "github.com/lib/pq"
+)
+
+// The tests here run every store implementation through the same cases,
+// so the in-memory store used by the handler and server tests keeps
+// behaving like Postgres. Postgres is only tested when STORE_TEST_DSN
+// names a database the tests may empty, e.g.
+//
+//	STORE_TEST_DSN='postgres://postgres@localhost/hospital_test?sslmode=disable' go test ./store/
+//
+// Its tables are truncated before each case.
+
+// Store is every interface both implementations provide.
+type Store interface {
+	store.UserStore
+	store.AppointmentStore
+	store.DoctorStore
+	store.LoginAttemptStore
+	store.EmailVerificationStore
+	store.SessionStore
+	store.PasswordResetStore
+	store.TwoFactorStore
+	store.PrescriptionStore
+	store.PharmacyOrderStore
+}
+
+var doctors = []store.Doctor{
+	{Name: "Dr. John Doe", Specialty: "Cardiology"},
+	{Name: "Dr. Jane Doe", Specialty: "Neurology"},
+}
+
+// backends returns a constructor of an empty store, holding doctors, for
+// each implementation available.
+func backends(t *testing.T) map[string]func(t *testing.T) Store {
+	b := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return store.NewMemory(doctors...) },
+	}
+	if dsn := os.Getenv("STORE_TEST_DSN"); dsn != "" {
+		db := openPostgres(t, dsn)
+		b["postgres"] = func(t *testing.T) Store {
+			resetPostgres(t, db)
+			return store.NewPostgres(db)
+		}
+	}
+	return b
+}
+
+func openPostgres(t *testing.T, dsn string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrations.Up(context.Background(), db, migrations.Options{TimeZone: "UTC"}); err != nil {
+		t.Fatalf("error migrating test database: %v", err)
+	}
+	return db
+}
+
+func resetPostgres(t *testing.T, db *sql.DB) {
+	t.Helper()
+	ctx := context.Background()
+	_, err := db.ExecContext(ctx, `
+		TRUNCATE users, appointments, doctors, login_attempts, email_verifications,
+			sessions, password_resets, user_totp, recovery_codes, prescriptions, pharmacy_orders
+		RESTART IDENTITY CASCADE`)
+	if err != nil {
+		t.Fatalf("error emptying test database: %v", err)
+	}
+	for _, d := range doctors {
+		_, err := db.ExecContext(ctx, "INSERT INTO doctors (name, specialty) VALUES ($1, $2)", d.Name, d.Specialty)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// run runs test against a fresh store of each implementation.
+func run(t *testing.T, test func(t *testing.T, ctx context.Context, s Store)) {
+	for name, open := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			test(t, context.Background(), open(t))
+		})
+	}
+}
+
+// base is a whole second, which both implementations store exactly.
+var base = time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC)
+
+func createUser(t *testing.T, ctx context.Context, s Store, email string) store.User {
+	t.Helper()
+	u, err := s.CreateUser(ctx, email, "secret")
+	if err != nil {
+		t.Fatalf("CreateUser(%q): %v", email, err)
+	}
+	return u
+}
+
+func book(t *testing.T, ctx context.Context, s Store, u store.User, doctor string, start time.Time) store.Appointment {
+	t.Helper()
+	a, err := s.CreateAppointment(ctx, store.Appointment{DoctorName: doctor, UserID: u.ID, Email: u.Email, StartsAt: start, Duration: time.Hour})
+	if err != nil {
+		t.Fatalf("CreateAppointment(%s, %v): %v", doctor, start, err)
+	}
+	return a
+}
+
+func ids(appointments []store.Appointment) []int64 {
+	out := make([]int64, len(appointments))
+	for i, a := range appointments {
+		out[i] = a.ID
+	}
+	return out
+}
+
+func equalIDs(got []store.Appointment, want ...store.Appointment) bool {
+	g, w := ids(got), ids(want)
+	if len(g) != len(w) {
+		return false
+	}
+	for i := range g {
+		if g[i] != w[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestUsers(t *testing.T) {
+	run(t, func(t *testing.T, ctx context.Context, s Store) {
+		u := createUser(t, ctx, s, "ann@example.com")
+		if u.ID == 0 || u.Role != store.RolePatient || !u.VerifiedAt.IsZero() {
+			t.Errorf("CreateUser = %+v, want an unverified patient with an ID", u)
+		}
+		if _, err := s.CreateUser(ctx, "ann@example.com", "other"); !errors.Is(err, store.ErrConflict) {
+			t.Errorf("CreateUser of a registered email: %v, want ErrConflict", err)
+		}
+
+		got, err := s.UserByEmail(ctx, "ann@example.com")
+		if err != nil || got.ID != u.ID || got.PasswordHash != "secret" {
+			t.Errorf("UserByEmail = %+v, %v, want user %d", got, err, u.ID)
+		}
+		if _, err := s.UserByEmail(ctx, "bob@example.com"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("UserByEmail of an unknown email: %v, want ErrNotFound", err)
+		}
+
+		if err := s.SetRole(ctx, u.ID, store.RoleDoctor); err != nil {
+			t.Fatal(err)
+		}
+		got, err = s.UserByID(ctx, u.ID)
+		if err != nil || got.Role != store.RoleDoctor {
+			t.Errorf("UserByID after SetRole = %+v, %v, want role %s", got, err, store.RoleDoctor)
+		}
+		if err := s.SetRole(ctx, u.ID+1000, store.RoleDoctor); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("SetRole of an unknown user: %v, want ErrNotFound", err)
+		}
+
+		if err := s.RehashPassword(ctx, u.ID, "secret", "new hash"); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := s.UserByID(ctx, u.ID); err != nil || got.PasswordHash != "new hash" {
+			t.Errorf("UserByID after RehashPassword = %+v, %v, want the new hash", got, err)
+		}
+		if err := s.RehashPassword(ctx, u.ID, "secret", "newer hash"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("RehashPassword of a changed password: %v, want ErrNotFound", err)
+		}
+		if err := s.RehashPassword(ctx, u.ID+1000, "secret", "new hash"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("RehashPassword of an unknown user: %v, want ErrNotFound", err)
+		}
+		if _, err := s.UserByID(ctx, u.ID+1000); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("UserByID of an unknown user: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestDoctors(t *testing.T) {
+	run(t, func(t *testing.T, ctx context.Context, s Store) {
+		got, err := s.Doctors(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(doctors) || got[0].Name != doctors[0].Name || got[1].Name != doctors[1].Name {
+			t.Errorf("Doctors = %+v, want %+v in order", got, doctors)
+		}
+		d, err := s.DoctorByName(ctx, "Dr. Jane Doe")
+		if err != nil || d.Specialty != "Neurology" || d.ID == 0 {
+			t.Errorf("DoctorByName = %+v, %v", d, err)
+		}
+		if _, err := s.DoctorByName(ctx, "Dr. Nobody"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("DoctorByName of an unknown doctor: %v, want ErrNotFound", err)
+		}
+
+		page, err := s.DoctorsAfter(ctx, 0, 1)
+		if err != nil || len(page) != 1 || page[0].ID != got[0].ID {
+			t.Fatalf("DoctorsAfter(0, 1) = %+v, %v, want the first doctor", page, err)
+		}
+		page, err = s.DoctorsAfter(ctx, page[0].ID, 10)
+		if err != nil || len(page) != 1 || page[0].ID != got[1].ID {
+			t.Errorf("DoctorsAfter(first, 10) = %+v, %v, want the second doctor", page, err)
+		}
+	})
+}
+
+func TestBooking(t *testing.T) {
+	run(t, func(t *testing.T, ctx context.Context, s Store) {
+		ann := createUser(t, ctx, s, "ann@example.com")
+		bob := createUser(t, ctx, s, "bob@example.com")
+
+		a := book(t, ctx, s, ann, "Dr. John Doe", base)
+		if a.ID == 0 || a.Status != store.StatusBooked {
+			t.Errorf("CreateAppointment = %+v, want a booked appointment with an ID", a)
+		}
+		if _, err := s.CreateAppointment(ctx, store.Appointment{DoctorName: "Dr. John Doe", UserID: bob.ID, Email: bob.Email, StartsAt: base, Duration: time.Hour}); !errors.Is(err, store.ErrConflict) {
+			t.Errorf("booking a taken slot: %v, want ErrConflict", err)
+		}
+		// The same time with another doctor, and a slot freed by a
+		// cancelled appointment, are free.
+		book(t, ctx, s, bob, "Dr. Jane Doe", base)
+		cancelled := store.Appointment{DoctorName: "Dr. Jane Doe", UserID: ann.ID, Email: ann.Email, StartsAt: base.Add(time.Hour), Duration: time.Hour, Status: "CANCELLED"}
+		if _, err := s.CreateAppointment(ctx, cancelled); err != nil {
+			t.Fatal(err)
+		}
+		book(t, ctx, s, bob, "Dr. Jane Doe", base.Add(time.Hour))
+
+		got, err := s.DeleteAppointment(ctx, a.ID)
+		if err != nil || got.ID != a.ID || !got.StartsAt.Equal(base) || got.Duration != time.Hour {
+			t.Errorf("DeleteAppointment = %+v, %v, want %+v", got, err, a)
+		}
+		if _, err := s.DeleteAppointment(ctx, a.ID); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("deleting twice: %v, want ErrNotFound", err)
+		}
+		book(t, ctx, s, bob, "Dr. John Doe", base)
+	})
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	run(t, func(t *testing.T, ctx context.Context, s Store) {
+		ann := createUser(t, ctx, s, "ann@example.com")
+		bob := createUser(t, ctx, s, "bob@example.com")
+
+		a, err := s.CreateAppointment(ctx, store.Appointment{DoctorName: "Dr. John Doe", UserID: ann.ID, Email: ann.Email, StartsAt: base, Duration: time.Hour, IdempotencyKey: "k1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		retry := store.Appointment{DoctorName: "Dr. John Doe", UserID: ann.ID, Email: ann.Email, StartsAt: base.Add(time.Hour), Duration: time.Hour, IdempotencyKey: "k1"}
+		if _, err := s.CreateAppointment(ctx, retry); !errors.Is(err, store.ErrConflict) {
+			t.Errorf("reusing an idempotency key: %v, want ErrConflict", err)
+		}
+		// Keys are per patient.
+		other := store.Appointment{DoctorName: "Dr. John Doe", UserID: bob.ID, Email: bob.Email, StartsAt: base.Add(time.Hour), Duration: time.Hour, IdempotencyKey: "k1"}
+		if _, err := s.CreateAppointment(ctx, other); err != nil {
+			t.Errorf("another patient's key: %v", err)
+		}
+
+		got, err := s.AppointmentByIdempotencyKey(ctx, ann.ID, "k1")
+		if err != nil || got.ID != a.ID || got.IdempotencyKey != "k1" {
+			t.Errorf("AppointmentByIdempotencyKey = %+v, %v, want %d", got, err, a.ID)
+		}
+		if _, err := s.AppointmentByIdempotencyKey(ctx, ann.ID, "k2"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("unknown key: %v, want ErrNotFound", err)
+		}
+		if _, err := s.AppointmentByIdempotencyKey(ctx, ann.ID, ""); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("empty key: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestListingAppointments(t *testing.T) {
+	run(t, func(t *testing.T, ctx context.Context, s Store) {
+		ann := createUser(t, ctx, s, "Ann@example.com")
+		bob := createUser(t, ctx, s, "bob@example.com")
+
+		// Booked out of order, to check the ordering.
+		a3 := book(t, ctx, s, ann, "Dr. John Doe", base.Add(48*time.Hour))
+		a1 := book(t, ctx, s, ann, "Dr. John Doe", base)
+		b1 := book(t, ctx, s, bob, "Dr. Jane Doe", base)
+		a2 := book(t, ctx, s, ann, "Dr. Jane Doe", base.Add(24*time.Hour))
+
+		upcoming, err := s.UpcomingAppointments(ctx, ann.ID, base.Add(time.Hour))
+		if err != nil || !equalIDs(upcoming, a2, a3) {
+			t.Errorf("UpcomingAppointments = %v, %v, want %v", ids(upcoming), err, ids([]store.Appointment{a2, a3}))
+		}
+
+		slots, err := s.BookedSlots(ctx, "Dr. John Doe", base, base.Add(48*time.Hour))
+		if err != nil || !equalIDs(slots, a1) {
+			t.Errorf("BookedSlots in [from, to) = %v, %v, want %v", ids(slots), err, ids([]store.Appointment{a1}))
+		}
+		slots, err = s.BookedSlots(ctx, "Dr. John Doe", time.Time{}, time.Time{})
+		if err != nil || !equalIDs(slots, a1, a3) {
+			t.Errorf("BookedSlots unbounded = %v, %v, want %v", ids(slots), err, ids([]store.Appointment{a1, a3}))
+		}
+
+		tests := []struct {
+			name   string
+			filter store.AppointmentFilter
+			want   []store.Appointment
+		}{
+			{"all", store.AppointmentFilter{}, []store.Appointment{a1, b1, a2, a3}},
+			{"user", store.AppointmentFilter{UserID: ann.ID}, []store.Appointment{a1, a2, a3}},
+			{"email ignores case", store.AppointmentFilter{Email: "ANN@example.com"}, []store.Appointment{a1, a2, a3}},
+			{"doctor", store.AppointmentFilter{DoctorName: "Dr. Jane Doe"}, []store.Appointment{b1, a2}},
+			{"range", store.AppointmentFilter{From: base.Add(time.Minute), To: base.Add(48 * time.Hour)}, []store.Appointment{a2}},
+			// a1 and b1 start together, so only their IDs order them.
+			{"after and limit", store.AppointmentFilter{After: store.CursorOf(a1), Limit: 2}, []store.Appointment{b1, a2}},
+			{"after a tie", store.AppointmentFilter{After: store.CursorOf(b1)}, []store.Appointment{a2, a3}},
+			{"after the end", store.AppointmentFilter{After: store.CursorOf(a3)}, nil},
+			{"no match", store.AppointmentFilter{UserID: bob.ID, DoctorName: "Dr. John Doe"}, nil},
+		}
+		for _, tt := range tests {
+			got, err := s.SearchAppointments(ctx, tt.filter)
+			if err != nil || !equalIDs(got, tt.want...) {
+				t.Errorf("SearchAppointments(%s) = %v, %v, want %v", tt.name, ids(got), err, ids(tt.want))
+			}
+		}
+	})
+}
+
+func TestRescheduleAppointment(t *testing.T) {
+	run(t, func(t *testing.T, ctx context.Context, s Store) {
+		ann := createUser(t, ctx, s, "ann@example.com")
+		a := book(t, ctx, s, ann, "Dr. John Doe", base)
+		b := book(t, ctx, s, ann, "Dr. John Doe", base.Add(time.Hour))
+
+		if _, err := s.RescheduleAppointment(ctx, a.ID, b.StartsAt); !errors.Is(err, store.ErrConflict) {
+			t.Errorf("moving onto a booked slot: %v, want ErrConflict", err)
+		}
+		if _, err := s.RescheduleAppointment(ctx, a.ID+1000, base); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("moving an unknown appointment: %v, want ErrNotFound", err)
+		}
+		// Moving onto its own slot is allowed.
+		if _, err := s.RescheduleAppointment(ctx, a.ID, base); err != nil {
+			t.Errorf("moving onto its own slot: %v", err)
+		}
+
+		later := base.Add(2 * time.Hour)
+		got, err := s.RescheduleAppointment(ctx, a.ID, later)
+		if err != nil || got.ID != a.ID || !got.StartsAt.Equal(later) || got.DoctorName != a.DoctorName {
+			t.Errorf("RescheduleAppointment = %+v, %v, want %d at %v", got, err, a.ID, later)
+		}
+		slots, err := s.BookedSlots(ctx, "Dr. John Doe", time.Time{}, time.Time{})
+		if err != nil || !equalIDs(slots, b, a) {
+			t.Errorf("BookedSlots after moving = %v, %v, want %v", ids(slots), err, ids([]store.Appointment{b, a}))
+		}
+	})
+}
+
+func TestLoginFailures(t *testing.T) {
+	run(t, func(t *testing.T, ctx context.Context, s Store) {
+		const ip, otherIP = "192.0.2.1", "192.0.2.2"
+		attempts := []store.LoginAttempt{
+			{Email: "ann@example.com", IP: ip, AttemptedAt: base.Add(-2 * time.Hour)}, // too old
+			{Email: "ann@example.com", IP: ip, AttemptedAt: base.Add(1 * time.Minute)},
+			{Email: "ann@example.com", IP: ip, Success: true, AttemptedAt: base.Add(2 * time.Minute)},
+			{Email: "ann@example.com", IP: otherIP, AttemptedAt: base.Add(3 * time.Minute)},
+			{Email: "bob@example.com", IP: ip, AttemptedAt: base.Add(4 * time.Minute)},
+		}
+		for _, a := range attempts {
+			if err := s.RecordLoginAttempt(ctx, a); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := s.LoginFailures(ctx, "ann@example.com", ip, base.Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := store.LoginFailures{Account: 1, LastAccount: base.Add(3 * time.Minute), IP: 2, LastIP: base.Add(4 * time.Minute)}
+		if got.Account != want.Account || !got.LastAccount.Equal(want.LastAccount) || got.IP != want.IP || !got.LastIP.Equal(want.LastIP) {
+			t.Errorf("LoginFailures = %+v, want %+v", got, want)
+		}
+
+		got, err = s.LoginFailures(ctx, "carol@example.com", "192.0.2.3", base.Add(-time.Hour))
+		if err != nil || got.Account != 0 || got.IP != 0 || !got.LastAccount.IsZero() || !got.LastIP.IsZero() {
+			t.Errorf("LoginFailures with no attempts = %+v, %v, want zero", got, err)
+		}
+	})
+}
+
+func TestEmailVerification(t *testing.T) {
+	run(t, func(t *testing.T, ctx context.Context, s Store) {
+		u := createUser(t, ctx, s, "ann@example.com")
+		for i, h := range []string{"old", "expired", "good"} {
+			v := store.EmailVerification{UserID: u.ID, TokenHash: []byte(h), CreatedAt: base.Add(time.Duration(i) * time.Minute), ExpiresAt: base.Add(time.Hour)}
+			if h == "expired" {
+				v.ExpiresAt = base.Add(10 * time.Minute)
+			}
+			if err := s.CreateEmailVerification(ctx, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.CreateEmailVerification(ctx, store.EmailVerification{UserID: u.ID, TokenHash: []byte("good"), ExpiresAt: base.Add(time.Hour)}); !errors.Is(err, store.ErrConflict) {
+			t.Errorf("reusing a token hash: %v, want ErrConflict", err)
+		}
+
+		n, last, err := s.EmailVerificationsSince(ctx, u.ID, base.Add(time.Minute))
+		if err != nil || n != 2 || !last.Equal(base.Add(2*time.Minute)) {
+			t.Errorf("EmailVerificationsSince = %d, %v, %v, want 2 ending %v", n, last, err, base.Add(2*time.Minute))
+		}
+
+		now := base.Add(20 * time.Minute)
+		if _, err := s.UseEmailVerification(ctx, u.ID, []byte("expired"), now); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("using an expired link: %v, want ErrNotFound", err)
+		}
+		got, err := s.UseEmailVerification(ctx, u.ID, []byte("good"), now)
+		if err != nil || got.ID != u.ID || !got.VerifiedAt.Equal(now) {
+			t.Errorf("UseEmailVerification = %+v, %v, want user %d verified at %v", got, err, u.ID, now)
+		}
+		if _, err := s.UseEmailVerification(ctx, u.ID, []byte("good"), now); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("using a link twice: %v, want ErrNotFound", err)
+		}
+		// A later link keeps the first verification time.
+		got, err = s.UseEmailVerification(ctx, u.ID, []byte("old"), now.Add(time.Minute))
+		if err != nil || !got.VerifiedAt.Equal(now) {
+			t.Errorf("using a second link = %+v, %v, want verified at %v", got, err, now)
+		}
+	})
+}
+
+func TestSessions(t *testing.T) {
+	run(t, func(t *testing.T, ctx context.Context, s Store) {
+		ann := createUser(t, ctx, s, "ann@example.com")
+		bob := createUser(t, ctx, s, "bob@example.com")
+		for _, sess := range []store.Session{
+			{TokenHash: []byte("ann1"), UserID: ann.ID, ExpiresAt: base.Add(time.Hour)},
+			{TokenHash: []byte("ann2"), UserID: ann.ID, ExpiresAt: base.Add(time.Hour)},
+			{TokenHash: []byte("bob"), UserID: bob.ID, ExpiresAt: base.Add(time.Hour)},
+		} {
+			if err := s.CreateSession(ctx, sess); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.CreateSession(ctx, store.Session{TokenHash: []byte("bob"), UserID: ann.ID, ExpiresAt: base.Add(time.Hour)}); !errors.Is(err, store.ErrConflict) {
+			t.Errorf("reusing a token hash: %v, want ErrConflict", err)
+		}
+
+		got, err := s.SessionByTokenHash(ctx, []byte("ann1"), base)
+		if err != nil || got.UserID != ann.ID || got.Email != ann.Email || got.CreatedAt.IsZero() {
+			t.Errorf("SessionByTokenHash = %+v, %v, want ann's session", got, err)
+		}
+		if _, err := s.SessionByTokenHash(ctx, []byte("ann1"), base.Add(time.Hour)); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expired session: %v, want ErrNotFound", err)
+		}
+
+		if err := s.DeleteSession(ctx, []byte("ann1")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.SessionByTokenHash(ctx, []byte("ann1"), base); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("deleted session: %v, want ErrNotFound", err)
+		}
+		if err := s.DeleteUserSessions(ctx, ann.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.SessionByTokenHash(ctx, []byte("ann2"), base); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("session of a user logged out everywhere: %v, want ErrNotFound", err)
+		}
+		if _, err := s.SessionByTokenHash(ctx, []byte("bob"), base); err != nil {
+			t.Errorf("another user's session: %v", err)
+		}
+	})
+}
+
+func TestPasswordReset(t *testing.T) {
+	run(t, func(t *testing.T, ctx context.Context, s Store) {
+		u := createUser(t, ctx, s, "ann@example.com")
+		for _, h := range []string{"r1", "r2"} {
+			if err := s.CreatePasswordReset(ctx, store.PasswordReset{UserID: u.ID, TokenHash: []byte(h), CreatedAt: base, ExpiresAt: base.Add(time.Hour)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.CreateSession(ctx, store.Session{TokenHash: []byte("sess"), UserID: u.ID, ExpiresAt: base.Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+
+		n, last, err := s.PasswordResetsSince(ctx, u.ID, base)
+		if err != nil || n != 2 || !last.Equal(base) {
+			t.Errorf("PasswordResetsSince = %d, %v, %v, want 2 at %v", n, last, err, base)
+		}
+
+		now := base.Add(time.Minute)
+		if _, err := s.ResetPassword(ctx, []byte("r1"), "new", base.Add(time.Hour)); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expired reset: %v, want ErrNotFound", err)
+		}
+		got, err := s.ResetPassword(ctx, []byte("r1"), "new", now)
+		if err != nil || got.ID != u.ID || !got.VerifiedAt.Equal(now) {
+			t.Errorf("ResetPassword = %+v, %v, want user %d verified at %v", got, err, u.ID, now)
+		}
+		if u, err := s.UserByID(ctx, u.ID); err != nil || u.PasswordHash != "new" {
+			t.Errorf("password hash after reset = %q, %v, want %q", u.PasswordHash, err, "new")
+		}
+		if _, err := s.SessionByTokenHash(ctx, []byte("sess"), base); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("session after reset: %v, want ErrNotFound", err)
+		}
+		// The reset used up the user's other link too.
+		if _, err := s.ResetPassword(ctx, []byte("r2"), "newer", now); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("second reset: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestTwoFactor(t *testing.T) {
+	run(t, func(t *testing.T, ctx context.Context, s Store) {
+		u := createUser(t, ctx, s, "ann@example.com")
+		if _, err := s.TOTP(ctx, u.ID); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("TOTP before enrolment: %v, want ErrNotFound", err)
+		}
+		if err := s.EnableTOTP(ctx, u.ID, 1, nil, base); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("EnableTOTP with nothing pending: %v, want ErrNotFound", err)
+		}
+
+		if err := s.StartTOTP(ctx, u.ID, "first"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.StartTOTP(ctx, u.ID, "second"); err != nil {
+			t.Fatalf("restarting a pending enrolment: %v", err)
+		}
+		if err := s.UseTOTPStep(ctx, u.ID, 5); !errors.Is(err, store.ErrConflict) {
+			t.Errorf("UseTOTPStep while pending: %v, want ErrConflict", err)
+		}
+		if err := s.EnableTOTP(ctx, u.ID, 10, [][]byte{[]byte("c1"), []byte("c2")}, base); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.TOTP(ctx, u.ID)
+		if err != nil || got.Secret != "second" || !got.EnabledAt.Equal(base) || got.LastStep != 10 {
+			t.Errorf("TOTP = %+v, %v, want the second secret enabled at step 10", got, err)
+		}
+		if err := s.StartTOTP(ctx, u.ID, "third"); !errors.Is(err, store.ErrConflict) {
+			t.Errorf("StartTOTP while enabled: %v, want ErrConflict", err)
+		}
+
+		if err := s.UseTOTPStep(ctx, u.ID, 10); !errors.Is(err, store.ErrConflict) {
+			t.Errorf("reusing a step: %v, want ErrConflict", err)
+		}
+		if err := s.UseTOTPStep(ctx, u.ID, 11); err != nil {
+			t.Errorf("UseTOTPStep of a later step: %v", err)
+		}
+
+		if err := s.UseRecoveryCode(ctx, u.ID, []byte("c1"), base); err != nil {
+			t.Errorf("UseRecoveryCode: %v", err)
+		}
+		if err := s.UseRecoveryCode(ctx, u.ID, []byte("c1"), base); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("reusing a recovery code: %v, want ErrNotFound", err)
+		}
+
+		if err := s.DisableTOTP(ctx, u.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.TOTP(ctx, u.ID); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("TOTP after disabling: %v, want ErrNotFound", err)
+		}
+		if err := s.UseRecoveryCode(ctx, u.ID, []byte("c2"), base); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("recovery code after disabling: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestPrescriptionsAndOrders(t *testing.T) {
+	run(t, func(t *testing.T, ctx context.Context, s Store) {
+		doctor := createUser(t, ctx, s, "house@example.com")
+		ann := createUser(t, ctx, s, "ann@example.com")
+		bob := createUser(t, ctx, s, "bob@example.com")
+		prescribe := func(u store.User, medicine string) store.Prescription {
+			t.Helper()
+			rx, err := s.CreatePrescription(ctx, store.Prescription{UserID: u.ID, PrescriberID: doctor.ID, PrescribedBy: doctor.Email, Medicine: medicine, Dosage: "1 daily", Quantity: 30, CreatedAt: base})
+			if err != nil {
+				t.Fatalf("CreatePrescription(%s): %v", medicine, err)
+			}
+			return rx
+		}
+		rx1 := prescribe(ann, "Tylenol")
+		prescribe(bob, "Advil")
+		rx2 := prescribe(ann, "Advil")
+
+		got, err := s.PrescriptionByID(ctx, rx1.ID)
+		if err != nil || got.UserID != ann.ID || got.PrescribedBy != doctor.Email || got.Medicine != "Tylenol" || got.Quantity != 30 || !got.CreatedAt.Equal(base) {
+			t.Errorf("PrescriptionByID = %+v, %v, want %+v", got, err, rx1)
+		}
+		if _, err := s.PrescriptionByID(ctx, rx1.ID+1000); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("PrescriptionByID of an unknown prescription: %v, want ErrNotFound", err)
+		}
+		list, err := s.Prescriptions(ctx, ann.ID, 0, 10)
+		if err != nil || len(list) != 2 || list[0].ID != rx2.ID || list[1].ID != rx1.ID {
+			t.Errorf("Prescriptions = %+v, %v, want ann's, newest first", list, err)
+		}
+		list, err = s.Prescriptions(ctx, ann.ID, rx2.ID, 10)
+		if err != nil || len(list) != 1 || list[0].ID != rx1.ID {
+			t.Errorf("Prescriptions before the newest = %+v, %v, want the first", list, err)
+		}
+
+		o, err := s.CreatePharmacyOrder(ctx, store.PharmacyOrder{UserID: ann.ID, PrescriptionID: rx1.ID})
+		if err != nil || o.ID == 0 || o.Status != store.OrderPending {
+			t.Fatalf("CreatePharmacyOrder = %+v, %v, want a pending order", o, err)
+		}
+		if _, err := s.CreatePharmacyOrder(ctx, store.PharmacyOrder{UserID: ann.ID, PrescriptionID: rx1.ID}); !errors.Is(err, store.ErrConflict) {
+			t.Errorf("ordering a prescription twice: %v, want ErrConflict", err)
+		}
+		placed, err := s.PlacePharmacyOrder(ctx, o.ID, "PH-1")
+		if err != nil || placed.Status != store.OrderPlaced || placed.Reference != "PH-1" {
+			t.Errorf("PlacePharmacyOrder = %+v, %v, want it placed as PH-1", placed, err)
+		}
+		if _, err := s.PlacePharmacyOrder(ctx, o.ID+1000, "PH-2"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("placing an unknown order: %v, want ErrNotFound", err)
+		}
+		byRx, err := s.PharmacyOrderByPrescription(ctx, rx1.ID)
+		if err != nil || byRx.ID != o.ID || byRx.Status != store.OrderPlaced {
+			t.Errorf("PharmacyOrderByPrescription = %+v, %v, want the placed order", byRx, err)
+		}
+		if _, err := s.PharmacyOrderByPrescription(ctx, rx2.ID); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("order of an unordered prescription: %v, want ErrNotFound", err)
+		}
+
+		o2, err := s.CreatePharmacyOrder(ctx, store.PharmacyOrder{UserID: ann.ID, PrescriptionID: rx2.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		orders, err := s.PharmacyOrders(ctx, ann.ID, 0, 1)
+		if err != nil || len(orders) != 1 || orders[0].ID != o2.ID {
+			t.Errorf("PharmacyOrders(limit 1) = %+v, %v, want the newest", orders, err)
+		}
+		orders, err = s.PharmacyOrders(ctx, ann.ID, o2.ID, 10)
+		if err != nil || len(orders) != 1 || orders[0].ID != o.ID {
+			t.Errorf("PharmacyOrders before the newest = %+v, %v, want the first", orders, err)
+		}
+		if orders, err := s.PharmacyOrders(ctx, bob.ID, 0, 10); err != nil || len(orders) != 0 {
+			t.Errorf("bob's PharmacyOrders = %+v, %v, want none", orders, err)
+		}
+	})
+}
+
+// TestAdoptedBaselineSchema migrates the tables the app created by hand
+// before migrations existed, in a schema of their own, and checks the
+// store works on the result.
+func TestAdoptedBaselineSchema(t *testing.T) {
+	dsn := os.Getenv("STORE_TEST_DSN")
+	if dsn == "" {
+		t.Skip("STORE_TEST_DSN is not set")
+	}
+	ctx := context.Background()
+	admin := openRaw(t, dsn)
+	if _, err := admin.ExecContext(ctx, "DROP SCHEMA IF EXISTS baseline CASCADE; CREATE SCHEMA baseline"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.ExecContext(ctx, "DROP SCHEMA IF EXISTS baseline CASCADE") })
+
+	db := openRaw(t, withSearchPath(t, dsn, "baseline"))
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE users (id SERIAL PRIMARY KEY, email TEXT UNIQUE, password TEXT);
+		CREATE TABLE appointments (id SERIAL PRIMARY KEY, doctor_name TEXT, user_id INTEGER, email TEXT, date DATE, time TIME, status TEXT);
+		INSERT INTO users (email, password) VALUES ('ann@example.com', 'secret');
+		INSERT INTO appointments (doctor_name, user_id, email, date, time, status)
+			VALUES ('Dr. John Doe', 1, 'ann@example.com', '2030-06-03', '09:00', 'BOOKED')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrations.Up(ctx, db, migrations.Options{TimeZone: "UTC"}); err != nil {
+		t.Fatalf("migrating the baseline schema: %v", err)
+	}
+
+	s := store.NewPostgres(db)
+	ann, err := s.UserByEmail(ctx, "ann@example.com")
+	if err != nil || ann.CreatedAt.IsZero() {
+		t.Fatalf("UserByEmail of a baseline user = %+v, %v, want the user with a creation time", ann, err)
+	}
+	if _, err := s.CreateUser(ctx, "bob@example.com", "secret"); err != nil {
+		t.Errorf("CreateUser after adopting the baseline: %v", err)
+	}
+	got, err := s.SearchAppointments(ctx, store.AppointmentFilter{UserID: ann.ID})
+	if err != nil || len(got) != 1 || !got[0].StartsAt.Equal(base) {
+		t.Errorf("baseline appointments = %+v, %v, want one at %v", got, err, base)
+	}
+}
+
+func openRaw(t *testing.T, dsn string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// withSearchPath returns dsn with its connections using schema.
+func withSearchPath(t *testing.T, dsn, schema string) string {
+	t.Helper()
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsn + " search_path=" + schema
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,652 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory implements every store interface in process memory. It applies
+// the same uniqueness rules as the Postgres schema, so handlers and servers
+// can be exercised without a database.
+type Memory struct {
+	mu            sync.Mutex
+	nextID        int64
+	users         []User
+	appointments  []Appointment
+	doctors       []Doctor
+	logins        []LoginAttempt
+	verifications []memoryVerification
+	sessions      []Session
+	resets        []memoryReset
+	totp          map[int64]TOTP
+	recovery      []memoryRecoveryCode
+	prescriptions []Prescription
+	orders        []PharmacyOrder
+}
+
+// NewMemory returns an empty store holding the given doctors.
+func NewMemory(doctors ...Doctor) *Memory {
+	m := &Memory{}
+	for _, d := range doctors {
+		d.ID = m.id()
+		m.doctors = append(m.doctors, d)
+	}
+	return m
+}
+
+func (m *Memory) id() int64 {
+	m.nextID++
+	return m.nextID
+}
+
+func (m *Memory) CreateUser(ctx context.Context, email, passwordHash string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Email == email {
+			return User{}, ErrConflict
+		}
+	}
+	u := User{ID: m.id(), Email: email, PasswordHash: passwordHash, CreatedAt: time.Now(), Role: RolePatient}
+	m.users = append(m.users, u)
+	return u, nil
+}
+
+func (m *Memory) UserByEmail(ctx context.Context, email string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (m *Memory) UserByID(ctx context.Context, id int64) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (m *Memory) SetRole(ctx context.Context, id int64, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.users {
+		if m.users[i].ID == id {
+			m.users[i].Role = role
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) RehashPassword(ctx context.Context, id int64, was, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.users {
+		if m.users[i].ID == id && m.users[i].PasswordHash == was {
+			m.users[i].PasswordHash = passwordHash
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) CreateAppointment(ctx context.Context, a Appointment) (Appointment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if a.Status == "" {
+		a.Status = StatusBooked
+	}
+	for _, b := range m.appointments {
+		if a.IdempotencyKey != "" && b.UserID == a.UserID && b.IdempotencyKey == a.IdempotencyKey {
+			return Appointment{}, ErrConflict
+		}
+	}
+	if a.Status == StatusBooked {
+		for _, b := range m.appointments {
+			if b.Status == StatusBooked && b.DoctorName == a.DoctorName && b.StartsAt.Equal(a.StartsAt) {
+				return Appointment{}, ErrConflict
+			}
+		}
+	}
+	a.ID = m.id()
+	m.appointments = append(m.appointments, a)
+	return a, nil
+}
+
+func (m *Memory) AppointmentByIdempotencyKey(ctx context.Context, userID int64, key string) (Appointment, error) {
+	found := m.filter(func(a Appointment) bool {
+		return key != "" && a.UserID == userID && a.IdempotencyKey == key
+	})
+	if len(found) == 0 {
+		return Appointment{}, ErrNotFound
+	}
+	return found[0], nil
+}
+
+func (m *Memory) UpcomingAppointments(ctx context.Context, userID int64, from time.Time) ([]Appointment, error) {
+	return m.filter(func(a Appointment) bool {
+		return a.UserID == userID && !a.StartsAt.Before(from)
+	}), nil
+}
+
+func (m *Memory) BookedSlots(ctx context.Context, doctorName string, from, to time.Time) ([]Appointment, error) {
+	return m.filter(func(a Appointment) bool {
+		return a.DoctorName == doctorName && a.Status == StatusBooked &&
+			(from.IsZero() || !a.StartsAt.Before(from)) &&
+			(to.IsZero() || a.StartsAt.Before(to))
+	}), nil
+}
+
+func (m *Memory) DeleteAppointment(ctx context.Context, id int64) (Appointment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, a := range m.appointments {
+		if a.ID == id {
+			m.appointments = append(m.appointments[:i], m.appointments[i+1:]...)
+			return a, nil
+		}
+	}
+	return Appointment{}, ErrNotFound
+}
+
+func (m *Memory) SearchAppointments(ctx context.Context, f AppointmentFilter) ([]Appointment, error) {
+	found := m.filter(func(a Appointment) bool {
+		return (f.UserID == 0 || a.UserID == f.UserID) &&
+			(f.Email == "" || strings.EqualFold(a.Email, f.Email)) &&
+			(f.DoctorName == "" || a.DoctorName == f.DoctorName) &&
+			(f.From.IsZero() || !a.StartsAt.Before(f.From)) &&
+			(f.To.IsZero() || a.StartsAt.Before(f.To)) &&
+			(f.After.ID == 0 || a.StartsAt.After(f.After.StartsAt) || a.StartsAt.Equal(f.After.StartsAt) && a.ID > f.After.ID)
+	})
+	if f.Limit > 0 && len(found) > f.Limit {
+		found = found[:f.Limit]
+	}
+	return found, nil
+}
+
+func (m *Memory) RescheduleAppointment(ctx context.Context, id int64, start time.Time) (Appointment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.appointments, func(a Appointment) bool { return a.ID == id })
+	if i < 0 {
+		return Appointment{}, ErrNotFound
+	}
+	a := m.appointments[i]
+	for _, b := range m.appointments {
+		if b.ID != id && b.Status == StatusBooked && b.DoctorName == a.DoctorName && b.StartsAt.Equal(start) {
+			return Appointment{}, ErrConflict
+		}
+	}
+	m.appointments[i].StartsAt = start
+	return m.appointments[i], nil
+}
+
+func (m *Memory) RecordLoginAttempt(ctx context.Context, a LoginAttempt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if a.AttemptedAt.IsZero() {
+		a.AttemptedAt = time.Now()
+	}
+	m.logins = append(m.logins, a)
+	return nil
+}
+
+func (m *Memory) LoginFailures(ctx context.Context, email, ip string, since time.Time) (LoginFailures, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var f LoginFailures
+	for _, a := range m.logins {
+		if a.AttemptedAt.Before(since) {
+			continue
+		}
+		if a.Email == email {
+			if a.Success {
+				f.Account, f.LastAccount = 0, time.Time{}
+			} else {
+				f.Account++
+				f.LastAccount = a.AttemptedAt
+			}
+		}
+		if a.IP == ip && !a.Success {
+			f.IP++
+			f.LastIP = a.AttemptedAt
+		}
+	}
+	return f, nil
+}
+
+// memoryVerification is an EmailVerification with its use recorded.
+type memoryVerification struct {
+	EmailVerification
+	used bool
+}
+
+func (m *Memory) CreateEmailVerification(ctx context.Context, v EmailVerification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.verifications {
+		if bytes.Equal(e.TokenHash, v.TokenHash) {
+			return ErrConflict
+		}
+	}
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+	m.verifications = append(m.verifications, memoryVerification{EmailVerification: v})
+	return nil
+}
+
+func (m *Memory) EmailVerificationsSince(ctx context.Context, userID int64, since time.Time) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int
+	var last time.Time
+	for _, v := range m.verifications {
+		if v.UserID == userID && !v.CreatedAt.Before(since) {
+			n++
+			if v.CreatedAt.After(last) {
+				last = v.CreatedAt
+			}
+		}
+	}
+	return n, last, nil
+}
+
+func (m *Memory) UseEmailVerification(ctx context.Context, userID int64, tokenHash []byte, now time.Time) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, v := range m.verifications {
+		if v.UserID != userID || !bytes.Equal(v.TokenHash, tokenHash) || v.used || !v.ExpiresAt.After(now) {
+			continue
+		}
+		for j, u := range m.users {
+			if u.ID == userID {
+				m.verifications[i].used = true
+				if m.users[j].VerifiedAt.IsZero() {
+					m.users[j].VerifiedAt = now
+				}
+				return m.users[j], nil
+			}
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (m *Memory) CreateSession(ctx context.Context, s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.sessions {
+		if bytes.Equal(e.TokenHash, s.TokenHash) {
+			return ErrConflict
+		}
+	}
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = time.Now()
+	}
+	m.sessions = append(m.sessions, s)
+	return nil
+}
+
+func (m *Memory) SessionByTokenHash(ctx context.Context, tokenHash []byte, now time.Time) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if !bytes.Equal(s.TokenHash, tokenHash) || !s.ExpiresAt.After(now) {
+			continue
+		}
+		for _, u := range m.users {
+			if u.ID == s.UserID {
+				s.Email = u.Email
+				return s, nil
+			}
+		}
+	}
+	return Session{}, ErrNotFound
+}
+
+func (m *Memory) DeleteSession(ctx context.Context, tokenHash []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions = slices.DeleteFunc(m.sessions, func(s Session) bool { return bytes.Equal(s.TokenHash, tokenHash) })
+	return nil
+}
+
+func (m *Memory) DeleteUserSessions(ctx context.Context, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions = slices.DeleteFunc(m.sessions, func(s Session) bool { return s.UserID == userID })
+	return nil
+}
+
+// memoryReset is a PasswordReset with its use recorded.
+type memoryReset struct {
+	PasswordReset
+	used bool
+}
+
+func (m *Memory) CreatePasswordReset(ctx context.Context, r PasswordReset) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.resets {
+		if bytes.Equal(e.TokenHash, r.TokenHash) {
+			return ErrConflict
+		}
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	m.resets = append(m.resets, memoryReset{PasswordReset: r})
+	return nil
+}
+
+func (m *Memory) PasswordResetsSince(ctx context.Context, userID int64, since time.Time) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int
+	var last time.Time
+	for _, r := range m.resets {
+		if r.UserID == userID && !r.CreatedAt.Before(since) {
+			n++
+			if r.CreatedAt.After(last) {
+				last = r.CreatedAt
+			}
+		}
+	}
+	return n, last, nil
+}
+
+func (m *Memory) ResetPassword(ctx context.Context, tokenHash []byte, passwordHash string, now time.Time) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.resets, func(r memoryReset) bool {
+		return bytes.Equal(r.TokenHash, tokenHash) && !r.used && r.ExpiresAt.After(now)
+	})
+	if i < 0 {
+		return User{}, ErrNotFound
+	}
+	userID := m.resets[i].UserID
+	j := slices.IndexFunc(m.users, func(u User) bool { return u.ID == userID })
+	if j < 0 {
+		return User{}, ErrNotFound
+	}
+
+	for k := range m.resets {
+		if m.resets[k].UserID == userID {
+			m.resets[k].used = true
+		}
+	}
+	m.sessions = slices.DeleteFunc(m.sessions, func(s Session) bool { return s.UserID == userID })
+	m.users[j].PasswordHash = passwordHash
+	if m.users[j].VerifiedAt.IsZero() {
+		m.users[j].VerifiedAt = now
+	}
+	return m.users[j], nil
+}
+
+// memoryRecoveryCode is one hashed recovery code.
+type memoryRecoveryCode struct {
+	userID int64
+	hash   []byte
+	used   bool
+}
+
+func (m *Memory) TOTP(ctx context.Context, userID int64) (TOTP, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.totp[userID]
+	if !ok {
+		return TOTP{}, ErrNotFound
+	}
+	return t, nil
+}
+
+func (m *Memory) StartTOTP(ctx context.Context, userID int64, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.totp[userID]; ok && !t.EnabledAt.IsZero() {
+		return ErrConflict
+	}
+	if m.totp == nil {
+		m.totp = make(map[int64]TOTP)
+	}
+	m.totp[userID] = TOTP{UserID: userID, Secret: secret}
+	return nil
+}
+
+func (m *Memory) EnableTOTP(ctx context.Context, userID int64, step int64, codeHashes [][]byte, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.totp[userID]
+	if !ok || !t.EnabledAt.IsZero() {
+		return ErrNotFound
+	}
+	t.EnabledAt, t.LastStep = now, step
+	m.totp[userID] = t
+	m.recovery = slices.DeleteFunc(m.recovery, func(c memoryRecoveryCode) bool { return c.userID == userID })
+	for _, h := range codeHashes {
+		m.recovery = append(m.recovery, memoryRecoveryCode{userID: userID, hash: h})
+	}
+	return nil
+}
+
+func (m *Memory) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.totp[userID]
+	if !ok || t.EnabledAt.IsZero() || t.LastStep >= step {
+		return ErrConflict
+	}
+	t.LastStep = step
+	m.totp[userID] = t
+	return nil
+}
+
+func (m *Memory) UseRecoveryCode(ctx context.Context, userID int64, codeHash []byte, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, c := range m.recovery {
+		if c.userID == userID && !c.used && bytes.Equal(c.hash, codeHash) {
+			m.recovery[i].used = true
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) DisableTOTP(ctx context.Context, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.totp, userID)
+	m.recovery = slices.DeleteFunc(m.recovery, func(c memoryRecoveryCode) bool { return c.userID == userID })
+	return nil
+}
+
+func (m *Memory) Doctors(ctx context.Context) ([]Doctor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Doctor(nil), m.doctors...), nil
+}
+
+func (m *Memory) DoctorsAfter(ctx context.Context, afterID int64, limit int) ([]Doctor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []Doctor
+	for _, d := range m.doctors {
+		if d.ID > afterID && len(out) < limit {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+func (m *Memory) DoctorByName(ctx context.Context, name string) (Doctor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, d := range m.doctors {
+		if d.Name == name {
+			return d, nil
+		}
+	}
+	return Doctor{}, ErrNotFound
+}
+
+func (m *Memory) CreatePrescription(ctx context.Context, rx Prescription) (Prescription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rx.ID = m.id()
+	if rx.CreatedAt.IsZero() {
+		rx.CreatedAt = time.Now()
+	}
+	m.prescriptions = append(m.prescriptions, rx)
+	return rx, nil
+}
+
+func (m *Memory) PrescriptionByID(ctx context.Context, id int64) (Prescription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, rx := range m.prescriptions {
+		if rx.ID == id {
+			return rx, nil
+		}
+	}
+	return Prescription{}, ErrNotFound
+}
+
+func (m *Memory) Prescriptions(ctx context.Context, userID, beforeID int64, limit int) ([]Prescription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return newestFirst(m.prescriptions, func(rx Prescription) bool {
+		return rx.UserID == userID && (beforeID == 0 || rx.ID < beforeID)
+	}, limit), nil
+}
+
+func (m *Memory) CreatePharmacyOrder(ctx context.Context, o PharmacyOrder) (PharmacyOrder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.orders {
+		if existing.PrescriptionID == o.PrescriptionID {
+			return PharmacyOrder{}, ErrConflict
+		}
+	}
+	o.ID = m.id()
+	o.Status = OrderPending
+	o.Reference = ""
+	if o.CreatedAt.IsZero() {
+		o.CreatedAt = time.Now()
+	}
+	m.orders = append(m.orders, o)
+	return o, nil
+}
+
+func (m *Memory) PharmacyOrderByPrescription(ctx context.Context, prescriptionID int64) (PharmacyOrder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, o := range m.orders {
+		if o.PrescriptionID == prescriptionID {
+			return o, nil
+		}
+	}
+	return PharmacyOrder{}, ErrNotFound
+}
+
+func (m *Memory) PlacePharmacyOrder(ctx context.Context, id int64, reference string) (PharmacyOrder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, o := range m.orders {
+		if o.ID == id {
+			m.orders[i].Status = OrderPlaced
+			m.orders[i].Reference = reference
+			return m.orders[i], nil
+		}
+	}
+	return PharmacyOrder{}, ErrNotFound
+}
+
+func (m *Memory) PharmacyOrders(ctx context.Context, userID, beforeID int64, limit int) ([]PharmacyOrder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return newestFirst(m.orders, func(o PharmacyOrder) bool {
+		return o.UserID == userID && (beforeID == 0 || o.ID < beforeID)
+	}, limit), nil
+}
+
+// newestFirst returns at most limit of the items keep accepts, latest
+// added first. Items are added in ID order, so that is by ID descending.
+func newestFirst[T any](items []T, keep func(T) bool, limit int) []T {
+	var out []T
+	for i := len(items) - 1; i >= 0 && len(out) < limit; i-- {
+		if keep(items[i]) {
+			out = append(out, items[i])
+		}
+	}
+	return out
+}
+
+// filter returns matching appointments ordered by start time, then ID.
+func (m *Memory) filter(keep func(Appointment) bool) []Appointment {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []Appointment
+	for _, a := range m.appointments {
+		if keep(a) {
+			out = append(out, a)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].StartsAt.Equal(out[j].StartsAt) {
+			return out[i].StartsAt.Before(out[j].StartsAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
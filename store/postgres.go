@@ -0,0 +1,639 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Postgres implements every store interface on top of the schema in
+// package migrations.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) CreateUser(ctx context.Context, email, passwordHash string) (User, error) {
+	u := User{Email: email, PasswordHash: passwordHash, Role: RolePatient}
+	err := p.db.QueryRowContext(ctx,
+		"INSERT INTO users (email, password) VALUES ($1, $2) RETURNING id, created_at",
+		email, passwordHash).Scan(&u.ID, &u.CreatedAt)
+	if err != nil {
+		return User{}, translate(err)
+	}
+	return u, nil
+}
+
+func (p *Postgres) UserByEmail(ctx context.Context, email string) (User, error) {
+	return p.queryUser(ctx, "SELECT id, email, password, created_at, email_verified_at, role FROM users WHERE email = $1", email)
+}
+
+func (p *Postgres) UserByID(ctx context.Context, id int64) (User, error) {
+	return p.queryUser(ctx, "SELECT id, email, password, created_at, email_verified_at, role FROM users WHERE id = $1", id)
+}
+
+func (p *Postgres) SetRole(ctx context.Context, id int64, role string) error {
+	res, err := p.db.ExecContext(ctx, "UPDATE users SET role = $2 WHERE id = $1", id, role)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
+
+func (p *Postgres) RehashPassword(ctx context.Context, id int64, was, passwordHash string) error {
+	res, err := p.db.ExecContext(ctx, "UPDATE users SET password = $3 WHERE id = $1 AND password = $2", id, was, passwordHash)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
+
+// UsersWithPlaintextPasswords returns the users whose password was stored
+// before passwords were hashed and has not been rehashed since.
+func (p *Postgres) UsersWithPlaintextPasswords(ctx context.Context) ([]User, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT id, email, password, created_at, email_verified_at, role FROM users
+		WHERE password NOT LIKE '$argon2id$%' ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []User
+	for rows.Next() {
+		var u User
+		var verifiedAt sql.NullTime
+		if err := rows.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.CreatedAt, &verifiedAt, &u.Role); err != nil {
+			return nil, err
+		}
+		u.VerifiedAt = verifiedAt.Time
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (p *Postgres) queryUser(ctx context.Context, query string, args ...any) (User, error) {
+	var u User
+	var verifiedAt sql.NullTime
+	err := p.db.QueryRowContext(ctx, query, args...).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.CreatedAt, &verifiedAt, &u.Role)
+	if err != nil {
+		return User{}, translate(err)
+	}
+	u.VerifiedAt = verifiedAt.Time
+	return u, nil
+}
+
+func (p *Postgres) CreateAppointment(ctx context.Context, a Appointment) (Appointment, error) {
+	if a.Status == "" {
+		a.Status = StatusBooked
+	}
+	err := p.db.QueryRowContext(ctx,
+		"INSERT INTO appointments (doctor_name, user_id, email, starts_at, duration_minutes, status, idempotency_key) VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')) RETURNING id",
+		a.DoctorName, a.UserID, a.Email, a.StartsAt, int(a.Duration/time.Minute), a.Status, a.IdempotencyKey).Scan(&a.ID)
+	if err != nil {
+		return Appointment{}, translate(err)
+	}
+	return a, nil
+}
+
+func (p *Postgres) AppointmentByIdempotencyKey(ctx context.Context, userID int64, key string) (Appointment, error) {
+	found, err := p.queryAppointments(ctx, `
+		SELECT id, doctor_name, user_id, email, starts_at, duration_minutes, status, COALESCE(idempotency_key, '')
+		FROM appointments
+		WHERE user_id = $1 AND idempotency_key = $2`, userID, key)
+	if err != nil {
+		return Appointment{}, err
+	}
+	if len(found) == 0 {
+		return Appointment{}, ErrNotFound
+	}
+	return found[0], nil
+}
+
+func (p *Postgres) UpcomingAppointments(ctx context.Context, userID int64, from time.Time) ([]Appointment, error) {
+	return p.queryAppointments(ctx, `
+		SELECT id, doctor_name, user_id, email, starts_at, duration_minutes, status, COALESCE(idempotency_key, '')
+		FROM appointments
+		WHERE user_id = $1 AND starts_at >= $2
+		ORDER BY starts_at`, userID, from)
+}
+
+func (p *Postgres) BookedSlots(ctx context.Context, doctorName string, from, to time.Time) ([]Appointment, error) {
+	return p.queryAppointments(ctx, `
+		SELECT id, doctor_name, user_id, email, starts_at, duration_minutes, status, COALESCE(idempotency_key, '')
+		FROM appointments
+		WHERE doctor_name = $1 AND status = $2
+			AND ($3::timestamptz IS NULL OR starts_at >= $3)
+			AND ($4::timestamptz IS NULL OR starts_at < $4)
+		ORDER BY starts_at`, doctorName, StatusBooked, nullTime(from), nullTime(to))
+}
+
+func (p *Postgres) DeleteAppointment(ctx context.Context, id int64) (Appointment, error) {
+	deleted, err := p.queryAppointments(ctx, `
+		DELETE FROM appointments
+		WHERE id = $1
+		RETURNING id, doctor_name, user_id, email, starts_at, duration_minutes, status, COALESCE(idempotency_key, '')`, id)
+	if err != nil {
+		return Appointment{}, err
+	}
+	if len(deleted) == 0 {
+		return Appointment{}, ErrNotFound
+	}
+	return deleted[0], nil
+}
+
+func (p *Postgres) SearchAppointments(ctx context.Context, f AppointmentFilter) ([]Appointment, error) {
+	return p.queryAppointments(ctx, `
+		SELECT id, doctor_name, user_id, email, starts_at, duration_minutes, status, COALESCE(idempotency_key, '')
+		FROM appointments
+		WHERE ($1::bigint = 0 OR user_id = $1)
+			AND ($2::text = '' OR lower(email) = lower($2))
+			AND ($3::text = '' OR doctor_name = $3)
+			AND ($4::timestamptz IS NULL OR starts_at >= $4)
+			AND ($5::timestamptz IS NULL OR starts_at < $5)
+			AND ($6::bigint = 0 OR (starts_at, id) > ($7::timestamptz, $6))
+		ORDER BY starts_at, id
+		LIMIT NULLIF($8, 0)`,
+		f.UserID, f.Email, f.DoctorName, nullTime(f.From), nullTime(f.To), f.After.ID, nullTime(f.After.StartsAt), f.Limit)
+}
+
+func (p *Postgres) RescheduleAppointment(ctx context.Context, id int64, start time.Time) (Appointment, error) {
+	moved, err := p.queryAppointments(ctx, `
+		UPDATE appointments SET starts_at = $2
+		WHERE id = $1
+		RETURNING id, doctor_name, user_id, email, starts_at, duration_minutes, status, COALESCE(idempotency_key, '')`, id, start)
+	if err != nil {
+		return Appointment{}, translate(err)
+	}
+	if len(moved) == 0 {
+		return Appointment{}, ErrNotFound
+	}
+	return moved[0], nil
+}
+
+func (p *Postgres) Doctors(ctx context.Context) ([]Doctor, error) {
+	return p.queryDoctors(ctx, "SELECT id, name, specialty, experience, photo_url FROM doctors ORDER BY id")
+}
+
+func (p *Postgres) DoctorsAfter(ctx context.Context, afterID int64, limit int) ([]Doctor, error) {
+	return p.queryDoctors(ctx, `
+		SELECT id, name, specialty, experience, photo_url FROM doctors
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2`, afterID, limit)
+}
+
+func (p *Postgres) queryDoctors(ctx context.Context, query string, args ...any) ([]Doctor, error) {
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var doctors []Doctor
+	for rows.Next() {
+		var d Doctor
+		if err := rows.Scan(&d.ID, &d.Name, &d.Specialty, &d.Experience, &d.PhotoURL); err != nil {
+			return nil, err
+		}
+		doctors = append(doctors, d)
+	}
+	return doctors, rows.Err()
+}
+
+func (p *Postgres) DoctorByName(ctx context.Context, name string) (Doctor, error) {
+	var d Doctor
+	err := p.db.QueryRowContext(ctx,
+		"SELECT id, name, specialty, experience, photo_url FROM doctors WHERE name = $1",
+		name).Scan(&d.ID, &d.Name, &d.Specialty, &d.Experience, &d.PhotoURL)
+	if err != nil {
+		return Doctor{}, translate(err)
+	}
+	return d, nil
+}
+
+func (p *Postgres) RecordLoginAttempt(ctx context.Context, a LoginAttempt) error {
+	_, err := p.db.ExecContext(ctx,
+		"INSERT INTO login_attempts (email, ip, success, attempted_at) VALUES ($1, $2, $3, COALESCE($4, now()))",
+		a.Email, a.IP, a.Success, nullTime(a.AttemptedAt))
+	return err
+}
+
+func (p *Postgres) LoginFailures(ctx context.Context, email, ip string, since time.Time) (LoginFailures, error) {
+	var f LoginFailures
+	var lastAccount, lastIP sql.NullTime
+	err := p.db.QueryRowContext(ctx, `
+		WITH recent AS (
+			SELECT email, ip, success, attempted_at
+			FROM login_attempts
+			WHERE attempted_at >= $3 AND (email = $1 OR ip = $2::inet)
+		), account AS (
+			SELECT attempted_at FROM recent
+			WHERE email = $1 AND NOT success
+				AND attempted_at > COALESCE((SELECT max(attempted_at) FROM recent WHERE email = $1 AND success), '-infinity')
+		)
+		SELECT
+			(SELECT count(*) FROM account),
+			(SELECT max(attempted_at) FROM account),
+			(SELECT count(*) FROM recent WHERE ip = $2::inet AND NOT success),
+			(SELECT max(attempted_at) FROM recent WHERE ip = $2::inet AND NOT success)`,
+		email, ip, since).Scan(&f.Account, &lastAccount, &f.IP, &lastIP)
+	if err != nil {
+		return LoginFailures{}, err
+	}
+	f.LastAccount, f.LastIP = lastAccount.Time, lastIP.Time
+	return f, nil
+}
+
+func (p *Postgres) CreateEmailVerification(ctx context.Context, v EmailVerification) error {
+	_, err := p.db.ExecContext(ctx,
+		"INSERT INTO email_verifications (user_id, token_hash, created_at, expires_at) VALUES ($1, $2, COALESCE($3, now()), $4)",
+		v.UserID, v.TokenHash, nullTime(v.CreatedAt), v.ExpiresAt)
+	return translate(err)
+}
+
+func (p *Postgres) EmailVerificationsSince(ctx context.Context, userID int64, since time.Time) (int, time.Time, error) {
+	var n int
+	var last sql.NullTime
+	err := p.db.QueryRowContext(ctx,
+		"SELECT count(*), max(created_at) FROM email_verifications WHERE user_id = $1 AND created_at >= $2",
+		userID, since).Scan(&n, &last)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return n, last.Time, nil
+}
+
+func (p *Postgres) UseEmailVerification(ctx context.Context, userID int64, tokenHash []byte, now time.Time) (User, error) {
+	// Marking the link used and the user verified in one statement means
+	// two concurrent clicks cannot both succeed.
+	return p.queryUser(ctx, `
+		WITH used AS (
+			UPDATE email_verifications SET used_at = $3
+			WHERE user_id = $1 AND token_hash = $2 AND used_at IS NULL AND expires_at > $3
+			RETURNING user_id
+		)
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, $3)
+		FROM used
+		WHERE users.id = used.user_id
+		RETURNING id, email, password, created_at, email_verified_at, role`, userID, tokenHash, now)
+}
+
+func (p *Postgres) CreateSession(ctx context.Context, s Session) error {
+	_, err := p.db.ExecContext(ctx,
+		"INSERT INTO sessions (user_id, token_hash, created_at, expires_at) VALUES ($1, $2, COALESCE($3, now()), $4)",
+		s.UserID, s.TokenHash, nullTime(s.CreatedAt), s.ExpiresAt)
+	return translate(err)
+}
+
+func (p *Postgres) SessionByTokenHash(ctx context.Context, tokenHash []byte, now time.Time) (Session, error) {
+	s := Session{TokenHash: tokenHash}
+	err := p.db.QueryRowContext(ctx, `
+		SELECT s.user_id, u.email, s.created_at, s.expires_at
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = $1 AND s.expires_at > $2`,
+		tokenHash, now).Scan(&s.UserID, &s.Email, &s.CreatedAt, &s.ExpiresAt)
+	if err != nil {
+		return Session{}, translate(err)
+	}
+	return s, nil
+}
+
+func (p *Postgres) DeleteSession(ctx context.Context, tokenHash []byte) error {
+	_, err := p.db.ExecContext(ctx, "DELETE FROM sessions WHERE token_hash = $1", tokenHash)
+	return err
+}
+
+func (p *Postgres) DeleteUserSessions(ctx context.Context, userID int64) error {
+	_, err := p.db.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = $1", userID)
+	return err
+}
+
+func (p *Postgres) CreatePasswordReset(ctx context.Context, r PasswordReset) error {
+	_, err := p.db.ExecContext(ctx,
+		"INSERT INTO password_resets (user_id, token_hash, created_at, expires_at) VALUES ($1, $2, COALESCE($3, now()), $4)",
+		r.UserID, r.TokenHash, nullTime(r.CreatedAt), r.ExpiresAt)
+	return translate(err)
+}
+
+func (p *Postgres) PasswordResetsSince(ctx context.Context, userID int64, since time.Time) (int, time.Time, error) {
+	var n int
+	var last sql.NullTime
+	err := p.db.QueryRowContext(ctx,
+		"SELECT count(*), max(created_at) FROM password_resets WHERE user_id = $1 AND created_at >= $2",
+		userID, since).Scan(&n, &last)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return n, last.Time, nil
+}
+
+func (p *Postgres) ResetPassword(ctx context.Context, tokenHash []byte, passwordHash string, now time.Time) (User, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	// Locking the reset row makes a second, concurrent use wait and then
+	// find it used.
+	var userID int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT user_id FROM password_resets
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+		FOR UPDATE`, tokenHash, now).Scan(&userID)
+	if err != nil {
+		return User{}, translate(err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE password_resets SET used_at = $2 WHERE user_id = $1 AND used_at IS NULL", userID, now); err != nil {
+		return User{}, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = $1", userID); err != nil {
+		return User{}, err
+	}
+
+	u := User{PasswordHash: passwordHash}
+	var verifiedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, `
+		UPDATE users SET password = $2, email_verified_at = COALESCE(email_verified_at, $3)
+		WHERE id = $1
+		RETURNING id, email, created_at, email_verified_at, role`,
+		userID, passwordHash, now).Scan(&u.ID, &u.Email, &u.CreatedAt, &verifiedAt, &u.Role)
+	if err != nil {
+		return User{}, translate(err)
+	}
+	u.VerifiedAt = verifiedAt.Time
+	return u, tx.Commit()
+}
+
+func (p *Postgres) TOTP(ctx context.Context, userID int64) (TOTP, error) {
+	t := TOTP{UserID: userID}
+	var enabledAt sql.NullTime
+	err := p.db.QueryRowContext(ctx,
+		"SELECT secret, enabled_at, last_step FROM user_totp WHERE user_id = $1",
+		userID).Scan(&t.Secret, &enabledAt, &t.LastStep)
+	if err != nil {
+		return TOTP{}, translate(err)
+	}
+	t.EnabledAt = enabledAt.Time
+	return t, nil
+}
+
+func (p *Postgres) StartTOTP(ctx context.Context, userID int64, secret string) error {
+	res, err := p.db.ExecContext(ctx, `
+		INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0
+		WHERE user_totp.enabled_at IS NULL`, userID, secret)
+	if err != nil {
+		return translate(err)
+	}
+	if err := requireRow(res); errors.Is(err, ErrNotFound) {
+		return ErrConflict
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *Postgres) EnableTOTP(ctx context.Context, userID int64, step int64, codeHashes [][]byte, now time.Time) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE user_totp SET enabled_at = $2, last_step = $3 WHERE user_id = $1 AND enabled_at IS NULL",
+		userID, now, step)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(res); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, h); err != nil {
+			return translate(err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) UseTOTPStep(ctx context.Context, userID int64, step int64) error {
+	res, err := p.db.ExecContext(ctx,
+		"UPDATE user_totp SET last_step = $2 WHERE user_id = $1 AND enabled_at IS NOT NULL AND last_step < $2",
+		userID, step)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(res); errors.Is(err, ErrNotFound) {
+		return ErrConflict
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *Postgres) UseRecoveryCode(ctx context.Context, userID int64, codeHash []byte, now time.Time) error {
+	res, err := p.db.ExecContext(ctx,
+		"UPDATE recovery_codes SET used_at = $3 WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL",
+		userID, codeHash, now)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
+
+func (p *Postgres) DisableTOTP(ctx context.Context, userID int64) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) CreatePrescription(ctx context.Context, rx Prescription) (Prescription, error) {
+	err := p.db.QueryRowContext(ctx, `
+		INSERT INTO prescriptions (user_id, prescriber_id, prescribed_by, medicine, dosage, quantity, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, now()))
+		RETURNING id, created_at`,
+		rx.UserID, rx.PrescriberID, rx.PrescribedBy, rx.Medicine, rx.Dosage, rx.Quantity, nullTime(rx.CreatedAt)).Scan(&rx.ID, &rx.CreatedAt)
+	if err != nil {
+		return Prescription{}, err
+	}
+	return rx, nil
+}
+
+func (p *Postgres) PrescriptionByID(ctx context.Context, id int64) (Prescription, error) {
+	found, err := p.queryPrescriptions(ctx, `
+		SELECT id, user_id, prescriber_id, prescribed_by, medicine, dosage, quantity, created_at
+		FROM prescriptions
+		WHERE id = $1`, id)
+	if err != nil {
+		return Prescription{}, err
+	}
+	if len(found) == 0 {
+		return Prescription{}, ErrNotFound
+	}
+	return found[0], nil
+}
+
+func (p *Postgres) Prescriptions(ctx context.Context, userID, beforeID int64, limit int) ([]Prescription, error) {
+	return p.queryPrescriptions(ctx, `
+		SELECT id, user_id, prescriber_id, prescribed_by, medicine, dosage, quantity, created_at
+		FROM prescriptions
+		WHERE user_id = $1 AND ($2::bigint = 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3`, userID, beforeID, limit)
+}
+
+func (p *Postgres) queryPrescriptions(ctx context.Context, query string, args ...any) ([]Prescription, error) {
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prescriptions []Prescription
+	for rows.Next() {
+		var rx Prescription
+		if err := rows.Scan(&rx.ID, &rx.UserID, &rx.PrescriberID, &rx.PrescribedBy, &rx.Medicine, &rx.Dosage, &rx.Quantity, &rx.CreatedAt); err != nil {
+			return nil, err
+		}
+		prescriptions = append(prescriptions, rx)
+	}
+	return prescriptions, rows.Err()
+}
+
+func (p *Postgres) CreatePharmacyOrder(ctx context.Context, o PharmacyOrder) (PharmacyOrder, error) {
+	o.Status = OrderPending
+	o.Reference = ""
+	err := p.db.QueryRowContext(ctx, `
+		INSERT INTO pharmacy_orders (user_id, prescription_id, status, created_at)
+		VALUES ($1, $2, $3, COALESCE($4, now()))
+		RETURNING id, created_at`,
+		o.UserID, o.PrescriptionID, o.Status, nullTime(o.CreatedAt)).Scan(&o.ID, &o.CreatedAt)
+	if err != nil {
+		return PharmacyOrder{}, translate(err)
+	}
+	return o, nil
+}
+
+func (p *Postgres) PharmacyOrderByPrescription(ctx context.Context, prescriptionID int64) (PharmacyOrder, error) {
+	found, err := p.queryPharmacyOrders(ctx, `
+		SELECT id, user_id, prescription_id, status, reference, created_at
+		FROM pharmacy_orders
+		WHERE prescription_id = $1`, prescriptionID)
+	if err != nil {
+		return PharmacyOrder{}, err
+	}
+	if len(found) == 0 {
+		return PharmacyOrder{}, ErrNotFound
+	}
+	return found[0], nil
+}
+
+func (p *Postgres) PlacePharmacyOrder(ctx context.Context, id int64, reference string) (PharmacyOrder, error) {
+	placed, err := p.queryPharmacyOrders(ctx, `
+		UPDATE pharmacy_orders SET status = $2, reference = $3
+		WHERE id = $1
+		RETURNING id, user_id, prescription_id, status, reference, created_at`, id, OrderPlaced, reference)
+	if err != nil {
+		return PharmacyOrder{}, err
+	}
+	if len(placed) == 0 {
+		return PharmacyOrder{}, ErrNotFound
+	}
+	return placed[0], nil
+}
+
+func (p *Postgres) PharmacyOrders(ctx context.Context, userID, beforeID int64, limit int) ([]PharmacyOrder, error) {
+	return p.queryPharmacyOrders(ctx, `
+		SELECT id, user_id, prescription_id, status, reference, created_at
+		FROM pharmacy_orders
+		WHERE user_id = $1 AND ($2::bigint = 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3`, userID, beforeID, limit)
+}
+
+func (p *Postgres) queryPharmacyOrders(ctx context.Context, query string, args ...any) ([]PharmacyOrder, error) {
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []PharmacyOrder
+	for rows.Next() {
+		var o PharmacyOrder
+		if err := rows.Scan(&o.ID, &o.UserID, &o.PrescriptionID, &o.Status, &o.Reference, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
+func (p *Postgres) queryAppointments(ctx context.Context, query string, args ...any) ([]Appointment, error) {
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var appointments []Appointment
+	for rows.Next() {
+		var a Appointment
+		var minutes int
+		if err := rows.Scan(&a.ID, &a.DoctorName, &a.UserID, &a.Email, &a.StartsAt, &minutes, &a.Status, &a.IdempotencyKey); err != nil {
+			return nil, err
+		}
+		a.Duration = time.Duration(minutes) * time.Minute
+		appointments = append(appointments, a)
+	}
+	return appointments, rows.Err()
+}
+
+// translate maps driver errors onto the store's sentinel errors.
+func translate(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation
+		return ErrConflict
+	}
+	return err
+}
+
+// requireRow returns ErrNotFound if res affected no rows.
+func requireRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
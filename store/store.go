@@ -0,0 +1,361 @@
+// Package store defines the persistence interfaces used by the web tier and
+// the gRPC services, with a Postgres implementation for production and an
+// in-memory one for tests and local experiments.
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the requested record does not exist.
+	ErrNotFound = errors.New("store: not found")
+	// ErrConflict is returned when a write would violate a uniqueness
+	// rule, e.g. a registered email or an already booked slot.
+	ErrConflict = errors.New("store: conflict")
+)
+
+// StatusBooked is the status of an appointment that holds its slot.
+const StatusBooked = "BOOKED"
+
+// Pharmacy order statuses. An order is pending until the pharmacy service
+// accepts it.
+const (
+	OrderPending = "PENDING"
+	OrderPlaced  = "PLACED"
+)
+
+// User roles. Everyone who registers is a patient; staff roles are granted
+// by an administrator.
+const (
+	RolePatient    = "patient"
+	RoleDoctor     = "doctor"
+	RolePharmacist = "pharmacist"
+	RoleAdmin      = "admin"
+)
+
+// Roles lists every role.
+var Roles = []string{RolePatient, RoleDoctor, RolePharmacist, RoleAdmin}
+
+type User struct {
+	ID    int64
+	Email string
+	// PasswordHash is an argon2id hash in the PHC string format. Accounts
+	// created before passwords were hashed hold the plaintext password
+	// until it is rehashed at their next login or by hash-passwords.
+	PasswordHash string
+	CreatedAt    time.Time
+	// VerifiedAt is when the patient proved they own Email; zero until
+	// then.
+	VerifiedAt time.Time
+	Role       string
+}
+
+type Appointment struct {
+	ID         int64
+	DoctorName string
+	UserID     int64
+	Email      string
+	StartsAt   time.Time
+	Duration   time.Duration
+	Status     string
+	// IdempotencyKey, when set, is unique among the patient's
+	// appointments and identifies the request that booked it.
+	IdempotencyKey string
+}
+
+// AppointmentFilter selects appointments for SearchAppointments. Zero
+// fields match every appointment.
+type AppointmentFilter struct {
+	UserID int64
+	// Email matches regardless of case.
+	Email      string
+	DoctorName string
+	// From and To bound the start time to [From, To).
+	From, To time.Time
+	// After, if its ID is set, skips the matches up to and including that
+	// position, and Limit, if not zero, caps how many are returned.
+	After AppointmentCursor
+	Limit int
+}
+
+// AppointmentCursor is a position in a listing of appointments, which are
+// ordered by start time and then ID.
+type AppointmentCursor struct {
+	StartsAt time.Time
+	ID       int64
+}
+
+// CursorOf returns the position of a in a listing.
+func CursorOf(a Appointment) AppointmentCursor {
+	return AppointmentCursor{StartsAt: a.StartsAt, ID: a.ID}
+}
+
+// String encodes c for a page token; ParseAppointmentCursor decodes it.
+func (c AppointmentCursor) String() string {
+	return strconv.FormatInt(c.StartsAt.UnixNano(), 10) + "." + strconv.FormatInt(c.ID, 10)
+}
+
+func ParseAppointmentCursor(s string) (AppointmentCursor, error) {
+	nanos, id, ok := strings.Cut(s, ".")
+	n, err1 := strconv.ParseInt(nanos, 10, 64)
+	i, err2 := strconv.ParseInt(id, 10, 64)
+	if !ok || err1 != nil || err2 != nil || i <= 0 {
+		return AppointmentCursor{}, errors.New("malformed appointment cursor")
+	}
+	return AppointmentCursor{StartsAt: time.Unix(0, n), ID: i}, nil
+}
+
+type Doctor struct {
+	ID         int64
+	Name       string
+	Specialty  string
+	Experience string
+	PhotoURL   string
+}
+
+// Prescription is medicine a doctor prescribed a patient. PrescribedBy is
+// the doctor's email when they wrote it.
+type Prescription struct {
+	ID           int64
+	UserID       int64
+	PrescriberID int64
+	PrescribedBy string
+	Medicine     string
+	Dosage       string
+	Quantity     int
+	CreatedAt    time.Time
+}
+
+// PharmacyOrder is a patient's order of a prescription from the pharmacy.
+// Reference is the pharmacy's, once it has accepted the order.
+type PharmacyOrder struct {
+	ID             int64
+	UserID         int64
+	PrescriptionID int64
+	Status         string
+	Reference      string
+	CreatedAt      time.Time
+}
+
+// EmailVerification is one verification link sent to a user. Only a hash
+// of the link's secret part is kept.
+type EmailVerification struct {
+	UserID    int64
+	TokenHash []byte
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Session is a logged-in browser. Only a hash of the token in its cookie
+// is kept.
+type Session struct {
+	TokenHash []byte
+	UserID    int64
+	// Email is the user's, filled in by SessionByTokenHash.
+	Email     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// PasswordReset is one password reset link sent to a user. Only a hash of
+// its token is kept.
+type PasswordReset struct {
+	UserID    int64
+	TokenHash []byte
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// TOTP is a user's two-factor authentication secret.
+type TOTP struct {
+	UserID int64
+	Secret string
+	// EnabledAt is when the user confirmed enrolment with a code; zero
+	// while enrolment is pending.
+	EnabledAt time.Time
+	// LastStep is the time step of the last code accepted.
+	LastStep int64
+}
+
+// LoginAttempt is one try at logging in, successful or not.
+type LoginAttempt struct {
+	Email       string
+	IP          string
+	Success     bool
+	AttemptedAt time.Time
+}
+
+// LoginFailures summarises recent failed logins for one email and one IP.
+type LoginFailures struct {
+	// Account counts failures for the email since its last successful
+	// login, and LastAccount is the latest of them.
+	Account     int
+	LastAccount time.Time
+	// IP counts failures from the address, whichever email they were for.
+	IP     int
+	LastIP time.Time
+}
+
+type UserStore interface {
+	// CreateUser returns ErrConflict if the email is already registered.
+	CreateUser(ctx context.Context, email, passwordHash string) (User, error)
+	UserByEmail(ctx context.Context, email string) (User, error)
+	UserByID(ctx context.Context, id int64) (User, error)
+	// SetRole returns ErrNotFound if there is no such user.
+	SetRole(ctx context.Context, id int64, role string) error
+	// RehashPassword sets the user's password hash to passwordHash if
+	// the stored value, a plaintext password or an older hash, is still
+	// was. It returns ErrNotFound if there is no such user or the password
+	// changed in the meantime.
+	RehashPassword(ctx context.Context, id int64, was, passwordHash string) error
+}
+
+type AppointmentStore interface {
+	// CreateAppointment fills in the ID and returns ErrConflict if the
+	// doctor's slot is already booked or the patient already used the
+	// idempotency key.
+	CreateAppointment(ctx context.Context, a Appointment) (Appointment, error)
+	// AppointmentByIdempotencyKey finds the patient's appointment booked
+	// with key.
+	AppointmentByIdempotencyKey(ctx context.Context, userID int64, key string) (Appointment, error)
+	// UpcomingAppointments lists a patient's appointments starting at or
+	// after from, earliest first.
+	UpcomingAppointments(ctx context.Context, userID int64, from time.Time) ([]Appointment, error)
+	// BookedSlots lists a doctor's booked appointments starting in
+	// [from, to), earliest first. A zero bound is open-ended.
+	BookedSlots(ctx context.Context, doctorName string, from, to time.Time) ([]Appointment, error)
+	// DeleteAppointment returns the appointment it deleted.
+	DeleteAppointment(ctx context.Context, id int64) (Appointment, error)
+	// SearchAppointments lists the appointments matching f, earliest
+	// first.
+	SearchAppointments(ctx context.Context, f AppointmentFilter) ([]Appointment, error)
+	// RescheduleAppointment moves the appointment with id to start and
+	// returns it. It returns ErrNotFound if there is no such appointment
+	// and ErrConflict if the doctor's new slot is already booked.
+	RescheduleAppointment(ctx context.Context, id int64, start time.Time) (Appointment, error)
+}
+
+type LoginAttemptStore interface {
+	// RecordLoginAttempt stores a; a zero AttemptedAt means now.
+	RecordLoginAttempt(ctx context.Context, a LoginAttempt) error
+	// LoginFailures counts failed attempts made at or after since.
+	LoginFailures(ctx context.Context, email, ip string, since time.Time) (LoginFailures, error)
+}
+
+type EmailVerificationStore interface {
+	// CreateEmailVerification stores v; a zero CreatedAt means now.
+	CreateEmailVerification(ctx context.Context, v EmailVerification) error
+	// EmailVerificationsSince counts the links sent to the user at or
+	// after since and returns when the latest was sent.
+	EmailVerificationsSince(ctx context.Context, userID int64, since time.Time) (int, time.Time, error)
+	// UseEmailVerification marks the user's link with tokenHash used and
+	// the user verified. It returns ErrNotFound if there is no such link
+	// or it has expired or already been used.
+	UseEmailVerification(ctx context.Context, userID int64, tokenHash []byte, now time.Time) (User, error)
+}
+
+type SessionStore interface {
+	// CreateSession stores s; a zero CreatedAt means now.
+	CreateSession(ctx context.Context, s Session) error
+	// SessionByTokenHash returns the session with tokenHash, or
+	// ErrNotFound if there is none or it expired before now.
+	SessionByTokenHash(ctx context.Context, tokenHash []byte, now time.Time) (Session, error)
+	// DeleteSession ends the session with tokenHash, if any.
+	DeleteSession(ctx context.Context, tokenHash []byte) error
+	// DeleteUserSessions ends every session of the user.
+	DeleteUserSessions(ctx context.Context, userID int64) error
+}
+
+type PasswordResetStore interface {
+	// CreatePasswordReset stores r; a zero CreatedAt means now.
+	CreatePasswordReset(ctx context.Context, r PasswordReset) error
+	// PasswordResetsSince counts the resets sent to the user at or after
+	// since and returns when the latest was sent.
+	PasswordResetsSince(ctx context.Context, userID int64, since time.Time) (int, time.Time, error)
+	// ResetPassword sets the password hash of the user whose unused reset,
+	// unexpired at now, has tokenHash. It also uses up the user's other
+	// resets, ends all their sessions and, since they proved they read
+	// their email, marks it verified. It returns ErrNotFound if there is
+	// no such reset.
+	ResetPassword(ctx context.Context, tokenHash []byte, passwordHash string, now time.Time) (User, error)
+}
+
+type TwoFactorStore interface {
+	// TOTP returns the user's secret, or ErrNotFound if they have none.
+	TOTP(ctx context.Context, userID int64) (TOTP, error)
+	// StartTOTP stores a pending secret for the user, replacing any
+	// pending one. It returns ErrConflict if two-factor authentication
+	// is already enabled.
+	StartTOTP(ctx context.Context, userID int64, secret string) error
+	// EnableTOTP confirms the pending secret, recording step as used, and
+	// replaces the user's recovery codes with codeHashes. It returns
+	// ErrNotFound if no enrolment is pending.
+	EnableTOTP(ctx context.Context, userID int64, step int64, codeHashes [][]byte, now time.Time) error
+	// UseTOTPStep records a code of step as used. It returns ErrConflict
+	// if a code of that step or a later one already was.
+	UseTOTPStep(ctx context.Context, userID int64, step int64) error
+	// UseRecoveryCode marks the user's unused recovery code with codeHash
+	// used, or returns ErrNotFound.
+	UseRecoveryCode(ctx context.Context, userID int64, codeHash []byte, now time.Time) error
+	// DisableTOTP removes the user's secret and recovery codes.
+	DisableTOTP(ctx context.Context, userID int64) error
+}
+
+type DoctorStore interface {
+	Doctors(ctx context.Context) ([]Doctor, error)
+	// DoctorsAfter lists at most limit doctors with IDs above afterID,
+	// in ID order.
+	DoctorsAfter(ctx context.Context, afterID int64, limit int) ([]Doctor, error)
+	DoctorByName(ctx context.Context, name string) (Doctor, error)
+}
+
+type PrescriptionStore interface {
+	// CreatePrescription fills in the ID; a zero CreatedAt means now.
+	CreatePrescription(ctx context.Context, p Prescription) (Prescription, error)
+	PrescriptionByID(ctx context.Context, id int64) (Prescription, error)
+	// Prescriptions lists at most limit of the patient's prescriptions
+	// with IDs below beforeID, or all of them if it is zero, newest first.
+	Prescriptions(ctx context.Context, userID, beforeID int64, limit int) ([]Prescription, error)
+}
+
+type PharmacyOrderStore interface {
+	// CreatePharmacyOrder stores o as pending and fills in the ID. It
+	// returns ErrConflict if the prescription was already ordered.
+	CreatePharmacyOrder(ctx context.Context, o PharmacyOrder) (PharmacyOrder, error)
+	PharmacyOrderByPrescription(ctx context.Context, prescriptionID int64) (PharmacyOrder, error)
+	// PlacePharmacyOrder marks the order placed with the pharmacy's
+	// reference and returns it.
+	PlacePharmacyOrder(ctx context.Context, id int64, reference string) (PharmacyOrder, error)
+	// PharmacyOrders lists at most limit of the patient's orders with IDs
+	// below beforeID, or all of them if it is zero, newest first.
+	PharmacyOrders(ctx context.Context, userID, beforeID int64, limit int) ([]PharmacyOrder, error)
+}
+
+// Compile-time checks that both implementations satisfy every interface.
+var (
+	_ UserStore              = (*Postgres)(nil)
+	_ AppointmentStore       = (*Postgres)(nil)
+	_ DoctorStore            = (*Postgres)(nil)
+	_ LoginAttemptStore      = (*Postgres)(nil)
+	_ EmailVerificationStore = (*Postgres)(nil)
+	_ SessionStore           = (*Postgres)(nil)
+	_ PasswordResetStore     = (*Postgres)(nil)
+	_ TwoFactorStore         = (*Postgres)(nil)
+	_ PrescriptionStore      = (*Postgres)(nil)
+	_ PharmacyOrderStore     = (*Postgres)(nil)
+	_ UserStore              = (*Memory)(nil)
+	_ AppointmentStore       = (*Memory)(nil)
+	_ DoctorStore            = (*Memory)(nil)
+	_ LoginAttemptStore      = (*Memory)(nil)
+	_ EmailVerificationStore = (*Memory)(nil)
+	_ SessionStore           = (*Memory)(nil)
+	_ PasswordResetStore     = (*Memory)(nil)
+	_ TwoFactorStore         = (*Memory)(nil)
+	_ PrescriptionStore      = (*Memory)(nil)
+	_ PharmacyOrderStore     = (*Memory)(nil)
+)
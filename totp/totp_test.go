@@ -0,0 +1,83 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of RFC 6238 appendix B, "12345678901234567890",
+// in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// The RFC lists 8-digit codes; ours are their last 6 digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil || got != tt.want {
+			t.Errorf("Code at %d = %q, %v, want %q", tt.unix, got, err, tt.want)
+		}
+	}
+	if got, err := Code(strings.ToLower(rfcSecret), Step(time.Unix(59, 0))); err != nil || got != "287082" {
+		t.Errorf("Code with a lower-case secret = %q, %v, want %q", got, err, "287082")
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code with an invalid secret succeeded")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	tests := []struct {
+		name string
+		code string
+		step int64 // the step accepted, or 0 if refused
+	}{
+		{"current step", code(current), current},
+		{"previous step", code(current - 1), current - 1},
+		{"next step", code(current + 1), current + 1},
+		{"two steps ago", code(current - 2), 0},
+		{"two steps ahead", code(current + 2), 0},
+		{"with a space", code(current)[:3] + " " + code(current)[3:], current},
+		{"too short", code(current)[:5], 0},
+		{"wrong", "000000", 0},
+	}
+	for _, tt := range tests {
+		step, ok := Validate(rfcSecret, tt.code, now)
+		if ok != (tt.step != 0) || step != tt.step {
+			t.Errorf("%s: Validate(%q) = %d, %v, want step %d", tt.name, tt.code, step, ok, tt.step)
+		}
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	a, b := NewSecret(), NewSecret()
+	if a == b {
+		t.Error("two secrets are the same")
+	}
+	if _, err := Code(a, 1); err != nil {
+		t.Errorf("Code with a new secret: %v", err)
+	}
+	if uri := URI("Hospital", "ann@example.com", a); !strings.HasPrefix(uri, "otpauth://totp/Hospital:ann@example.com?") || !strings.Contains(uri, "secret="+a) {
+		t.Errorf("URI = %q", uri)
+	}
+}